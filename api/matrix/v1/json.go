@@ -0,0 +1,195 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// JSON encoding of the populated matrices:
+//
+// - the fields are in snake_case, and the computed fields are only
+//   present when they have been computed. They are not part of the
+//   configuration, the *Export types encode them,
+// - the timestamps are RFC 3339 strings, or null when unknown,
+// - the durations are integers in nanoseconds, with a `_ns` suffix,
+// - the message types are "info", "warning", "error" and "flake",
+// - the back-references (TestSpec.Matrix, TestResult.TestSpec) are
+//   not encoded, and the builds referenced by the regressions and the
+//   failure clusters are encoded by build id and Prow name.
+
+var testMessageTypeNames = map[TestMessageType]string{
+	TestMessageTypeInfo: "info",
+	TestMessageTypeWarning: "warning",
+	TestMessageTypeError: "error",
+	TestMessageTypeFlake: "flake",
+}
+
+func (t TestMessageType) MarshalText() ([]byte, error) {
+	name, ok := testMessageTypeNames[t]
+	if !ok {
+		return nil, fmt.Errorf("unknown message type %d", int64(t))
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText accepts the JSON names of the message types, and the
+// names of their artifacts directories.
+func (t *TestMessageType) UnmarshalText(text []byte) error {
+	for message_type, name := range testMessageTypeNames {
+		if string(text) == name || string(text) == message_type.String() {
+			*t = message_type
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown message type '%s'", string(text))
+}
+
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	if !t.Valid {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.Time.Format(time.RFC3339))
+}
+
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*t = Timestamp{}
+		return nil
+	}
+
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return fmt.Errorf("error parsing the timestamp '%s': %v", value, err)
+	}
+	*t = NewTimestamp(parsed)
+
+	return nil
+}
+
+// testResultFields has the fields of TestResult, without its methods.
+type testResultFields TestResult
+
+// MarshalJSON encodes the fields of the test result and its status.
+func (test TestResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		testResultFields
+		Status string `json:"status"`
+	}{testResultFields(test), test.Status()})
+}
+
+// BuildRef identifies a build in the JSON encoding.
+type BuildRef struct {
+	ProwName string `json:"prow_name,omitempty"`
+	BuildId string `json:"build_id"`
+}
+
+func newBuildRef(test_result *TestResult) *BuildRef {
+	if test_result == nil {
+		return nil
+	}
+
+	ref := &BuildRef{BuildId: test_result.BuildId}
+	if test_result.TestSpec != nil {
+		ref.ProwName = test_result.TestSpec.ProwName
+	}
+	return ref
+}
+
+type regressionFields Regression
+
+// MarshalJSON encodes the builds of the regression by reference. The
+// regressed test is not encoded, as the regression is encoded in it.
+func (regression Regression) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		regressionFields
+		LastGood *BuildRef `json:"last_good,omitempty"`
+		FirstBad *BuildRef `json:"first_bad,omitempty"`
+	}{regressionFields(regression), newBuildRef(regression.LastGood), newBuildRef(regression.FirstBad)})
+}
+
+type failureClusterFields FailureCluster
+
+// MarshalJSON encodes the tests of the cluster by Prow name, and its
+// builds by reference.
+func (cluster FailureCluster) MarshalJSON() ([]byte, error) {
+	prow_names := []string{}
+	for _, test := range cluster.Tests {
+		prow_names = append(prow_names, test.ProwName)
+	}
+
+	return json.Marshal(struct {
+		failureClusterFields
+		Tests []string `json:"tests"`
+		FirstSeen *BuildRef `json:"first_seen,omitempty"`
+		LastSeen *BuildRef `json:"last_seen,omitempty"`
+	}{failureClusterFields(cluster), prow_names, newBuildRef(cluster.FirstSeen), newBuildRef(cluster.LastSeen)})
+}
+
+// TestExport is the JSON export of a populated test: its configuration
+// and the fields computed from its builds.
+type TestExport struct {
+	*TestSpec
+	OldTests []*TestResult `json:"old_tests,omitempty"`
+	Regression *Regression `json:"regression,omitempty"`
+	Stats *TestStats `json:"stats,omitempty"`
+	HistoryStats *TestStats `json:"history_stats,omitempty"`
+}
+
+func NewTestExport(test *TestSpec) TestExport {
+	return TestExport{
+		TestSpec: test,
+		OldTests: test.OldTests,
+		Regression: test.Regression,
+		Stats: test.Stats,
+		HistoryStats: test.HistoryStats,
+	}
+}
+
+// MatrixExport is the JSON export of a populated matrix.
+type MatrixExport struct {
+	*MatrixSpec
+	Tests map[string][]TestExport `json:"tests,omitempty"`
+}
+
+// MatricesExport is the JSON export of the populated matrices. The
+// computed fields of the specifications are not part of the
+// configuration, they are only encoded here.
+type MatricesExport struct {
+	GenerationTime Timestamp `json:"generation_time"`
+	*MatricesSpec
+	FailureClusters []*FailureCluster `json:"failure_clusters,omitempty"`
+	Stats map[string]*MatrixStats `json:"stats,omitempty"`
+	HistorySince Timestamp `json:"history_since"`
+	Matrices map[string]MatrixExport `json:"matrices,omitempty"`
+}
+
+func NewMatricesExport(matrices_spec *MatricesSpec, generation_time time.Time) *MatricesExport {
+	export := &MatricesExport{
+		GenerationTime: NewTimestamp(generation_time),
+		MatricesSpec: matrices_spec,
+		FailureClusters: matrices_spec.FailureClusters,
+		Stats: matrices_spec.Stats,
+		HistorySince: matrices_spec.HistorySince,
+		Matrices: map[string]MatrixExport{},
+	}
+
+	for matrix_name, test_matrix := range matrices_spec.Matrices {
+		test_matrix := test_matrix
+		matrix_export := MatrixExport{MatrixSpec: &test_matrix, Tests: map[string][]TestExport{}}
+		for test_group, tests := range test_matrix.Tests {
+			matrix_export.Tests[test_group] = []TestExport{}
+			for test_idx := range tests {
+				matrix_export.Tests[test_group] = append(matrix_export.Tests[test_group],
+					NewTestExport(&tests[test_idx]))
+			}
+		}
+		export.Matrices[matrix_name] = matrix_export
+	}
+
+	return export
+}
@@ -0,0 +1,118 @@
+package v1
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatricesExport(t *testing.T) {
+	finish_time := time.Date(2020, 9, 15, 12, 26, 40, 0, time.UTC)
+
+	test_matrix := MatrixSpec{Name: "nightly"}
+	test := TestSpec{ProwName: "periodic-e2e", Matrix: &test_matrix}
+	failed := &TestResult{
+		TestSpec: &test,
+		BuildId: "1002",
+		Result: "FAILURE",
+		FinishTime: NewTimestamp(finish_time),
+		Duration: 220 * time.Minute,
+		Messages: map[TestMessageType]map[string]string{
+			TestMessageTypeFlake: {"install": "known flake"},
+		},
+		Classification: &FailureClassification{Rule: "cluster-login", Category: FailureCategoryInfraFlake},
+	}
+	passed := &TestResult{TestSpec: &test, BuildId: "1001", Passed: true, Result: "SUCCESS"}
+	test.OldTests = []*TestResult{failed, passed}
+	test.Regression = &Regression{Test: &test, Status: RegressionStatusRegressed,
+		LastGood: passed, FirstBad: failed, FailedBuilds: 1}
+	test.Stats = &TestStats{Builds: 2, Passed: 1, Flakes: 1}
+	test_matrix.Tests = map[string][]TestSpec{"main": {test}}
+
+	matrices_spec := &MatricesSpec{
+		Matrices: map[string]MatrixSpec{"nightly": test_matrix},
+		FailureClusters: []*FailureCluster{{Signature: "step failed", Tests: []*TestSpec{&test},
+			FirstSeen: failed, LastSeen: failed, Occurrences: 1}},
+		Stats: map[string]*MatrixStats{"nightly": {TestStats: *test.Stats}},
+	}
+
+	content, err := json.Marshal(NewMatricesExport(matrices_spec, finish_time.Add(time.Hour)))
+	if err != nil {
+		t.Fatalf("failed to export the matrices: %v", err)
+	}
+
+	for _, expected := range []string{
+		`"generation_time":"2020-09-15T13:26:40Z"`,
+		`"finish_time":"2020-09-15T12:26:40Z"`,
+		`"start_time":null`,
+		`"duration_ns":13200000000000`,
+		`"messages":{"flake":{"install":"known flake"}}`,
+		`"classification":{"rule":"cluster-login","category":"infra_flake"`,
+		`"status":"known_flake"`,
+		`"status":"success"`,
+		`"first_bad":{"prow_name":"periodic-e2e","build_id":"1002"}`,
+		`"tests":["periodic-e2e"]`,
+		`"last_seen":{"prow_name":"periodic-e2e","build_id":"1002"}`,
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("JSON export does not contain %q", expected)
+		}
+	}
+
+	exported := MatricesExport{}
+	if err = json.Unmarshal(content, &exported); err != nil {
+		t.Fatalf("invalid JSON export: %v", err)
+	}
+	tests := exported.Matrices["nightly"].Tests["main"]
+	if len(tests) != 1 || len(tests[0].OldTests) != 2 {
+		t.Fatalf("unexpected tests in the JSON export: %+v", tests)
+	}
+	build := tests[0].OldTests[0]
+	if build.BuildId != "1002" || !build.FinishTime.Time.Equal(finish_time) || build.StartTime.Valid ||
+		build.Duration != 220 * time.Minute || build.Messages[TestMessageTypeFlake]["install"] != "known flake" {
+		t.Errorf("the build was not exported correctly: %+v", build)
+	}
+	if tests[0].Regression == nil || tests[0].Regression.Status != RegressionStatusRegressed ||
+		tests[0].Stats == nil || tests[0].Stats.Builds != 2 {
+		t.Errorf("the regression or the statistics of the test were not exported: %+v", tests[0])
+	}
+	if len(exported.FailureClusters) != 1 || exported.Stats["nightly"] == nil {
+		t.Errorf("the failure clusters or the statistics are not part of the JSON export")
+	}
+}
+
+func TestComputedFieldsNotInConfiguration(t *testing.T) {
+	test_matrix := MatrixSpec{Name: "nightly"}
+	test := TestSpec{
+		ProwName: "periodic-e2e",
+		OldTests: []*TestResult{{BuildId: "1002"}},
+		Stats: &TestStats{Builds: 1},
+	}
+	test_matrix.Tests = map[string][]TestSpec{"main": {test}}
+	matrices_spec := &MatricesSpec{
+		Matrices: map[string]MatrixSpec{"nightly": test_matrix},
+		FailureClusters: []*FailureCluster{{Signature: "install failed"}},
+	}
+
+	content, err := json.Marshal(matrices_spec)
+	if err != nil {
+		t.Fatalf("cannot encode the configuration: %v", err)
+	}
+	for _, field := range []string{"old_tests", "stats", "failure_clusters", "history_since"} {
+		if strings.Contains(string(content), "\""+field+"\"") {
+			t.Errorf("the computed field %q is part of the configuration: %s", field, content)
+		}
+	}
+
+	config := []byte(`{"matrices": {"nightly": {"tests": {"main": [{"prow_name": "periodic-e2e",
+		"old_tests": [{"build_id": "1002"}], "stats": {"builds": 1}}]}}}}`)
+	parsed := MatricesSpec{}
+	if err = json.Unmarshal(config, &parsed); err != nil {
+		t.Fatalf("cannot parse the configuration: %v", err)
+	}
+	parsed_test := parsed.Matrices["nightly"].Tests["main"][0]
+	if parsed_test.ProwName != "periodic-e2e" || parsed_test.OldTests != nil || parsed_test.Stats != nil {
+		t.Errorf("computed fields were parsed from the configuration: %+v", parsed_test)
+	}
+}
@@ -17,6 +17,11 @@
 
 package v1
 
+import (
+	"strings"
+	"time"
+)
+
 const Version = "v1"
 
 type TestMessageType int64
@@ -42,57 +47,334 @@ func (t TestMessageType) String() string {
 	return "unknown"
 }
 
+// DateFormat is the default layout of the dates.
+const DateFormat = "2006-01-02 15:04"
+
+// Timestamp is a point in time, which is unknown when not Valid.
+type Timestamp struct {
+	Time time.Time
+	Valid bool
+}
+
+func NewTimestamp(t time.Time) Timestamp {
+	return Timestamp{Time: t, Valid: true}
+}
+
+// Format formats the timestamp with `layout`, or returns "N/A" if it
+// is unknown.
+func (t Timestamp) Format(layout string) string {
+	if !t.Valid {
+		return "N/A"
+	}
+	return t.Time.Format(layout)
+}
+
+func (t Timestamp) String() string {
+	return t.Format(DateFormat)
+}
+
+// Sub returns the duration t-u, or 0 if one of the timestamps is
+// unknown.
+func (t Timestamp) Sub(u Timestamp) time.Duration {
+	if !t.Valid || !u.Valid {
+		return 0
+	}
+	return t.Time.Sub(u.Time)
+}
+
 type MatricesSpec struct {
 	Version string                 `json:"version"`
 	Description string             `json:"description,omitempty"`
 	TestHistory int                `json:"test_history"`
+	Concurrency int                `json:"concurrency,omitempty"`
+	HTTP *HTTPSpec                 `json:"http,omitempty"`
+	Cache *CacheSpec               `json:"cache,omitempty"`
+	FailureRules []FailureRule     `json:"failure_rules,omitempty"`
+
+	// computed from the test results, not part of the configuration:
+	// only encoded in the MatricesExport
+	FailureClusters []*FailureCluster `json:"-"`
+	// tests which started to fail
+	Regressions []*Regression `json:"-"`
+	// statistics of the matrices, indexed by name
+	Stats map[string]*MatrixStats `json:"-"`
+	// tests with failed builds, least reliable first
+	UnreliableTests []*TestSpec `json:"-"`
+	// start of the window of the TestSpec.HistoryStats, not Valid if
+	// the history database is not used
+	HistorySince Timestamp `json:"-"`
 	Matrices map[string]MatrixSpec `json:"matrices,omitempty"`
 }
 
+// HTTPSpec configures the HTTP requests fetching the artifacts.
+// Durations use the Go syntax, e.g. "30s" or "1m30s".
+type HTTPSpec struct {
+	Timeout string        `json:"timeout,omitempty"`
+	Retries *int          `json:"retries,omitempty"`
+	BackoffInitial string `json:"backoff_initial,omitempty"`
+	BackoffMax string     `json:"backoff_max,omitempty"`
+}
+
+const (
+	FailureCategoryInfraFlake = "infra_flake"
+	FailureCategoryProductBug = "product_bug"
+	FailureCategoryTestBug    = "test_bug"
+)
+
+// sources of the text matched by the failure rules
+const (
+	FailureSourceBuildLog = "build_log"
+	FailureSourceFailure  = "failure"
+	FailureSourceAnsible  = "ansible"
+	FailureSourceJUnit    = "junit"
+)
+
+// CacheSpec configures the expiration of the mutable entries of the
+// artifacts cache (job listings, running builds), and the eviction of
+// its old entries.
+type CacheSpec struct {
+	MutableTTL string `json:"mutable_ttl,omitempty"`
+	MaxAge string     `json:"max_age,omitempty"`
+	MaxSizeMB int64   `json:"max_size_mb,omitempty"`
+}
+
+// FailureRule classifies the failed builds whose artifacts match a
+// regular expression.
+type FailureRule struct {
+	Name string        `json:"name"`
+	Category string    `json:"category"`
+	Pattern string     `json:"pattern"`
+	// where the pattern is searched, everywhere if empty
+	Sources []string   `json:"sources,omitempty"`
+	Ticket string      `json:"ticket,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// FailureCluster groups the failed builds with the same failure
+// signature.
+type FailureCluster struct {
+	Signature string `json:"signature"`
+	// short identifier of the signature
+	Id string `json:"id"`
+	// tests with a build failing with the signature
+	Tests []*TestSpec `json:"-"`
+	// oldest and newest builds failing with the signature
+	FirstSeen *TestResult `json:"-"`
+	LastSeen *TestResult `json:"-"`
+	Occurrences int `json:"occurrences"`
+}
+
+const (
+	// the last conclusive build passed
+	RegressionStatusPassing = "passing"
+	// the last conclusive builds failed after a passing one
+	RegressionStatusRegressed = "regressed"
+	// all the conclusive builds of the history failed
+	RegressionStatusFailing = "failing"
+	// no conclusive build in the history
+	RegressionStatusUnknown = "unknown"
+)
+
+// VersionChange is a version which differs between the last good and
+// the first bad build of a regression.
+type VersionChange struct {
+	Name string `json:"name"`
+	LastGood string `json:"last_good"`
+	FirstBad string `json:"first_bad"`
+}
+
+// Regression describes the transition of a test from passing to
+// failing. The builds which failed because of a fetch error, a known
+// flake or an infrastructure flake are not conclusive, and are
+// ignored.
+type Regression struct {
+	Test *TestSpec `json:"-"`
+	Status string `json:"status"`
+
+	// newest passing build before the failures, nil if unknown
+	LastGood *TestResult `json:"-"`
+	// oldest failed build after LastGood
+	FirstBad *TestResult `json:"-"`
+	// number of conclusive failed builds since LastGood
+	FailedBuilds int `json:"failed_builds"`
+
+	VersionChanges []VersionChange `json:"version_changes,omitempty"`
+}
+
+// TestStats are the reliability statistics of the finished builds of
+// the history of one or several tests. The builds still running or
+// which could not be fetched are not counted.
+type TestStats struct {
+	Builds int `json:"builds"`
+	Passed int `json:"passed"`
+	// failed builds, except the flakes
+	Failed int `json:"failed"`
+	// builds which failed because of a known flake or of the
+	// infrastructure
+	Flakes int `json:"flakes"`
+
+	// fractions of the builds, between 0 and 1
+	PassRate float64 `json:"pass_rate"`
+	FlakeRate float64 `json:"flake_rate"`
+
+	// number of builds which failed since the last passing one (the
+	// longest streak of the tests, for the groups and matrices)
+	FailureStreak int `json:"failure_streak"`
+	// number of transitions between passing and failing
+	Flips int `json:"flips"`
+	// average time between two failures (flakes included), 0 if less
+	// than two builds failed
+	MeanTimeBetweenFailures time.Duration `json:"mean_time_between_failures_ns"`
+	// MeanTimeBetweenFailures, for the consumers of the JSON reports
+	MeanTimeBetweenFailuresSeconds float64 `json:"mean_time_between_failures_seconds"`
+}
+
+// MatrixStats are the statistics of a matrix, and of its test groups.
+type MatrixStats struct {
+	TestStats
+	Groups map[string]*TestStats `json:"groups,omitempty"`
+}
+
+// FailureClassification is the failure rule matched by a build.
+type FailureClassification struct {
+	Rule string `json:"rule"`
+	Category string `json:"category"`
+	Ticket string `json:"ticket,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	Source string `json:"source"`
+	// text matched by the pattern
+	Match string `json:"match"`
+}
+
+
+// TaskFailure describes an ansible task which failed on a host.
+type TaskFailure struct {
+	Role string `json:"role"`
+	Task string `json:"task"`
+	Host string `json:"host"`
+	Msg string `json:"msg"`
+	Stderr string `json:"stderr,omitempty"`
+}
+
 type ToolboxStepResult struct {
-	Name string
+	Name string `json:"name"`
 
-	Ok int
-	Failures int
-	Ignored int
+	Ok int `json:"ok"`
+	Failures int `json:"failures"`
+	Ignored int `json:"ignored"`
 
-	ExpectedFailure string
+	ExpectedFailure string `json:"expected_failure,omitempty"`
 
-	FlakeFailure string
+	FlakeFailure string `json:"flake_failure,omitempty"`
+
+	FailedTasks []TaskFailure `json:"failed_tasks,omitempty"`
+
+	// why the ansible log of the step could not be fully parsed
+	ParseError string `json:"parse_error,omitempty"`
+}
+
+type JUnitTestCase struct {
+	// path of the JUnit file, relative to the test step artifacts
+	File string `json:"file"`
+	Suite string `json:"suite"`
+	ClassName string `json:"class_name"`
+	Name string `json:"name"`
+
+	// passed, failed or skipped
+	Status string `json:"status"`
+	Duration time.Duration `json:"duration_ns"`
+	// message of the failure or skip
+	Message string `json:"message,omitempty"`
 }
 
 type TestResult struct {
-	BuildId string
-	Passed bool
-	Result string
-	FinishDate string
+	BuildId string `json:"build_id"`
+	Passed bool `json:"passed"`
+	Result string `json:"result"`
+
+	// creation of the Prow job
+	CreationTime Timestamp `json:"creation_time"`
+	StartTime Timestamp `json:"start_time"`
+	FinishTime Timestamp `json:"finish_time"`
+	// time between the start and the end of the build
+	Duration time.Duration `json:"duration_ns"`
+	// time between the creation of the Prow job and the start of the
+	// build
+	QueueTime time.Duration `json:"queue_time_ns"`
+	// Prow timeout of the build, if known
+	Timeout time.Duration `json:"timeout_ns,omitempty"`
+
+	StepExecuted bool `json:"step_executed"`
+	StepPassed bool `json:"step_passed"`
+	StepResult string `json:"step_result"`
+
+	Messages map[TestMessageType]map[string]string `json:"messages"`
 
-	StepExecuted bool
-	StepPassed bool
-	StepResult string
+	// first transient or permanent error that prevented an artifact
+	// of this build from being fetched
+	FetchError string `json:"fetch_error,omitempty"`
 
-	Messages map[TestMessageType]map[string]string
+	// content of the FAILURE file of the test step
+	StepFailure string `json:"step_failure,omitempty"`
+
+	// nil if the build passed or if no failure rule matched
+	Classification *FailureClassification `json:"classification,omitempty"`
+	// normalized description of the failure, empty if the build
+	// passed or if its failure is unknown
+	FailureSignature string `json:"failure_signature,omitempty"`
 
 	/* *** */
 
-	OperatorVersion string
-	OpenShiftVersion string
-	CiArtifactsVersion string
+	OperatorVersion string `json:"operator_version"`
+	OpenShiftVersion string `json:"openshift_version"`
+	CiArtifactsVersion string `json:"ci_artifacts_version"`
 
 	/* *** */
-	TestSpec *TestSpec
+	TestSpec *TestSpec `json:"-"`
+	// the build was restored from its snapshot, its artifacts were
+	// not parsed again
+	FromSnapshot bool `json:"-"`
+
+	ToolboxSteps []string `json:"toolbox_steps"`
 
-	ToolboxSteps []string
+	ToolboxStepsResults []ToolboxStepResult `json:"toolbox_steps_results"`
 
-	ToolboxStepsResults []ToolboxStepResult
+	JUnitTestCases []JUnitTestCase `json:"junit_test_cases,omitempty"`
 
 	/* *** */
 
-	Ok int
-	Failures int
-	Ignored int
+	Ok int `json:"ok"`
+	Failures int `json:"failures"`
+	Ignored int `json:"ignored"`
+
+	FlakeFailure bool `json:"flake_failure"`
+}
+
+// Status summarizes the result of the build: "success",
+// "fetch_error", "known_flake", the category of its failure
+// classification, "step_missing", "step_success" or "step_failed".
+func (test *TestResult) Status() string {
+	if test.Passed {
+		return "success"
+	} else if test.FetchError != "" {
+		return "fetch_error"
+	} else if len(test.Messages[TestMessageTypeFlake]) != 0 {
+		return "known_flake"
+	} else if test.Classification != nil {
+		return test.Classification.Category
+	} else if !test.StepExecuted {
+		return "step_missing"
+	} else if test.StepPassed {
+		return "step_success"
+	}
+	return "step_failed"
+}
 
-	FlakeFailure bool
+// MatchVersion tells if `version` is `filter`, or one of its patch
+// versions (eg, "4.17.3" matches "4.17").
+func MatchVersion(version, filter string) bool {
+	return filter == "" || version == filter || strings.HasPrefix(version, filter + ".")
 }
 
 type TestSpec struct {
@@ -107,18 +389,32 @@ type TestSpec struct {
 
 	/* *** */
 
-	Matrix *MatrixSpec
+	Matrix *MatrixSpec `json:"-"`
+
+	TestGroup string `json:"test_group,omitempty"`
 
-	TestGroup string
+	// populated from the test results, not part of the configuration:
+	// only encoded in the TestExport
+	OldTests []*TestResult `json:"-"`
 
-	OldTests []*TestResult
+	Regression *Regression `json:"-"`
+	Stats *TestStats `json:"-"`
+	// statistics of the builds of the history database
+	HistoryStats *TestStats `json:"-"`
 }
 
 type MatrixSpec struct {
 	Description string        `json:"description,omitempty"`
 	ViewerURL string          `json:"viewer_url,omitempty"`
+	Source string             `json:"source,omitempty"`
 	ArtifactsURL string       `json:"artifacts_url,omitempty"`
 	ArtifactsCache string     `json:"artifacts_cache,omitempty"`
+	ArtifactsBrowserURL string `json:"artifacts_browser_url,omitempty"`
+	GcsEndpoint string        `json:"gcs_endpoint,omitempty"`
+	HTTP *HTTPSpec            `json:"http,omitempty"`
+	Cache *CacheSpec          `json:"cache,omitempty"`
+	// checked before the failure rules of the matrices
+	FailureRules []FailureRule `json:"failure_rules,omitempty"`
 	ProwConfig string         `json:"prow_config,omitempty"`
 	ProwStep string           `json:"prow_step,omitempty"`
 	OperatorName string       `json:"operator_name,omitempty"`
@@ -127,5 +423,5 @@ type MatrixSpec struct {
 
 	/* *** */
 
-	Name string
+	Name string `json:"name,omitempty"`
 }
@@ -0,0 +1,512 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	cli "github.com/urfave/cli/v2"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+	"github.com/openshift-psap/ci-dashboard/pkg/artifacts"
+	"github.com/openshift-psap/ci-dashboard/pkg/config"
+	"github.com/openshift-psap/ci-dashboard/pkg/populate"
+)
+
+const (
+	DefaultConfigFile  = "examples/gpu-operator.yml"
+	DefaultTestHistory = -1
+	DefaultConcurrency = -1
+
+	UnknownMatrix = "(not in config)"
+)
+
+var log = logrus.New()
+
+func GetLogger() *logrus.Logger {
+	return log
+}
+
+type Flags struct {
+	ConfigFile string
+
+	// stats
+	ShowBuilds bool
+
+	// prune
+	OlderThan time.Duration
+	OutsideHistory bool
+	UnknownJobs bool
+	DryRun bool
+
+	// verify
+	Fix bool
+
+	// prune and warm
+	TestHistory int
+
+	// warm
+	Concurrency int
+}
+
+func BuildCommand() *cli.Command {
+	// Create a flags struct to hold our flags
+	cacheFlags := Flags{}
+
+	// Create the 'cache' command
+	cache := cli.Command{}
+	cache.Name = "cache"
+	cache.Usage = "Inspect and manage the artifacts cache"
+
+	// Setup the flags shared by all the subcommands
+	cache.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:        "config-file",
+			Aliases:     []string{"c"},
+			Usage:       "Configuration file defining the matrices and their artifacts cache",
+			Destination: &cacheFlags.ConfigFile,
+			Value:       DefaultConfigFile,
+			EnvVars:     []string{"CI_DASHBOARD_CACHE_CONFIG_FILE"},
+		},
+	}
+
+	cache.Subcommands = []*cli.Command{
+		{
+			Name:  "stats",
+			Usage: "Show the size of the cache per matrix and job",
+			Action: func(c *cli.Context) error {
+				return statsWrapper(c, &cacheFlags)
+			},
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:        "builds",
+					Usage:       "Show the size of each build",
+					Destination: &cacheFlags.ShowBuilds,
+				},
+			},
+		},
+		{
+			Name:  "prune",
+			Usage: "Remove old or unneeded entries from the cache",
+			Action: func(c *cli.Context) error {
+				return pruneWrapper(c, &cacheFlags)
+			},
+			Flags: []cli.Flag{
+				&cli.DurationFlag{
+					Name:        "older-than",
+					Usage:       "Remove the entries fetched before this duration",
+					Destination: &cacheFlags.OlderThan,
+				},
+				&cli.BoolFlag{
+					Name:        "outside-history",
+					Usage:       "Remove the builds no longer within the test history",
+					Destination: &cacheFlags.OutsideHistory,
+				},
+				&cli.BoolFlag{
+					Name:        "unknown-jobs",
+					Usage:       "Remove the jobs not part of the configuration",
+					Destination: &cacheFlags.UnknownJobs,
+				},
+				&cli.IntFlag{
+					Name:        "test-history",
+					Aliases:     []string{"th"},
+					Usage:       "Number of builds to keep with --outside-history",
+					Destination: &cacheFlags.TestHistory,
+					Value:       DefaultTestHistory,
+				},
+				&cli.BoolFlag{
+					Name:        "dry-run",
+					Usage:       "Only show what would be removed",
+					Destination: &cacheFlags.DryRun,
+				},
+			},
+		},
+		{
+			Name:  "verify",
+			Usage: "Find the invalid entries of the cache (404 pages, truncated JSON, ...)",
+			Action: func(c *cli.Context) error {
+				return verifyWrapper(c, &cacheFlags)
+			},
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:        "fix",
+					Usage:       "Remove the invalid entries",
+					Destination: &cacheFlags.Fix,
+				},
+			},
+		},
+		{
+			Name:      "inspect",
+			Usage:     "Show the metadata of the cache entries of the given artifact paths",
+			ArgsUsage: "PATH...",
+			Action: func(c *cli.Context) error {
+				return inspectWrapper(c, &cacheFlags)
+			},
+		},
+		{
+			Name:  "warm",
+			Usage: "Pre-fetch the artifacts required by the configuration, except the ones of the builds with a snapshot",
+			Action: func(c *cli.Context) error {
+				return warmWrapper(c, &cacheFlags)
+			},
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:        "test-history",
+					Aliases:     []string{"th"},
+					Usage:       "Number of tests to fetch",
+					Destination: &cacheFlags.TestHistory,
+					Value:       DefaultTestHistory,
+				},
+				&cli.IntFlag{
+					Name:        "concurrency",
+					Aliases:     []string{"j"},
+					Usage:       "Maximum number of tests and builds to fetch in parallel",
+					Destination: &cacheFlags.Concurrency,
+					Value:       DefaultConcurrency,
+				},
+			},
+		},
+	}
+
+	return &cache
+}
+
+// cacheJobs maps the cache directories to the jobs they contain,
+// and the jobs to their matrix.
+type cacheJobs map[string]map[string]*v1.TestSpec
+
+func loadConfig(f *Flags) (*v1.MatricesSpec, cacheJobs, error) {
+	matrices_spec, err := config.ParseMatricesConfigFile(f.ConfigFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing config file: %v", err)
+	}
+
+	jobs := cacheJobs{}
+	for _, test := range populate.PrepareTestMatrices(matrices_spec) {
+		cache_dir := test.Matrix.ArtifactsCache
+		if cache_dir == "" {
+			continue
+		}
+		if jobs[cache_dir] == nil {
+			jobs[cache_dir] = map[string]*v1.TestSpec{}
+		}
+		jobs[cache_dir][test.ProwName] = test
+	}
+
+	return matrices_spec, jobs, nil
+}
+
+func sortedCacheDirs(jobs cacheJobs) []string {
+	cache_dirs := []string{}
+	for cache_dir := range jobs {
+		cache_dirs = append(cache_dirs, cache_dir)
+	}
+	sort.Strings(cache_dirs)
+	return cache_dirs
+}
+
+func humanSize(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+type sizeStats struct {
+	files int
+	size int64
+}
+
+func (s *sizeStats) add(file artifacts.CacheFile) {
+	s.files++
+	s.size += file.Size
+}
+
+func statsWrapper(c *cli.Context, f *Flags) error {
+	_, jobs, err := loadConfig(f)
+	if err != nil {
+		return err
+	}
+
+	for _, cache_dir := range sortedCacheDirs(jobs) {
+		total := sizeStats{}
+		matrix_stats := map[string]*sizeStats{}
+		job_stats := map[string]*sizeStats{}
+		build_stats := map[string]map[string]*sizeStats{}
+
+		err := artifacts.WalkCache(cache_dir, func(file artifacts.CacheFile) error {
+			prow_name := file.ProwName()
+			matrix_name := UnknownMatrix
+			if test, found := jobs[cache_dir][prow_name]; found {
+				matrix_name = test.Matrix.Name
+			}
+
+			if matrix_stats[matrix_name] == nil {
+				matrix_stats[matrix_name] = &sizeStats{}
+			}
+			if job_stats[prow_name] == nil {
+				job_stats[prow_name] = &sizeStats{}
+				build_stats[prow_name] = map[string]*sizeStats{}
+			}
+			build_id := file.BuildId()
+			if build_stats[prow_name][build_id] == nil {
+				build_stats[prow_name][build_id] = &sizeStats{}
+			}
+
+			total.add(file)
+			matrix_stats[matrix_name].add(file)
+			job_stats[prow_name].add(file)
+			build_stats[prow_name][build_id].add(file)
+
+			return nil
+		})
+		if os.IsNotExist(err) {
+			fmt.Printf("Cache %s: empty\n", cache_dir)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("error walking the cache %s: %v", cache_dir, err)
+		}
+
+		fmt.Printf("Cache %s: %s in %d files\n", cache_dir, humanSize(total.size), total.files)
+
+		matrix_names := []string{}
+		for matrix_name := range matrix_stats {
+			matrix_names = append(matrix_names, matrix_name)
+		}
+		sort.Strings(matrix_names)
+
+		for _, matrix_name := range matrix_names {
+			fmt.Printf("- %s: %s in %d files\n", matrix_name,
+				humanSize(matrix_stats[matrix_name].size), matrix_stats[matrix_name].files)
+
+			prow_names := []string{}
+			for prow_name := range job_stats {
+				test, found := jobs[cache_dir][prow_name]
+				if found && test.Matrix.Name == matrix_name || !found && matrix_name == UnknownMatrix {
+					prow_names = append(prow_names, prow_name)
+				}
+			}
+			sort.Strings(prow_names)
+
+			for _, prow_name := range prow_names {
+				build_ids := []string{}
+				for build_id := range build_stats[prow_name] {
+					if build_id != "" {
+						build_ids = append(build_ids, build_id)
+					}
+				}
+				sort.Strings(build_ids)
+
+				fmt.Printf("  - %s: %s, %d builds\n", prow_name,
+					humanSize(job_stats[prow_name].size), len(build_ids))
+				if !f.ShowBuilds {
+					continue
+				}
+
+				for _, build_id := range build_ids {
+					fmt.Printf("    - %s: %s\n", build_id, humanSize(build_stats[prow_name][build_id].size))
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func pruneWrapper(c *cli.Context, f *Flags) error {
+	if f.OlderThan == 0 && !f.OutsideHistory && !f.UnknownJobs {
+		return fmt.Errorf("nothing to prune, use --older-than, --outside-history or --unknown-jobs")
+	}
+
+	matrices_spec, jobs, err := loadConfig(f)
+	if err != nil {
+		return err
+	}
+
+	// override matrices_spec.TestHistory if we received a flag value,
+	// as PopulateTestMatrices does
+	test_history := matrices_spec.TestHistory
+	if f.TestHistory >= 0 {
+		test_history = f.TestHistory
+	}
+	if f.OutsideHistory && test_history <= 0 {
+		return fmt.Errorf("invalid test history (%d), set test_history in '%s' or use --test-history",
+			test_history, f.ConfigFile)
+	}
+
+	for _, cache_dir := range sortedCacheDirs(jobs) {
+		// builds to keep, per job
+		history := map[string]map[string]bool{}
+		if f.OutsideHistory {
+			for prow_name, test := range jobs[cache_dir] {
+				build_ids, err := artifacts.FetchTestBuildIds(test.Matrix, prow_name, test_history)
+				if err != nil {
+					log.Warningf("Failed to fetch the builds of %s, not pruning it: %v", prow_name, err)
+					continue
+				}
+				history[prow_name] = map[string]bool{}
+				for _, build_id := range build_ids {
+					history[prow_name][build_id] = true
+				}
+			}
+		}
+
+		removed := sizeStats{}
+		err := artifacts.WalkCache(cache_dir, func(file artifacts.CacheFile) error {
+			prow_name := file.ProwName()
+			_, known_job := jobs[cache_dir][prow_name]
+
+			reason := ""
+			if f.UnknownJobs && !known_job {
+				reason = "job not in config"
+			} else if f.OutsideHistory && history[prow_name] != nil && file.BuildId() != "" &&
+				!history[prow_name][file.BuildId()] {
+				reason = "build outside the test history"
+			} else if f.OlderThan != 0 && time.Since(file.ModTime) > f.OlderThan {
+				reason = "entry too old"
+			} else {
+				return nil
+			}
+
+			log.Debugf("Pruning %s: %s", file.Path, reason)
+			removed.add(file)
+			if f.DryRun {
+				return nil
+			}
+			return artifacts.RemoveCacheFile(file)
+		})
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("error pruning the cache %s: %v", cache_dir, err)
+		}
+
+		action := "Removed"
+		if f.DryRun {
+			action = "Would remove"
+		}
+		log.Infof("Cache %s: %s %d files (%s)", cache_dir, action, removed.files, humanSize(removed.size))
+	}
+
+	return nil
+}
+
+func verifyWrapper(c *cli.Context, f *Flags) error {
+	_, jobs, err := loadConfig(f)
+	if err != nil {
+		return err
+	}
+
+	invalid_files := 0
+	for _, cache_dir := range sortedCacheDirs(jobs) {
+		err := artifacts.WalkCache(cache_dir, func(file artifacts.CacheFile) error {
+			problem, err := artifacts.VerifyCacheFile(file)
+			if err != nil {
+				return err
+			}
+			if problem == "" {
+				return nil
+			}
+
+			invalid_files++
+			fmt.Printf("%s: %s\n", file.Path, problem)
+			if f.Fix {
+				return artifacts.RemoveCacheFile(file)
+			}
+			return nil
+		})
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("error verifying the cache %s: %v", cache_dir, err)
+		}
+	}
+
+	if invalid_files != 0 && !f.Fix {
+		return fmt.Errorf("found %d invalid cache entries, use --fix to remove them", invalid_files)
+	}
+
+	action := "found"
+	if f.Fix {
+		action = "removed"
+	}
+	log.Infof("Cache verified, %d invalid entries %s", invalid_files, action)
+
+	return nil
+}
+
+func inspectWrapper(c *cli.Context, f *Flags) error {
+	if c.NArg() == 0 {
+		return fmt.Errorf("missing the path of the artifacts to inspect")
+	}
+
+	_, jobs, err := loadConfig(f)
+	if err != nil {
+		return err
+	}
+
+	for _, cache_dir := range sortedCacheDirs(jobs) {
+		err := artifacts.WalkCache(cache_dir, func(file artifacts.CacheFile) error {
+			for _, path := range c.Args().Slice() {
+				if !strings.HasPrefix(file.Key, path) {
+					continue
+				}
+
+				fmt.Printf("%s (%s)\n", file.Key, file.Path)
+				fmt.Printf("  size: %s, modified: %s\n", humanSize(file.Size), file.ModTime.Format(time.RFC3339))
+
+				meta := file.Metadata()
+				if meta == nil {
+					fmt.Printf("  no metadata\n")
+					break
+				}
+				fmt.Printf("  url: %s\n", meta.URL)
+				fmt.Printf("  status: %d, missing: %t\n", meta.StatusCode, meta.Missing)
+				fmt.Printf("  fetched at: %s (%s ago)\n", meta.FetchedAt.Format(time.RFC3339),
+					time.Since(meta.FetchedAt).Round(time.Second))
+				if meta.ContentType != "" {
+					fmt.Printf("  content type: %s\n", meta.ContentType)
+				}
+				if meta.ETag != "" {
+					fmt.Printf("  etag: %s\n", meta.ETag)
+				}
+				if meta.LastModified != "" {
+					fmt.Printf("  last modified: %s\n", meta.LastModified)
+				}
+				break
+			}
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error inspecting the cache %s: %v", cache_dir, err)
+		}
+	}
+
+	return nil
+}
+
+func warmWrapper(c *cli.Context, f *Flags) error {
+	matrices_spec, err := config.ParseMatricesConfigFile(f.ConfigFile)
+	if err != nil {
+		return fmt.Errorf("error parsing config file: %v", err)
+	}
+
+	// the builds restored from their snapshots are not fetched, the
+	// generation of the matrices does not need their artifacts either
+	if err = populate.PopulateTestMatrices(matrices_spec, f.TestHistory, f.Concurrency); err != nil {
+		return fmt.Errorf("error fetching the matrix results: %v", err)
+	}
+
+	populate.PopulateTestStepLogs(matrices_spec)
+
+	log.Infof("Artifacts cache warmed for '%s'", f.ConfigFile)
+
+	return nil
+}
@@ -0,0 +1,267 @@
+package cache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift-psap/ci-dashboard/pkg/artifacts"
+)
+
+const testConfig = `
+version: v1
+test_history: 2
+matrices:
+  nightly:
+    description: Nightly
+    operator_name: Test Operator
+    artifacts_url: file://%s
+    artifacts_cache: %s
+    prow_config: periodic-ci-org-repo
+    prow_step: test-step
+    tests:
+      0_main|Main branch:
+      - branch: main
+        test_name: e2e
+        operator_version: "1.8"
+`
+
+const testJob = "periodic-ci-org-repo-main-e2e"
+
+// file path -> content, relative to the cache directory
+var testCacheFiles = map[string]string{
+	testJob + "/?index": "<html>listing</html>",
+	testJob + "/latest-build.txt": "1002",
+	testJob + "/1000/finished.json": `{"passed": true}`,
+	testJob + "/1000/finished.json.cache-meta.json": `{"status_code": 200}`,
+	testJob + "/1000/build-log.txt": "log",
+	testJob + "/1001/finished.json": `{"passed": false}`,
+	// truncated
+	testJob + "/1002/finished.json": `{"passed": `,
+	"periodic-ci-org-repo-unknown-e2e/2000/finished.json": `{}`,
+	artifacts.SnapshotsDirName + "/" + testJob + "/1000.json": `{}`,
+}
+
+// files modified two days ago
+var testOldCacheFiles = []string{
+	testJob + "/1001/finished.json",
+	artifacts.SnapshotsDirName + "/" + testJob + "/1000.json",
+}
+
+func writeTestFile(t *testing.T, file_path, content string) {
+	if err := os.MkdirAll(filepath.Dir(file_path), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file_path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestCache creates a configuration file with a local artifacts
+// directory and an artifacts cache populated with testCacheFiles.
+func newTestCache(t *testing.T) (*Flags, string, func()) {
+	tmp_dir, err := ioutil.TempDir("", "ci-dashboard-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	artifacts_dir := filepath.Join(tmp_dir, "artifacts")
+	for _, build_id := range []string{"1000", "1001", "1002"} {
+		writeTestFile(t, filepath.Join(artifacts_dir, testJob, build_id, "finished.json"), `{"passed": true}`)
+	}
+
+	cache_dir := filepath.Join(tmp_dir, "cache")
+	for file_path, content := range testCacheFiles {
+		writeTestFile(t, filepath.Join(cache_dir, file_path), content)
+	}
+	mod_time := time.Now().Add(-48 * time.Hour)
+	for _, file_path := range testOldCacheFiles {
+		if err := os.Chtimes(filepath.Join(cache_dir, file_path), mod_time, mod_time); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config_file := filepath.Join(tmp_dir, "config.yml")
+	writeTestFile(t, config_file, fmt.Sprintf(testConfig, artifacts_dir, cache_dir))
+
+	flags := &Flags{
+		ConfigFile: config_file,
+		TestHistory: DefaultTestHistory,
+		Concurrency: DefaultConcurrency,
+	}
+
+	return flags, cache_dir, func() { os.RemoveAll(tmp_dir) }
+}
+
+// remainingFiles returns the files of testCacheFiles still in
+// `cache_dir`.
+func remainingFiles(cache_dir string) []string {
+	files := []string{}
+	for file_path := range testCacheFiles {
+		if _, err := os.Stat(filepath.Join(cache_dir, file_path)); err == nil {
+			files = append(files, file_path)
+		}
+	}
+	sort.Strings(files)
+
+	return files
+}
+
+// testCacheFilesWithout returns the files of testCacheFiles, except
+// `removed`.
+func testCacheFilesWithout(removed ...string) []string {
+	files := []string{}
+	for file_path := range testCacheFiles {
+		is_removed := false
+		for _, removed_path := range removed {
+			is_removed = is_removed || file_path == removed_path
+		}
+		if !is_removed {
+			files = append(files, file_path)
+		}
+	}
+	sort.Strings(files)
+
+	return files
+}
+
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	err = fn()
+	os.Stdout = stdout
+	w.Close()
+
+	output, read_err := ioutil.ReadAll(r)
+	if read_err != nil {
+		t.Fatal(read_err)
+	}
+
+	return string(output), err
+}
+
+func TestCacheStats(t *testing.T) {
+	flags, cache_dir, cleanup := newTestCache(t)
+	defer cleanup()
+
+	flags.ShowBuilds = true
+	output, err := captureStdout(t, func() error { return statsWrapper(nil, flags) })
+	if err != nil {
+		t.Fatalf("cache stats failed: %v", err)
+	}
+
+	for _, expected := range []string{
+		fmt.Sprintf("Cache %s: 73.0 B in 7 files\n", cache_dir),
+		"- nightly: 71.0 B in 6 files\n",
+		"  - " + testJob + ": 71.0 B, 3 builds\n",
+		"    - 1000: 19.0 B\n",
+		"- " + UnknownMatrix + ": 2.0 B in 1 files\n",
+		"  - periodic-ci-org-repo-unknown-e2e: 2.0 B, 1 builds\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("cache stats do not contain %q:\n%s", expected, output)
+		}
+	}
+	if strings.Contains(output, artifacts.SnapshotsDirName) {
+		t.Errorf("cache stats include the snapshots:\n%s", output)
+	}
+}
+
+func TestCacheVerify(t *testing.T) {
+	flags, cache_dir, cleanup := newTestCache(t)
+	defer cleanup()
+
+	truncated_file := testJob + "/1002/finished.json"
+
+	output, err := captureStdout(t, func() error { return verifyWrapper(nil, flags) })
+	if err == nil {
+		t.Errorf("cache verify succeeded with an invalid entry")
+	}
+	if !strings.Contains(output, truncated_file+": truncated or invalid JSON") {
+		t.Errorf("cache verify did not report the truncated file:\n%s", output)
+	}
+	if remaining := remainingFiles(cache_dir); !reflect.DeepEqual(remaining, testCacheFilesWithout()) {
+		t.Errorf("cache verify without --fix removed files, %v remaining", remaining)
+	}
+
+	flags.Fix = true
+	if _, err = captureStdout(t, func() error { return verifyWrapper(nil, flags) }); err != nil {
+		t.Fatalf("cache verify --fix failed: %v", err)
+	}
+	if remaining, expected := remainingFiles(cache_dir), testCacheFilesWithout(truncated_file); !reflect.DeepEqual(remaining, expected) {
+		t.Errorf("got %v in the cache after verify --fix, expected %v", remaining, expected)
+	}
+
+	flags.Fix = false
+	if _, err = captureStdout(t, func() error { return verifyWrapper(nil, flags) }); err != nil {
+		t.Errorf("cache verify failed after the fix: %v", err)
+	}
+}
+
+func TestCachePrune(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		flags Flags
+		removed []string
+	}{
+		{"older than", Flags{OlderThan: 24 * time.Hour}, []string{
+			testJob + "/1001/finished.json",
+		}},
+		{"outside history", Flags{OutsideHistory: true}, []string{
+			testJob + "/1000/finished.json",
+			testJob + "/1000/finished.json.cache-meta.json",
+			testJob + "/1000/build-log.txt",
+		}},
+		{"outside test history flag", Flags{OutsideHistory: true, TestHistory: 1}, []string{
+			testJob + "/1000/finished.json",
+			testJob + "/1000/finished.json.cache-meta.json",
+			testJob + "/1000/build-log.txt",
+			testJob + "/1001/finished.json",
+		}},
+		{"unknown jobs", Flags{UnknownJobs: true}, []string{
+			"periodic-ci-org-repo-unknown-e2e/2000/finished.json",
+		}},
+		{"dry run", Flags{OlderThan: 24 * time.Hour, OutsideHistory: true, UnknownJobs: true, DryRun: true}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			flags, cache_dir, cleanup := newTestCache(t)
+			defer cleanup()
+
+			tc.flags.ConfigFile = flags.ConfigFile
+			if tc.flags.TestHistory == 0 {
+				tc.flags.TestHistory = DefaultTestHistory
+			}
+			if err := pruneWrapper(nil, &tc.flags); err != nil {
+				t.Fatalf("cache prune failed: %v", err)
+			}
+
+			if remaining, expected := remainingFiles(cache_dir), testCacheFilesWithout(tc.removed...); !reflect.DeepEqual(remaining, expected) {
+				t.Errorf("got %v in the cache, expected %v", remaining, expected)
+			}
+		})
+	}
+
+	flags, _, cleanup := newTestCache(t)
+	defer cleanup()
+
+	if err := pruneWrapper(nil, flags); err == nil {
+		t.Errorf("cache prune succeeded without anything to prune")
+	}
+
+	flags.OutsideHistory = true
+	flags.TestHistory = 0
+	if err := pruneWrapper(nil, flags); err == nil {
+		t.Errorf("cache prune succeeded with an empty test history")
+	}
+}
@@ -1,6 +1,7 @@
 package daily_matrix
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -10,7 +11,10 @@ import (
 	"github.com/sirupsen/logrus"
 	cli "github.com/urfave/cli/v2"
 
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+	"github.com/openshift-psap/ci-dashboard/pkg/artifacts"
 	"github.com/openshift-psap/ci-dashboard/pkg/config"
+	"github.com/openshift-psap/ci-dashboard/pkg/history"
 	"github.com/openshift-psap/ci-dashboard/pkg/populate"
 	matrix_tpl "github.com/openshift-psap/ci-dashboard/pkg/template/matrix"
 
@@ -21,6 +25,20 @@ const (
 	DefaultOutputFile = "output/gpu-operator_daily-matrix.html"
 	DefaultTemplateFile = "templates/daily_matrix.tmpl.html"
 	DefaultTestHistory = -1
+	DefaultConcurrency = -1
+	DefaultTimezone = "Local"
+	DefaultDateFormat = v1.DateFormat
+	DefaultGenerationDateFormat = matrix_tpl.DefaultGenerationDateFormat
+	DefaultHistoryWindow = 90 * 24 * time.Hour
+	DefaultFormat = FormatHTML
+)
+
+// formats of the output file
+const (
+	// page generated from the template
+	FormatHTML = "html"
+	// fully populated matrices, see v1.MatricesExport
+	FormatJSON = "json"
 )
 
 var log = logrus.New()
@@ -32,8 +50,17 @@ func GetLogger() *logrus.Logger {
 type Flags struct {
 	ConfigFile string
 	OutputFile string
+	Format string
 	TemplateFile string
 	TestHistory int
+	Concurrency int
+	Timezone string
+	DateFormat string
+	GenerationDateFormat string
+	RegressionsFile string
+	StatsFile string
+	HistoryDB string
+	HistoryWindow time.Duration
 }
 
 type Context struct {
@@ -71,6 +98,14 @@ func BuildCommand() *cli.Command {
 			Value:       DefaultOutputFile,
 			EnvVars:     []string{"CI_DASHBOARD_DAILYMATRIX_OUTPUT_FILE"},
 		},
+		&cli.StringFlag{
+			Name:        "format",
+			Aliases:     []string{"f"},
+			Usage:       "Format of the output file: 'html' (generated from the template) or 'json' (populated matrices)",
+			Destination: &daily_matrixFlags.Format,
+			Value:       DefaultFormat,
+			EnvVars:     []string{"CI_DASHBOARD_DAILYMATRIX_FORMAT"},
+		},
 		&cli.StringFlag{
 			Name:        "template",
 			Aliases:     []string{"t"},
@@ -87,15 +122,70 @@ func BuildCommand() *cli.Command {
 			Value:       DefaultTestHistory,
 			EnvVars:     []string{"CI_DASHBOARD_DAILYMATRIX_TEST_HISTORY"},
 		},
+		&cli.IntFlag{
+			Name:        "concurrency",
+			Aliases:     []string{"j"},
+			Usage:       "Maximum number of tests and builds to fetch in parallel",
+			Destination: &daily_matrixFlags.Concurrency,
+			Value:       DefaultConcurrency,
+			EnvVars:     []string{"CI_DASHBOARD_DAILYMATRIX_CONCURRENCY"},
+		},
+		&cli.StringFlag{
+			Name:        "timezone",
+			Aliases:     []string{"tz"},
+			Usage:       "Time zone of the dates of the matrix (eg, UTC, Europe/Paris)",
+			Destination: &daily_matrixFlags.Timezone,
+			Value:       DefaultTimezone,
+			EnvVars:     []string{"CI_DASHBOARD_DAILYMATRIX_TIMEZONE"},
+		},
+		&cli.StringFlag{
+			Name:        "date-format",
+			Usage:       "Go layout of the dates of the tests",
+			Destination: &daily_matrixFlags.DateFormat,
+			Value:       DefaultDateFormat,
+			EnvVars:     []string{"CI_DASHBOARD_DAILYMATRIX_DATE_FORMAT"},
+		},
+		&cli.StringFlag{
+			Name:        "generation-date-format",
+			Usage:       "Go layout of the generation date of the matrix",
+			Destination: &daily_matrixFlags.GenerationDateFormat,
+			Value:       DefaultGenerationDateFormat,
+			EnvVars:     []string{"CI_DASHBOARD_DAILYMATRIX_GENERATION_DATE_FORMAT"},
+		},
+		&cli.StringFlag{
+			Name:        "regressions-file",
+			Usage:       "JSON file where the regression status of the tests will be stored (disabled if empty)",
+			Destination: &daily_matrixFlags.RegressionsFile,
+			EnvVars:     []string{"CI_DASHBOARD_DAILYMATRIX_REGRESSIONS_FILE"},
+		},
+		&cli.StringFlag{
+			Name:        "stats-file",
+			Usage:       "JSON file where the reliability statistics of the tests will be stored (disabled if empty)",
+			Destination: &daily_matrixFlags.StatsFile,
+			EnvVars:     []string{"CI_DASHBOARD_DAILYMATRIX_STATS_FILE"},
+		},
+		&cli.StringFlag{
+			Name:        "history-db",
+			Usage:       "History database where the builds will be recorded, and from which the long-term statistics will be computed (disabled if empty)",
+			Destination: &daily_matrixFlags.HistoryDB,
+			EnvVars:     []string{"CI_DASHBOARD_DAILYMATRIX_HISTORY_DB"},
+		},
+		&cli.DurationFlag{
+			Name:        "history-window",
+			Usage:       "Duration of the history used for the long-term statistics",
+			Destination: &daily_matrixFlags.HistoryWindow,
+			Value:       DefaultHistoryWindow,
+			EnvVars:     []string{"CI_DASHBOARD_DAILYMATRIX_HISTORY_WINDOW"},
+		},
 	}
 
 	return &daily_matrix
 }
 
-func saveGeneratedHtml(generated_html []byte, f *Flags) error {
-	output_dir, err := filepath.Abs(filepath.Dir(f.OutputFile))
+func saveFile(filename string, content []byte) error {
+	output_dir, err := filepath.Abs(filepath.Dir(filename))
     if err != nil {
-		return fmt.Errorf("Failed to get output directory for %s: %v", f.OutputFile, err)
+		return fmt.Errorf("Failed to get output directory for %s: %v", filename, err)
     }
 
 	err = os.MkdirAll(output_dir, os.ModePerm)
@@ -103,39 +193,111 @@ func saveGeneratedHtml(generated_html []byte, f *Flags) error {
 		return fmt.Errorf("Failed to create output directory %s: %v", output_dir, err)
     }
 
-	err = ioutil.WriteFile(f.OutputFile, generated_html, 0644)
+	err = ioutil.WriteFile(filename, content, 0644)
 	if err != nil {
-		return fmt.Errorf("Failed to write into output file at %s: %v", f.OutputFile, err)
+		return fmt.Errorf("Failed to write into output file at %s: %v", filename, err)
 	}
 
 	return nil
 }
 
+func saveJson(filename string, value interface{}) error {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to encode %s: %v", filename, err)
+	}
+
+	return saveFile(filename, append(content, '\n'))
+}
+
+func updateHistory(matricesSpec *v1.MatricesSpec, f *Flags, currentTime time.Time) error {
+	history_db, err := history.Open(f.HistoryDB)
+	if err != nil {
+		return err
+	}
+	defer history_db.Close()
+
+	ingested, err := history_db.Ingest(matricesSpec)
+	if err != nil {
+		return err
+	}
+	log.Infof("History database '%s': %d builds added, %d updated, %d already recorded",
+		f.HistoryDB, ingested.Added, ingested.Updated, ingested.Skipped)
+
+	return history_db.PopulateHistoryStats(matricesSpec, currentTime.Add(-f.HistoryWindow))
+}
+
 func daily_matrixWrapper(c *cli.Context, f *Flags) error {
+	if f.Format != FormatHTML && f.Format != FormatJSON {
+		return fmt.Errorf("invalid format '%s', expected '%s' or '%s'", f.Format, FormatHTML, FormatJSON)
+	}
+
+	location, err := time.LoadLocation(f.Timezone)
+	if err != nil {
+		return fmt.Errorf("invalid timezone '%s': %v", f.Timezone, err)
+	}
+
 	matricesSpec, err := config.ParseMatricesConfigFile(f.ConfigFile)
 	if err != nil {
 		return fmt.Errorf("error parsing config file: %v", err)
 	}
 
-	if err = populate.PopulateTestMatrices(matricesSpec, f.TestHistory); err != nil {
+	if err = populate.PopulateTestMatrices(matricesSpec, f.TestHistory, f.Concurrency); err != nil {
 		return fmt.Errorf("error fetching the matrix results: %v", err)
 	}
 
 	populate.PopulateTestStepLogs(matricesSpec)
 
 	currentTime := time.Now()
-	generation_date := currentTime.Format("2006-01-02 15h04")
+	if replay_time, replaying := artifacts.ReplayTime(); replaying {
+		// reproduce the output of the recording
+		currentTime = replay_time
+	}
 
-	generated_html, err := matrix_tpl.Generate(f.TemplateFile, matricesSpec, generation_date)
-	if err != nil {
-		return fmt.Errorf("error generating the matrix page from the template: %v", err)
+	if f.HistoryDB != "" {
+		if err = updateHistory(matricesSpec, f, currentTime); err != nil {
+			return fmt.Errorf("error updating the history database: %v", err)
+		}
 	}
 
-	if err = saveGeneratedHtml(generated_html, f); err != nil {
-		return fmt.Errorf("error saving the generated matrix page: %v", err)
+	if f.Format == FormatJSON {
+		err = saveJson(f.OutputFile, v1.NewMatricesExport(matricesSpec, currentTime))
+		if err != nil {
+			return fmt.Errorf("error saving the matrices: %v", err)
+		}
+	} else {
+		generated_html, err := matrix_tpl.Generate(f.TemplateFile, matricesSpec, matrix_tpl.Options{
+			Now: currentTime,
+			Location: location,
+			DateFormat: f.DateFormat,
+			GenerationDateFormat: f.GenerationDateFormat,
+		})
+		if err != nil {
+			return fmt.Errorf("error generating the matrix page from the template: %v", err)
+		}
+
+		if err = saveFile(f.OutputFile, generated_html); err != nil {
+			return fmt.Errorf("error saving the generated matrix page: %v", err)
+		}
 	}
 
 	log.Infof("Daily test matrix saved into '%s'", f.OutputFile)
 
+	if f.RegressionsFile != "" {
+		if err = saveJson(f.RegressionsFile, populate.RegressionReport(matricesSpec)); err != nil {
+			return fmt.Errorf("error saving the regressions: %v", err)
+		}
+
+		log.Infof("Regressions saved into '%s'", f.RegressionsFile)
+	}
+
+	if f.StatsFile != "" {
+		if err = saveJson(f.StatsFile, populate.NewStatsReport(matricesSpec)); err != nil {
+			return fmt.Errorf("error saving the statistics: %v", err)
+		}
+
+		log.Infof("Statistics saved into '%s'", f.StatsFile)
+	}
+
 	return nil
 }
@@ -0,0 +1,196 @@
+package daily_matrix
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+	"github.com/openshift-psap/ci-dashboard/pkg/fakeprow"
+	"github.com/openshift-psap/ci-dashboard/pkg/history"
+	"github.com/openshift-psap/ci-dashboard/pkg/populate"
+)
+
+const testConfig = `
+version: v1
+test_history: 5
+failure_rules:
+- name: segfault
+  category: product_bug
+  pattern: "segmentation fault"
+- name: cluster-login
+  category: infra_flake
+  pattern: "You must be logged in to the server"
+  sources: [ansible]
+  ticket: https://issues.example.com/browse/CI-42
+matrices:
+  nightly:
+    description: Nightly
+    operator_name: Test Operator
+    viewer_url: https://prow.example.com/view/gs/test-bucket/logs
+    artifacts_url: %s
+    artifacts_cache: %s
+    prow_config: periodic-ci-org-repo
+    prow_step: test-step
+    tests:
+      0_main|Main branch:
+      - branch: main
+        test_name: e2e
+        operator_version: "1.8"
+      1_release|Release branch:
+      - branch: release-1.7
+        test_name: e2e
+        operator_version: "1.7"
+`
+
+// TestDailyMatrix is a smoke test of the command: the behaviors of the
+// populated matrices are tested in their packages.
+func TestDailyMatrix(t *testing.T) {
+	server, err := fakeprow.NewServer(&fakeprow.Bucket{
+		Jobs: []fakeprow.Job{{
+			Name: "periodic-ci-org-repo-main-e2e",
+			StepDir: "artifacts/e2e/test-step/artifacts",
+			Builds: []fakeprow.Build{
+				{
+					Id: "1000", Result: "SUCCESS", Timestamp: 1600000000, StepResult: "SUCCESS",
+					Steps: []fakeprow.Step{{Name: "000__cluster__capture_environment", Ok: 5}},
+				},
+				{
+					Id: "1001", Result: "FAILURE", Timestamp: 1600086400, StepResult: "FAILURE",
+					Failure: "step failed",
+					Steps: []fakeprow.Step{{Name: "000__cluster__capture_environment", Ok: 2, Failures: 1,
+						FailedTasks: []fakeprow.FailedTask{{
+							Name: "cluster_capture_environment : Get the nodes",
+							Msg: "non-zero return code",
+							Stderr: "error: You must be logged in to the server",
+						}}}},
+				},
+			},
+		}, {
+			Name: "periodic-ci-org-repo-release-1.7-e2e",
+			StepDir: "artifacts/e2e/test-step/artifacts",
+			Builds: []fakeprow.Build{
+				{
+					Id: "2000", Result: "SUCCESS", Timestamp: 1600000000, StepResult: "SUCCESS",
+					OperatorVersion: "1.7.0",
+				},
+				{
+					Id: "2001", Result: "FAILURE", Timestamp: 1600086400, StepResult: "FAILURE",
+					OperatorVersion: "1.7.1",
+					Failure: "segmentation fault in the driver",
+				},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	tmp_dir, err := ioutil.TempDir("", "ci-dashboard-daily-matrix-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp_dir)
+
+	config_file := filepath.Join(tmp_dir, "config.yml")
+	config := fmt.Sprintf(testConfig, server.ArtifactsURL(), filepath.Join(tmp_dir, "cache"))
+	if err = ioutil.WriteFile(config_file, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	flags := &Flags{
+		ConfigFile: config_file,
+		OutputFile: filepath.Join(tmp_dir, "output", "matrix.html"),
+		Format: DefaultFormat,
+		TemplateFile: filepath.Join("..", "..", DefaultTemplateFile),
+		TestHistory: DefaultTestHistory,
+		Concurrency: DefaultConcurrency,
+		Timezone: "UTC",
+		DateFormat: "02/01/2006 15:04 MST",
+		RegressionsFile: filepath.Join(tmp_dir, "output", "regressions.json"),
+		StatsFile: filepath.Join(tmp_dir, "output", "stats.json"),
+		HistoryDB: filepath.Join(tmp_dir, "history.db"),
+		// the builds of the test are old
+		HistoryWindow: 100000 * time.Hour,
+	}
+	if err = daily_matrixWrapper(nil, flags); err != nil {
+		t.Fatalf("daily_matrix failed: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(flags.OutputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(content)
+
+	for _, expected := range []string{
+		"old_tests old_test_success",
+		"old_tests old_test_infra_flake",
+		"old_tests old_test_product_bug",
+		"periodic-ci-org-repo-main-e2e/1001",
+		"periodic-ci-org-repo-release-1.7-e2e/2001",
+		`<table id="regressions">`,
+		`<table id="reliability">`,
+		`<table id="failure_signatures">`,
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("generated matrix does not contain %q", expected)
+		}
+	}
+	if strings.Contains(html, "old_tests old_test_fetch_error") {
+		t.Errorf("generated matrix contains fetch errors")
+	}
+
+	content, err = ioutil.ReadFile(flags.RegressionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	regressions := []populate.RegressionReportEntry{}
+	if err = json.Unmarshal(content, &regressions); err != nil || len(regressions) != 2 {
+		t.Errorf("got %d tests in the regressions file, expected 2 (%v)", len(regressions), err)
+	}
+
+	content, err = ioutil.ReadFile(flags.StatsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := populate.StatsReport{}
+	if err = json.Unmarshal(content, &stats); err != nil || len(stats.Tests) != 2 {
+		t.Errorf("got %d tests in the statistics file, expected 2 (%v)", len(stats.Tests), err)
+	}
+
+	history_db, err := history.Open(flags.HistoryDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := history_db.Records(history.Filter{})
+	history_db.Close()
+	if err != nil || len(records) != 4 {
+		t.Errorf("got %d builds in the history database, expected 4 (%v)", len(records), err)
+	}
+
+	// JSON export of the populated matrices
+	flags.Format = FormatJSON
+	flags.OutputFile = filepath.Join(tmp_dir, "output", "matrices.json")
+	if err = daily_matrixWrapper(nil, flags); err != nil {
+		t.Fatalf("daily_matrix JSON export failed: %v", err)
+	}
+
+	content, err = ioutil.ReadFile(flags.OutputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := v1.MatricesExport{}
+	if err = json.Unmarshal(content, &exported); err != nil {
+		t.Fatalf("invalid JSON export: %v", err)
+	}
+	if tests := exported.Matrices["nightly"].Tests; len(tests) != 2 {
+		t.Errorf("got %d test groups in the JSON export, expected 2", len(tests))
+	}
+}
@@ -0,0 +1,168 @@
+package history
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	cli "github.com/urfave/cli/v2"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+	"github.com/openshift-psap/ci-dashboard/pkg/history"
+	"github.com/openshift-psap/ci-dashboard/pkg/populate"
+)
+
+type Flags struct {
+	HistoryDB string
+
+	// filters
+	ProwName string
+	OperatorVersion string
+	OpenShiftVersion string
+	Since time.Duration
+}
+
+func BuildCommand() *cli.Command {
+	// Create a flags struct to hold our flags
+	historyFlags := Flags{}
+
+	// Create the 'history' command
+	history := cli.Command{}
+	history.Name = "history"
+	history.Usage = "Query the history database recorded by daily_matrix"
+
+	// Setup the flags shared by all the subcommands
+	history.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:        "history-db",
+			Aliases:     []string{"db"},
+			Usage:       "History database to query",
+			Destination: &historyFlags.HistoryDB,
+			Required:    true,
+			EnvVars:     []string{"CI_DASHBOARD_HISTORY_DB"},
+		},
+	}
+
+	filterFlags := []cli.Flag{
+		&cli.StringFlag{
+			Name:        "prow-name",
+			Usage:       "Only consider the builds of this Prow job",
+			Destination: &historyFlags.ProwName,
+		},
+		&cli.StringFlag{
+			Name:        "operator-version",
+			Usage:       "Only consider the builds of this operator version, or of its patch versions",
+			Destination: &historyFlags.OperatorVersion,
+		},
+		&cli.StringFlag{
+			Name:        "openshift-version",
+			Usage:       "Only consider the builds on this OpenShift version, or on its patch versions",
+			Destination: &historyFlags.OpenShiftVersion,
+		},
+		&cli.DurationFlag{
+			Name:        "since",
+			Usage:       "Only consider the builds finished during this duration (all the builds if 0)",
+			Destination: &historyFlags.Since,
+		},
+	}
+
+	history.Subcommands = []*cli.Command{
+		{
+			Name:  "stats",
+			Usage: "Show the pass and flake rates of the recorded Prow jobs",
+			Action: func(c *cli.Context) error {
+				return statsWrapper(c, &historyFlags)
+			},
+			Flags: filterFlags,
+		},
+		{
+			Name:  "builds",
+			Usage: "List the recorded builds, newest first",
+			Action: func(c *cli.Context) error {
+				return buildsWrapper(c, &historyFlags)
+			},
+			Flags: filterFlags,
+		},
+	}
+
+	return &history
+}
+
+func (f *Flags) filter() history.Filter {
+	filter := history.Filter{
+		ProwName: f.ProwName,
+		OperatorVersion: f.OperatorVersion,
+		OpenShiftVersion: f.OpenShiftVersion,
+	}
+	if f.Since > 0 {
+		filter.Since = time.Now().Add(-f.Since)
+	}
+
+	return filter
+}
+
+func statsWrapper(c *cli.Context, f *Flags) error {
+	history_db, err := history.OpenReadOnly(f.HistoryDB)
+	if err != nil {
+		return err
+	}
+	defer history_db.Close()
+
+	records, err := history_db.Records(f.filter())
+	if err != nil {
+		return err
+	}
+
+	prow_names := []string{}
+	test_results := map[string][]*v1.TestResult{}
+	for _, record := range records {
+		if test_results[record.ProwName] == nil {
+			prow_names = append(prow_names, record.ProwName)
+		}
+		test_results[record.ProwName] = append(test_results[record.ProwName], record.TestResult())
+	}
+
+	if len(prow_names) == 0 {
+		fmt.Println("No build recorded.")
+		return nil
+	}
+	sort.Strings(prow_names)
+
+	for _, prow_name := range prow_names {
+		stats := populate.ComputeTestStats(test_results[prow_name])
+		fmt.Printf("%s: %d builds, %d passed, %d failed, %d flakes (pass rate: %.0f%%, flake rate: %.0f%%)\n",
+			prow_name, stats.Builds, stats.Passed, stats.Failed, stats.Flakes,
+			stats.PassRate * 100, stats.FlakeRate * 100)
+	}
+
+	return nil
+}
+
+func buildsWrapper(c *cli.Context, f *Flags) error {
+	history_db, err := history.OpenReadOnly(f.HistoryDB)
+	if err != nil {
+		return err
+	}
+	defer history_db.Close()
+
+	records, err := history_db.Records(f.filter())
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		test_result := record.TestResult()
+		descr := record.Result
+		if record.FailureCategory != "" {
+			descr += " (" + record.FailureCategory + ")"
+		}
+		fmt.Printf("%s/%s: %s, finished at %s, operator %s, OpenShift %s\n",
+			record.ProwName, record.BuildId, descr, test_result.FinishTime,
+			record.OperatorVersion, record.OpenShiftVersion)
+		if record.FailureSignature != "" {
+			fmt.Printf("    %s\n", record.FailureSignature)
+		}
+	}
+
+	return nil
+}
@@ -1,18 +1,26 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"strings"
 
+	"github.com/openshift-psap/ci-dashboard/cmd/cache"
 	"github.com/openshift-psap/ci-dashboard/cmd/daily_matrix"
+	"github.com/openshift-psap/ci-dashboard/cmd/history"
 	"github.com/openshift-psap/ci-dashboard/cmd/matrix_benchmarks"
+	"github.com/openshift-psap/ci-dashboard/cmd/serve"
 	"github.com/openshift-psap/ci-dashboard/pkg/artifacts"
 	"github.com/openshift-psap/ci-dashboard/pkg/config"
+	history_db "github.com/openshift-psap/ci-dashboard/pkg/history"
 	log "github.com/sirupsen/logrus"
 	cli "github.com/urfave/cli/v2"
 )
 
 type Flags struct {
 	Debug bool
+	RecordDir string
+	ReplayDir string
 }
 
 func main() {
@@ -35,11 +43,26 @@ func main() {
 			Destination: &flags.Debug,
 			EnvVars:     []string{"CI_DASHBOARD_DEBUG"},
 		},
+		&cli.StringFlag{
+			Name:        "record",
+			Usage:       "Record all the artifacts requests into this fixture directory",
+			Destination: &flags.RecordDir,
+			EnvVars:     []string{"CI_DASHBOARD_RECORD"},
+		},
+		&cli.StringFlag{
+			Name:        "replay",
+			Usage:       "Serve all the artifacts requests from this fixture directory",
+			Destination: &flags.ReplayDir,
+			EnvVars:     []string{"CI_DASHBOARD_REPLAY"},
+		},
 	}
 
 	app.Commands = []*cli.Command{
 		daily_matrix.BuildCommand(),
 		matrix_benchmarks.BuildCommand(),
+		cache.BuildCommand(),
+		history.BuildCommand(),
+		serve.BuildCommand(),
 	}
 
 	// Set log-level for all subcommands
@@ -51,11 +74,42 @@ func main() {
 		daily_matrixLog := daily_matrix.GetLogger()
 		daily_matrixLog.SetLevel(logLevel)
 
+		serveLog := serve.GetLogger()
+		serveLog.SetLevel(logLevel)
+
+		cacheLog := cache.GetLogger()
+		cacheLog.SetLevel(logLevel)
+
+		historyLog := history_db.GetLogger()
+		historyLog.SetLevel(logLevel)
+
 		configLog := config.GetLogger()
 		configLog.SetLevel(logLevel)
 
 		artifactsLog := artifacts.GetLogger()
 		artifactsLog.SetLevel(logLevel)
+
+		if flags.RecordDir != "" && flags.ReplayDir != "" {
+			return fmt.Errorf("--record and --replay cannot be used together")
+		} else if flags.RecordDir != "" {
+			return artifacts.StartRecording(flags.RecordDir)
+		} else if flags.ReplayDir != "" {
+			return artifacts.StartReplay(flags.ReplayDir)
+		}
+
+		return nil
+	}
+
+	app.After = func(app *cli.Context) error {
+		if err := artifacts.StopRecording(); err != nil {
+			return err
+		}
+
+		if misses := artifacts.ReplayMisses(); len(misses) != 0 {
+			return fmt.Errorf("%d requests were not found in the replay fixtures:\n%s",
+				len(misses), strings.Join(misses, "\n"))
+		}
+
 		return nil
 	}
 
@@ -13,13 +13,8 @@ func FetchGPUBurnLogs(test_result *v1.TestResult) (string, error) {
 		if ! strings.Contains(step_name, "_run_gpu_burn") {
 			continue
 		}
-		html_gpu_burn_files, err := artifacts.FetchTestStepResult(test_result,
-			fmt.Sprintf("artifacts/%s/", step_name), artifacts.TypeHtml)
-		if err != nil {
-			return "", err
-		}
-
-		gpu_burn_files, err := artifacts.ListFilesInDirectory(html_gpu_burn_files.Html, false, true)
+		gpu_burn_files, err := artifacts.ListTestStepDirectory(test_result,
+			fmt.Sprintf("artifacts/%s", step_name), false, true)
 		if err != nil {
 			return "", err
 		}
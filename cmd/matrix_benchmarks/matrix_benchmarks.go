@@ -17,6 +17,7 @@ const (
 	DefaultConfigFile  = "examples/gpu-operator.yml"
 	DefaultOutputDir = "output/matrix_benchmarking/"
 	DefaultTestHistory = -1
+	DefaultConcurrency = -1
 )
 
 var log = logrus.New()
@@ -29,6 +30,7 @@ type Flags struct {
 	ConfigFile string
 	OutputDir string
 	TestHistory int
+	Concurrency int
 }
 
 type Context struct {
@@ -74,6 +76,14 @@ func BuildCommand() *cli.Command {
 			Value:       DefaultTestHistory,
 			EnvVars:     []string{"CI_DASHBOARD_MATRIX_BENCH_TEST_HISTORY"},
 		},
+		&cli.IntFlag{
+			Name:        "concurrency",
+			Aliases:     []string{"j"},
+			Usage:       "Maximum number of tests and builds to fetch in parallel",
+			Destination: &matrix_benchFlags.Concurrency,
+			Value:       DefaultConcurrency,
+			EnvVars:     []string{"CI_DASHBOARD_MATRIX_BENCH_CONCURRENCY"},
+		},
 	}
 
 	return &matrix_bench
@@ -86,7 +96,7 @@ func matrix_benchWrapper(c *cli.Context, f *Flags) error {
 		return fmt.Errorf("error parsing config file: %v", err)
 	}
 
-	if err = populate.PopulateTestMatrices(matrices_spec, f.TestHistory); err != nil {
+	if err = populate.PopulateTestMatrices(matrices_spec, f.TestHistory, f.Concurrency); err != nil {
 		return fmt.Errorf("error fetching the matrix results: %v", err)
 	}
 
@@ -105,7 +115,7 @@ func matrix_benchWrapper(c *cli.Context, f *Flags) error {
 	}
 
 	saveSettings := func(benchmark_name string, test_result *v1.TestResult, exit_code int) (string, error) {
-		dest_dir := fmt.Sprintf("%s/%s/%s/%s/", f.OutputDir, test_result.TestSpec.ProwName, test_result.FinishDate, benchmark_name)
+		dest_dir := fmt.Sprintf("%s/%s/%s/%s/", f.OutputDir, test_result.TestSpec.ProwName, test_result.FinishTime.Format(v1.DateFormat), benchmark_name)
 
 		if err := os.MkdirAll(dest_dir, os.ModePerm); err != nil {
 			return "", fmt.Errorf("Failed to create output directory %s: %v", f.OutputDir, err)
@@ -120,7 +130,7 @@ func matrix_benchWrapper(c *cli.Context, f *Flags) error {
 		settings += "operator-version=" + test_result.TestSpec.OperatorVersion + "\n"
 		settings += "openshift-version=" + test_result.TestSpec.Branch + "\n"
 		settings += "instance-type=g4dn.xlarge\n"
-		settings += "@finish-date=" + test_result.FinishDate + "\n"
+		settings += "@finish-date=" + test_result.FinishTime.Format(v1.DateFormat) + "\n"
 
 		if err := saveInfo(dest_dir, "settings", []byte(settings)); err != nil {
 			return "", err
@@ -180,6 +190,12 @@ func matrix_benchWrapper(c *cli.Context, f *Flags) error {
 			return err
 		}
 
+		if test_result.Duration != 0 {
+			if err = saveInfoInt(dest_dir, "duration_seconds", int(test_result.Duration.Seconds())); err != nil {
+				return err
+			}
+		}
+
 		return nil
 	}
 
@@ -0,0 +1,113 @@
+package matrix_benchmarks
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/openshift-psap/ci-dashboard/pkg/fakeprow"
+)
+
+const testConfig = `
+version: v1
+test_history: 5
+matrices:
+  nightly:
+    description: Nightly
+    operator_name: GPU Operator
+    artifacts_url: %s
+    artifacts_cache: %s
+    prow_config: periodic-ci-org-repo
+    prow_step: gpu-operator-e2e
+    tests:
+      0_main|Main branch:
+      - branch: main
+        test_name: e2e
+        operator_version: "1.8"
+`
+
+func TestMatrixBenchmarks(t *testing.T) {
+	step_dir := "artifacts/e2e/gpu-operator-e2e/artifacts"
+	gpu_burn_step := "001__gpu_operator__run_gpu_burn"
+
+	server, err := fakeprow.NewServer(&fakeprow.Bucket{
+		Jobs: []fakeprow.Job{{
+			Name: "periodic-ci-org-repo-main-e2e",
+			StepDir: step_dir,
+			Builds: []fakeprow.Build{
+				{
+					Id: "1000", Result: "SUCCESS", Timestamp: 1600000000, StepResult: "SUCCESS",
+					Steps: []fakeprow.Step{
+						{Name: "000__cluster__capture_environment", Ok: 5},
+						{Name: gpu_burn_step, Ok: 7},
+					},
+					Files: map[string]string{
+						step_dir + "/artifacts/" + gpu_burn_step + "/gpu_burn.0.log": "GPU 0: OK\n",
+					},
+				},
+				{
+					Id: "1001", Result: "FAILURE", Timestamp: 1600086400, StepResult: "FAILURE",
+					Steps: []fakeprow.Step{{Name: "000__cluster__capture_environment", Ok: 3, Failures: 1}},
+				},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	tmp_dir, err := ioutil.TempDir("", "ci-dashboard-matrix-benchmarks-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp_dir)
+
+	config_file := filepath.Join(tmp_dir, "config.yml")
+	config := fmt.Sprintf(testConfig, server.ArtifactsURL(), filepath.Join(tmp_dir, "cache"))
+	if err = ioutil.WriteFile(config_file, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	flags := &Flags{
+		ConfigFile: config_file,
+		OutputDir: filepath.Join(tmp_dir, "output"),
+		TestHistory: DefaultTestHistory,
+		Concurrency: DefaultConcurrency,
+	}
+	if err = matrix_benchWrapper(nil, flags); err != nil {
+		t.Fatalf("matrix_benchmarks failed: %v", err)
+	}
+
+	job_dir := filepath.Join(flags.OutputDir, "periodic-ci-org-repo-main-e2e")
+
+	expected_files := map[string]string{
+		"test-properties/test_passed": "",
+		"test-properties/ansible_tasks_ok": "",
+		"gpu-burn/pod.log": "GPU 0: OK\n",
+	}
+	for filename, expected_content := range expected_files {
+		matches, err := filepath.Glob(filepath.Join(job_dir, "*", filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) == 0 {
+			t.Errorf("no %s file generated in %s", filename, job_dir)
+			continue
+		}
+		if expected_content == "" {
+			continue
+		}
+		content, err := ioutil.ReadFile(matches[0])
+		if err != nil || string(content) != expected_content {
+			t.Errorf("%s: got %q, %v, expected %q", matches[0], content, err, expected_content)
+		}
+	}
+
+	passed, _ := filepath.Glob(filepath.Join(job_dir, "*", "test-properties", "test_passed"))
+	if len(passed) != 2 {
+		t.Errorf("got %d test-properties results, expected 2", len(passed))
+	}
+}
@@ -0,0 +1,354 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+// APIPrefix is the path of the read-only JSON API. The API encodes
+// the v1 types with their JSON tags.
+//
+//   /api/v1/dashboards
+//       refresh status of the dashboards
+//   /api/v1/dashboards/<dashboard>
+//       populated matrices of the dashboard, see v1.MatricesExport
+//   /api/v1/dashboards/<dashboard>/matrices
+//       summary of the matrices of the dashboard
+//   /api/v1/dashboards/<dashboard>/matrices/<matrix>/tests
+//       tests of the matrix, with their last build matching the filters
+//   /api/v1/dashboards/<dashboard>/tests/<prow name>
+//       test and its history, reduced to the builds matching the filters
+//   /api/v1/dashboards/<dashboard>/tests/<prow name>/builds/<build id>
+//       full result of a build
+//
+// The tests and builds are filtered with the query parameters:
+//
+//   status             status of the build (see v1.TestResult.Status),
+//                      comma-separated
+//   operator_version   operator version of the build, or of its patch
+//                      versions (eg, "24.9" matches "24.9.1")
+//   openshift_version  OpenShift version of the build, or of its patch
+//                      versions
+const APIPrefix = "/api/v1/"
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type apiDashboard struct {
+	Name string `json:"name"`
+	LastRefresh v1.Timestamp `json:"last_refresh"`
+	LastAttempt v1.Timestamp `json:"last_attempt"`
+	RefreshError string `json:"refresh_error,omitempty"`
+}
+
+type apiMatrix struct {
+	Name string `json:"name"`
+	Description string `json:"description,omitempty"`
+	OperatorName string `json:"operator_name,omitempty"`
+	// test groups, sorted
+	TestGroups []string `json:"test_groups"`
+	Tests int `json:"tests"`
+	Stats *v1.MatrixStats `json:"stats,omitempty"`
+}
+
+// apiBuild is the summary of a build.
+type apiBuild struct {
+	BuildId string `json:"build_id"`
+	Status string `json:"status"`
+	Result string `json:"result"`
+	FinishTime v1.Timestamp `json:"finish_time"`
+	OperatorVersion string `json:"operator_version"`
+	OpenShiftVersion string `json:"openshift_version"`
+	URL string `json:"url,omitempty"`
+}
+
+type apiTest struct {
+	ProwName string `json:"prow_name"`
+	Matrix string `json:"matrix"`
+	TestGroup string `json:"test_group"`
+	TestName string `json:"test_name,omitempty"`
+	Branch string `json:"branch,omitempty"`
+	OperatorVersion string `json:"operator_version,omitempty"`
+	Variant string `json:"variant,omitempty"`
+	// newest build matching the filters, nil if none
+	LastBuild *apiBuild `json:"last_build"`
+	// number of builds matching the filters
+	Builds int `json:"builds"`
+	Regression *v1.Regression `json:"regression,omitempty"`
+	Stats *v1.TestStats `json:"stats,omitempty"`
+}
+
+// buildFilter selects the builds with the query parameters of a
+// request.
+type buildFilter struct {
+	statuses map[string]bool
+	operatorVersion string
+	openShiftVersion string
+}
+
+func newBuildFilter(r *http.Request) buildFilter {
+	query := r.URL.Query()
+	filter := buildFilter{
+		operatorVersion: query.Get("operator_version"),
+		openShiftVersion: query.Get("openshift_version"),
+	}
+	if status := query.Get("status"); status != "" {
+		filter.statuses = map[string]bool{}
+		for _, status := range strings.Split(status, ",") {
+			filter.statuses[strings.TrimSpace(status)] = true
+		}
+	}
+
+	return filter
+}
+
+func (f buildFilter) match(test_result *v1.TestResult) bool {
+	if f.statuses != nil && !f.statuses[test_result.Status()] {
+		return false
+	}
+	return v1.MatchVersion(test_result.OperatorVersion, f.operatorVersion) &&
+		v1.MatchVersion(test_result.OpenShiftVersion, f.openShiftVersion)
+}
+
+// builds returns the builds of `test` matching the filter, newest
+// first.
+func (f buildFilter) builds(test *v1.TestSpec) []*v1.TestResult {
+	builds := []*v1.TestResult{}
+	for _, test_result := range test.OldTests {
+		if f.match(test_result) {
+			builds = append(builds, test_result)
+		}
+	}
+
+	return builds
+}
+
+func newAPIBuild(test *v1.TestSpec, test_result *v1.TestResult) *apiBuild {
+	build := &apiBuild{
+		BuildId: test_result.BuildId,
+		Status: test_result.Status(),
+		Result: test_result.Result,
+		FinishTime: test_result.FinishTime,
+		OperatorVersion: test_result.OperatorVersion,
+		OpenShiftVersion: test_result.OpenShiftVersion,
+	}
+	if test.Matrix != nil && test.Matrix.ViewerURL != "" {
+		build.URL = test.Matrix.ViewerURL + "/" + test.ProwName + "/" + test_result.BuildId
+	}
+
+	return build
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		log.Warningf("Failed to encode the API response: %v", err)
+		status = http.StatusInternalServerError
+		content, _ = json.Marshal(apiError{Error: "error encoding the response: " + err.Error()})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(content, '\n'))
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, apiError{Error: msg})
+}
+
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeAPIError(w, http.StatusMethodNotAllowed, "the API is read-only")
+		return
+	}
+
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, APIPrefix), "/"), "/")
+	if path[0] != "dashboards" {
+		writeAPIError(w, http.StatusNotFound, "unknown API path " + r.URL.Path)
+		return
+	}
+
+	if len(path) == 1 {
+		dashboards := []apiDashboard{}
+		for _, dashboard := range s.dashboards {
+			status := dashboard.Status()
+			entry := apiDashboard{Name: status.Name, RefreshError: status.RefreshError}
+			if !status.LastRefresh.IsZero() {
+				entry.LastRefresh = v1.NewTimestamp(status.LastRefresh)
+			}
+			if !status.LastAttempt.IsZero() {
+				entry.LastAttempt = v1.NewTimestamp(status.LastAttempt)
+			}
+			dashboards = append(dashboards, entry)
+		}
+		writeJSON(w, http.StatusOK, dashboards)
+		return
+	}
+
+	dashboard := s.Dashboard(path[1])
+	if dashboard == nil {
+		writeAPIError(w, http.StatusNotFound, "unknown dashboard '" + path[1] + "'")
+		return
+	}
+	matricesSpec, last_refresh := dashboard.MatricesSpec()
+	if matricesSpec == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, "dashboard '" + dashboard.Name + "' not populated yet")
+		return
+	}
+
+	path = path[2:]
+	filter := newBuildFilter(r)
+	switch {
+	case len(path) == 0:
+		writeJSON(w, http.StatusOK, v1.NewMatricesExport(matricesSpec, last_refresh))
+	case len(path) == 1 && path[0] == "matrices":
+		s.serveAPIMatrices(w, matricesSpec)
+	case len(path) == 3 && path[0] == "matrices" && path[2] == "tests":
+		s.serveAPITests(w, matricesSpec, path[1], filter)
+	case len(path) == 2 && path[0] == "tests":
+		s.serveAPITest(w, matricesSpec, path[1], filter)
+	case len(path) == 4 && path[0] == "tests" && path[2] == "builds":
+		s.serveAPIBuild(w, matricesSpec, path[1], path[3])
+	default:
+		writeAPIError(w, http.StatusNotFound, "unknown API path " + r.URL.Path)
+	}
+}
+
+func (s *Server) serveAPIMatrices(w http.ResponseWriter, matricesSpec *v1.MatricesSpec) {
+	matrices := []apiMatrix{}
+	for matrix_name, test_matrix := range matricesSpec.Matrices {
+		matrix := apiMatrix{
+			Name: matrix_name,
+			Description: test_matrix.Description,
+			OperatorName: test_matrix.OperatorName,
+			TestGroups: []string{},
+			Stats: matricesSpec.Stats[matrix_name],
+		}
+		for test_group, tests := range test_matrix.Tests {
+			matrix.TestGroups = append(matrix.TestGroups, test_group)
+			matrix.Tests += len(tests)
+		}
+		sort.Strings(matrix.TestGroups)
+		matrices = append(matrices, matrix)
+	}
+	sort.Slice(matrices, func(i, j int) bool {
+		return matrices[i].Name < matrices[j].Name
+	})
+
+	writeJSON(w, http.StatusOK, matrices)
+}
+
+// serveAPITests lists the tests of a matrix. With a filter, only the
+// tests with a matching build are listed.
+func (s *Server) serveAPITests(w http.ResponseWriter, matricesSpec *v1.MatricesSpec, matrix_name string, filter buildFilter) {
+	test_matrix, ok := matricesSpec.Matrices[matrix_name]
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "unknown matrix '" + matrix_name + "'")
+		return
+	}
+	filtered := filter.statuses != nil || filter.operatorVersion != "" || filter.openShiftVersion != ""
+
+	tests := []apiTest{}
+	for test_group, group_tests := range test_matrix.Tests {
+		for test_idx := range group_tests {
+			test := &group_tests[test_idx]
+			builds := filter.builds(test)
+			if filtered && len(builds) == 0 {
+				continue
+			}
+
+			entry := apiTest{
+				ProwName: test.ProwName,
+				Matrix: matrix_name,
+				TestGroup: test_group,
+				TestName: test.TestName,
+				Branch: test.Branch,
+				OperatorVersion: test.OperatorVersion,
+				Variant: test.Variant,
+				Builds: len(builds),
+				Regression: test.Regression,
+				Stats: test.Stats,
+			}
+			if len(builds) != 0 {
+				entry.LastBuild = newAPIBuild(test, builds[0])
+			}
+			tests = append(tests, entry)
+		}
+	}
+	sort.Slice(tests, func(i, j int) bool {
+		if tests[i].TestGroup != tests[j].TestGroup {
+			return tests[i].TestGroup < tests[j].TestGroup
+		}
+		return tests[i].ProwName < tests[j].ProwName
+	})
+
+	writeJSON(w, http.StatusOK, tests)
+}
+
+// findTest returns the test of the `prow_name` job. When the job is
+// part of several matrices, the first one in the order of the matrix
+// and test group names is used, so that every request gets the same
+// test.
+func findTest(matricesSpec *v1.MatricesSpec, prow_name string) *v1.TestSpec {
+	matrix_names := []string{}
+	for matrix_name := range matricesSpec.Matrices {
+		matrix_names = append(matrix_names, matrix_name)
+	}
+	sort.Strings(matrix_names)
+
+	for _, matrix_name := range matrix_names {
+		test_matrix := matricesSpec.Matrices[matrix_name]
+
+		test_groups := []string{}
+		for test_group := range test_matrix.Tests {
+			test_groups = append(test_groups, test_group)
+		}
+		sort.Strings(test_groups)
+
+		for _, test_group := range test_groups {
+			tests := test_matrix.Tests[test_group]
+			for test_idx := range tests {
+				if tests[test_idx].ProwName == prow_name {
+					return &tests[test_idx]
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) serveAPITest(w http.ResponseWriter, matricesSpec *v1.MatricesSpec, prow_name string, filter buildFilter) {
+	test := findTest(matricesSpec, prow_name)
+	if test == nil {
+		writeAPIError(w, http.StatusNotFound, "unknown test '" + prow_name + "'")
+		return
+	}
+
+	// the populated test is shared with the other requests
+	filtered := *test
+	filtered.OldTests = filter.builds(test)
+
+	writeJSON(w, http.StatusOK, v1.NewTestExport(&filtered))
+}
+
+func (s *Server) serveAPIBuild(w http.ResponseWriter, matricesSpec *v1.MatricesSpec, prow_name, build_id string) {
+	test := findTest(matricesSpec, prow_name)
+	if test == nil {
+		writeAPIError(w, http.StatusNotFound, "unknown test '" + prow_name + "'")
+		return
+	}
+
+	for _, test_result := range test.OldTests {
+		if test_result.BuildId == build_id {
+			writeJSON(w, http.StatusOK, test_result)
+			return
+		}
+	}
+	writeAPIError(w, http.StatusNotFound, "unknown build '" + build_id + "' of test '" + prow_name + "'")
+}
@@ -0,0 +1,178 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+var testRefreshTime = time.Date(2024, 9, 2, 8, 0, 0, 0, time.UTC)
+
+func testAPIBuild(build_id string, passed bool, operator_version, openshift_version string) *v1.TestResult {
+	result := "FAILURE"
+	if passed {
+		result = "SUCCESS"
+	}
+	return &v1.TestResult{
+		BuildId: build_id,
+		Passed: passed,
+		Result: result,
+		StepExecuted: true,
+		FinishTime: v1.NewTimestamp(time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)),
+		OperatorVersion: operator_version,
+		OpenShiftVersion: openshift_version,
+		Messages: map[v1.TestMessageType]map[string]string{
+			v1.TestMessageTypeInfo: {"cluster": "4 nodes"},
+		},
+	}
+}
+
+func newTestAPIServer(t *testing.T) *Server {
+	s, err := NewServer(&Flags{
+		ConfigFiles: []string{"examples/gpu-operator.yml"},
+		TemplateFiles: []string{DefaultTemplateFile},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matrix := &v1.MatrixSpec{
+		Name: "nightly",
+		Description: "Nightly",
+		ViewerURL: "https://prow.example.com/view/gs/test-bucket/logs",
+	}
+	stable := v1.TestSpec{ProwName: "periodic-e2e-24.9", OperatorVersion: "24.9", Matrix: matrix,
+		OldTests: []*v1.TestResult{
+			testAPIBuild("103", true, "24.9.1", "4.18.2"),
+			testAPIBuild("102", false, "24.9.1", "4.17.5"),
+			testAPIBuild("101", false, "24.9.0", "4.18.1"),
+		}}
+	master := v1.TestSpec{ProwName: "periodic-e2e-master", OperatorVersion: "master", Matrix: matrix,
+		OldTests: []*v1.TestResult{testAPIBuild("201", false, "25.3.0", "4.18.2")}}
+	matrix.Tests = map[string][]v1.TestSpec{
+		"0_stable|Stable": {stable},
+		"1_master|Master": {master},
+	}
+
+	dashboard := s.Dashboard("gpu-operator")
+	dashboard.matricesSpec = &v1.MatricesSpec{
+		Version: v1.Version,
+		Matrices: map[string]v1.MatrixSpec{"nightly": *matrix},
+	}
+	dashboard.lastRefresh = testRefreshTime
+
+	return s
+}
+
+func getJSON(t *testing.T, handler http.Handler, url string, expected_status int, value interface{}) {
+	resp := get(t, handler, url)
+	if resp.Code != expected_status {
+		t.Fatalf("got status %d for %s, expected %d: %s", resp.Code, url, expected_status, resp.Body.String())
+	} else if resp.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected content type of %s: %s", url, resp.Header().Get("Content-Type"))
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), value); err != nil {
+		t.Fatalf("invalid JSON response for %s: %v", url, err)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	s := newTestAPIServer(t)
+	handler := s.Handler()
+
+	dashboards := []apiDashboard{}
+	getJSON(t, handler, "/api/v1/dashboards", http.StatusOK, &dashboards)
+	if len(dashboards) != 1 || dashboards[0].Name != "gpu-operator" || !dashboards[0].LastRefresh.Valid {
+		t.Errorf("unexpected dashboards: %+v", dashboards)
+	}
+
+	matrices := []apiMatrix{}
+	getJSON(t, handler, "/api/v1/dashboards/gpu-operator/matrices", http.StatusOK, &matrices)
+	if len(matrices) != 1 || matrices[0].Name != "nightly" || matrices[0].Tests != 2 ||
+		len(matrices[0].TestGroups) != 2 || matrices[0].TestGroups[0] != "0_stable|Stable" {
+		t.Errorf("unexpected matrices: %+v", matrices)
+	}
+
+	tests := []apiTest{}
+	getJSON(t, handler, "/api/v1/dashboards/gpu-operator/matrices/nightly/tests", http.StatusOK, &tests)
+	if len(tests) != 2 || tests[0].ProwName != "periodic-e2e-24.9" || tests[0].Builds != 3 ||
+		tests[0].LastBuild == nil || tests[0].LastBuild.BuildId != "103" || tests[0].LastBuild.Status != "success" ||
+		tests[0].LastBuild.URL != "https://prow.example.com/view/gs/test-bucket/logs/periodic-e2e-24.9/103" {
+		t.Errorf("unexpected tests: %+v", tests)
+	}
+
+	// is 24.9 green on 4.17?
+	getJSON(t, handler, "/api/v1/dashboards/gpu-operator/matrices/nightly/tests?operator_version=24.9&openshift_version=4.17",
+		http.StatusOK, &tests)
+	if len(tests) != 1 || tests[0].Builds != 1 || tests[0].LastBuild.BuildId != "102" || tests[0].LastBuild.Status != "step_failed" {
+		t.Errorf("unexpected tests of 24.9 on 4.17: %+v", tests)
+	}
+	getJSON(t, handler, "/api/v1/dashboards/gpu-operator/matrices/nightly/tests?openshift_version=4.18&status=success",
+		http.StatusOK, &tests)
+	if len(tests) != 1 || tests[0].LastBuild.BuildId != "103" {
+		t.Errorf("unexpected successful tests on 4.18: %+v", tests)
+	}
+
+	test := v1.TestExport{}
+	getJSON(t, handler, "/api/v1/dashboards/gpu-operator/tests/periodic-e2e-24.9?status=step_failed,success&openshift_version=4.18",
+		http.StatusOK, &test)
+	if test.ProwName != "periodic-e2e-24.9" || len(test.OldTests) != 2 ||
+		test.OldTests[0].BuildId != "103" || test.OldTests[1].BuildId != "101" {
+		t.Errorf("unexpected history of the test: %+v", test)
+	}
+
+	build := v1.TestResult{}
+	getJSON(t, handler, "/api/v1/dashboards/gpu-operator/tests/periodic-e2e-24.9/builds/102", http.StatusOK, &build)
+	if build.BuildId != "102" || build.OpenShiftVersion != "4.17.5" || build.Messages[v1.TestMessageTypeInfo]["cluster"] != "4 nodes" {
+		t.Errorf("unexpected build: %+v", build)
+	}
+
+	exported := v1.MatricesExport{}
+	getJSON(t, handler, "/api/v1/dashboards/gpu-operator", http.StatusOK, &exported)
+	if !exported.GenerationTime.Time.Equal(testRefreshTime) || len(exported.Matrices["nightly"].Tests) != 2 {
+		t.Errorf("unexpected export of the dashboard: %+v", exported)
+	}
+
+	api_error := apiError{}
+	for _, url := range []string{
+		"/api/v1/unknown",
+		"/api/v1/dashboards/unknown/matrices",
+		"/api/v1/dashboards/gpu-operator/matrices/unknown/tests",
+		"/api/v1/dashboards/gpu-operator/tests/unknown",
+		"/api/v1/dashboards/gpu-operator/tests/periodic-e2e-24.9/builds/999",
+	} {
+		getJSON(t, handler, url, http.StatusNotFound, &api_error)
+		if api_error.Error == "" {
+			t.Errorf("no error message for %s", url)
+		}
+	}
+
+	s.Dashboard("gpu-operator").matricesSpec = nil
+	getJSON(t, handler, "/api/v1/dashboards/gpu-operator/matrices", http.StatusServiceUnavailable, &api_error)
+}
+
+func TestAPITestInSeveralMatrices(t *testing.T) {
+	s := newTestAPIServer(t)
+	handler := s.Handler()
+
+	// the 24.9 job is also part of a weekly matrix
+	dashboard := s.Dashboard("gpu-operator")
+	weekly := &v1.MatrixSpec{Name: "weekly", Description: "Weekly"}
+	weekly.Tests = map[string][]v1.TestSpec{
+		"0_stable|Stable": {{ProwName: "periodic-e2e-24.9", OperatorVersion: "24.9", Matrix: weekly,
+			OldTests: []*v1.TestResult{testAPIBuild("901", true, "24.9.1", "4.18.2")}}},
+	}
+	dashboard.matricesSpec.Matrices["weekly"] = *weekly
+
+	// the matrices are maps, repeat the request to catch a random order
+	for i := 0; i < 20; i++ {
+		test := v1.TestExport{}
+		getJSON(t, handler, "/api/v1/dashboards/gpu-operator/tests/periodic-e2e-24.9", http.StatusOK, &test)
+		if len(test.OldTests) != 3 || test.OldTests[0].BuildId != "103" {
+			t.Fatalf("got the test of the wrong matrix: %+v", test)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package serve
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	cli "github.com/urfave/cli/v2"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+	matrix_tpl "github.com/openshift-psap/ci-dashboard/pkg/template/matrix"
+)
+
+const (
+	DefaultConfigFile = "examples/gpu-operator.yml"
+	DefaultTemplateFile = "templates/daily_matrix.tmpl.html"
+	DefaultMailTemplateFile = "templates/daily_matrix.mail.tmpl.md"
+	DefaultListenAddress = ":8080"
+	DefaultRefreshInterval = time.Hour
+	DefaultTestHistory = -1
+	DefaultConcurrency = -1
+	DefaultTimezone = "Local"
+	DefaultDateFormat = v1.DateFormat
+	DefaultGenerationDateFormat = matrix_tpl.DefaultGenerationDateFormat
+	// time given to the running requests to complete on shutdown
+	ShutdownTimeout = 10 * time.Second
+)
+
+var log = logrus.New()
+
+func GetLogger() *logrus.Logger {
+	return log
+}
+
+type Flags struct {
+	ConfigFiles []string
+	TemplateFiles []string
+	ListenAddress string
+	RefreshInterval time.Duration
+	TestHistory int
+	Concurrency int
+	Timezone string
+	DateFormat string
+	GenerationDateFormat string
+
+	location *time.Location
+}
+
+func BuildCommand() *cli.Command {
+	// Create a flags struct to hold our flags
+	serveFlags := Flags{}
+
+	// Create the 'serve' command
+	serve := cli.Command{}
+	serve.Name = "serve"
+	serve.Usage = "Serve the daily test matrices over HTTP, and refresh them in the background"
+	serve.Action = func(c *cli.Context) error {
+		serveFlags.ConfigFiles = c.StringSlice("config-file")
+		serveFlags.TemplateFiles = c.StringSlice("template")
+		return serveWrapper(c, &serveFlags)
+	}
+
+	// Setup the flags for this command
+	serve.Flags = []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:        "config-file",
+			Aliases:     []string{"c"},
+			Usage:       "Configuration files of the served matrices, each served under the base name of its file",
+			Value:       cli.NewStringSlice(DefaultConfigFile),
+			EnvVars:     []string{"CI_DASHBOARD_SERVE_CONFIG_FILE"},
+		},
+		&cli.StringSliceFlag{
+			Name:        "template",
+			Aliases:     []string{"t"},
+			Usage:       "Template files of the pages of the matrices, each served under its base name without .tmpl",
+			Value:       cli.NewStringSlice(DefaultTemplateFile, DefaultMailTemplateFile),
+			EnvVars:     []string{"CI_DASHBOARD_SERVE_TEMPLATE_FILE"},
+		},
+		&cli.StringFlag{
+			Name:        "listen",
+			Aliases:     []string{"l"},
+			Usage:       "Address on which the HTTP server listens",
+			Destination: &serveFlags.ListenAddress,
+			Value:       DefaultListenAddress,
+			EnvVars:     []string{"CI_DASHBOARD_SERVE_LISTEN"},
+		},
+		&cli.DurationFlag{
+			Name:        "refresh-interval",
+			Usage:       "Interval between two refreshes of the matrices",
+			Destination: &serveFlags.RefreshInterval,
+			Value:       DefaultRefreshInterval,
+			EnvVars:     []string{"CI_DASHBOARD_SERVE_REFRESH_INTERVAL"},
+		},
+		&cli.IntFlag{
+			Name:        "test-history",
+			Aliases:     []string{"th"},
+			Usage:       "Number of tests to fetch",
+			Destination: &serveFlags.TestHistory,
+			Value:       DefaultTestHistory,
+			EnvVars:     []string{"CI_DASHBOARD_SERVE_TEST_HISTORY"},
+		},
+		&cli.IntFlag{
+			Name:        "concurrency",
+			Aliases:     []string{"j"},
+			Usage:       "Maximum number of tests and builds to fetch in parallel",
+			Destination: &serveFlags.Concurrency,
+			Value:       DefaultConcurrency,
+			EnvVars:     []string{"CI_DASHBOARD_SERVE_CONCURRENCY"},
+		},
+		&cli.StringFlag{
+			Name:        "timezone",
+			Aliases:     []string{"tz"},
+			Usage:       "Time zone of the dates of the matrices (eg, UTC, Europe/Paris)",
+			Destination: &serveFlags.Timezone,
+			Value:       DefaultTimezone,
+			EnvVars:     []string{"CI_DASHBOARD_SERVE_TIMEZONE"},
+		},
+		&cli.StringFlag{
+			Name:        "date-format",
+			Usage:       "Go layout of the dates of the tests",
+			Destination: &serveFlags.DateFormat,
+			Value:       DefaultDateFormat,
+			EnvVars:     []string{"CI_DASHBOARD_SERVE_DATE_FORMAT"},
+		},
+		&cli.StringFlag{
+			Name:        "generation-date-format",
+			Usage:       "Go layout of the refresh dates of the matrices",
+			Destination: &serveFlags.GenerationDateFormat,
+			Value:       DefaultGenerationDateFormat,
+			EnvVars:     []string{"CI_DASHBOARD_SERVE_GENERATION_DATE_FORMAT"},
+		},
+	}
+
+	return &serve
+}
+
+// validate checks the flags, and resolves their time zone.
+func (f *Flags) validate() error {
+	if f.RefreshInterval <= 0 {
+		return fmt.Errorf("invalid refresh interval %s, it must be positive", f.RefreshInterval)
+	}
+
+	location, err := time.LoadLocation(f.Timezone)
+	if err != nil {
+		return fmt.Errorf("invalid timezone '%s': %v", f.Timezone, err)
+	}
+	f.location = location
+
+	return nil
+}
+
+func serveWrapper(c *cli.Context, f *Flags) error {
+	if err := f.validate(); err != nil {
+		return err
+	}
+
+	s, err := NewServer(f)
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	refresh_done := make(chan struct{})
+	go func() {
+		s.RefreshLoop(f.RefreshInterval, stop)
+		close(refresh_done)
+	}()
+
+	http_server := &http.Server{Addr: f.ListenAddress, Handler: s.Handler()}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	server_error := make(chan error, 1)
+	go func() {
+		log.Infof("Serving %d dashboard(s) on %s, refreshed every %s",
+			len(s.Dashboards()), f.ListenAddress, f.RefreshInterval)
+		server_error <- http_server.ListenAndServe()
+	}()
+
+	select {
+	case err = <-server_error:
+		err = fmt.Errorf("error serving the dashboards: %v", err)
+	case sig := <-signals:
+		log.Infof("Received %s, shutting down", sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		if err = http_server.Shutdown(ctx); err != nil {
+			err = fmt.Errorf("error shutting down the server: %v", err)
+		}
+	}
+
+	// wait for the refresh in progress, so that the artifacts cache is
+	// not left half-written
+	close(stop)
+	<-refresh_done
+
+	return err
+}
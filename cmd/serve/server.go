@@ -0,0 +1,364 @@
+package serve
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+	"github.com/openshift-psap/ci-dashboard/pkg/artifacts"
+	"github.com/openshift-psap/ci-dashboard/pkg/config"
+	"github.com/openshift-psap/ci-dashboard/pkg/populate"
+	matrix_tpl "github.com/openshift-psap/ci-dashboard/pkg/template/matrix"
+)
+
+// Dashboard is the last populated state of a matrix configuration.
+type Dashboard struct {
+	// name of the dashboard in the URLs, the base name of its
+	// configuration file
+	Name string
+	ConfigFile string
+
+	mutex sync.RWMutex
+	// nil until the first successful refresh, never modified once
+	// populated
+	matricesSpec *v1.MatricesSpec
+	lastRefresh time.Time
+	lastAttempt time.Time
+	refreshError error
+}
+
+// DashboardStatus is the refresh status of a dashboard.
+type DashboardStatus struct {
+	Name string
+	// zero if the dashboard was never populated
+	LastRefresh time.Time
+	LastAttempt time.Time
+	// error of the last refresh, "" if it succeeded
+	RefreshError string
+}
+
+// Status returns the refresh status of the dashboard.
+func (d *Dashboard) Status() DashboardStatus {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	status := DashboardStatus{
+		Name: d.Name,
+		LastRefresh: d.lastRefresh,
+		LastAttempt: d.lastAttempt,
+	}
+	if d.refreshError != nil {
+		status.RefreshError = d.refreshError.Error()
+	}
+
+	return status
+}
+
+// MatricesSpec returns the last populated matrices of the dashboard,
+// and the time of their refresh. The matrices are nil if the
+// dashboard was never populated.
+func (d *Dashboard) MatricesSpec() (*v1.MatricesSpec, time.Time) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.matricesSpec, d.lastRefresh
+}
+
+// page is a template rendered for each dashboard.
+type page struct {
+	// name of the page in the URLs, the base name of its template
+	// without the .tmpl suffix
+	Name string
+	TemplateFile string
+	ContentType string
+}
+
+func newPage(template_file string) page {
+	name := strings.Replace(filepath.Base(template_file), ".tmpl", "", 1)
+
+	content_type := mime.TypeByExtension(filepath.Ext(name))
+	if filepath.Ext(name) == ".md" {
+		content_type = "text/markdown; charset=utf-8"
+	} else if content_type == "" {
+		content_type = "text/plain; charset=utf-8"
+	}
+
+	return page{Name: name, TemplateFile: template_file, ContentType: content_type}
+}
+
+type Server struct {
+	flags *Flags
+	// sorted by name
+	dashboards []*Dashboard
+	dashboardsByName map[string]*Dashboard
+	pages []page
+
+	// serializes the refreshes
+	refreshMutex sync.Mutex
+}
+
+// NewServer creates the server of the dashboards of `flags.ConfigFiles`.
+// The dashboards are not populated until the first refresh.
+func NewServer(flags *Flags) (*Server, error) {
+	if len(flags.ConfigFiles) == 0 {
+		return nil, fmt.Errorf("no configuration file to serve")
+	} else if len(flags.TemplateFiles) == 0 {
+		return nil, fmt.Errorf("no template to render")
+	}
+
+	s := &Server{
+		flags: flags,
+		dashboardsByName: map[string]*Dashboard{},
+	}
+
+	for _, config_file := range flags.ConfigFiles {
+		name := strings.TrimSuffix(filepath.Base(config_file), filepath.Ext(config_file))
+		if name == "api" || name == "healthz" {
+			return nil, fmt.Errorf("configuration file %s cannot be served, '%s' is a reserved name", config_file, name)
+		} else if previous, exists := s.dashboardsByName[name]; exists {
+			return nil, fmt.Errorf("configuration files %s and %s have the same name '%s'",
+				previous.ConfigFile, config_file, name)
+		}
+
+		dashboard := &Dashboard{Name: name, ConfigFile: config_file}
+		s.dashboards = append(s.dashboards, dashboard)
+		s.dashboardsByName[name] = dashboard
+	}
+	sort.Slice(s.dashboards, func(i, j int) bool {
+		return s.dashboards[i].Name < s.dashboards[j].Name
+	})
+
+	page_names := map[string]bool{}
+	for _, template_file := range flags.TemplateFiles {
+		template_page := newPage(template_file)
+		if page_names[template_page.Name] {
+			return nil, fmt.Errorf("several templates have the same name '%s'", template_page.Name)
+		}
+		page_names[template_page.Name] = true
+		s.pages = append(s.pages, template_page)
+	}
+
+	return s, nil
+}
+
+// Dashboards returns the dashboards of the server, sorted by name.
+func (s *Server) Dashboards() []*Dashboard {
+	return s.dashboards
+}
+
+// Dashboard returns the dashboard called `name`, or nil.
+func (s *Server) Dashboard(name string) *Dashboard {
+	return s.dashboardsByName[name]
+}
+
+// now is the current time of the pages, or the time of the recording
+// when the artifacts are replayed.
+func now() time.Time {
+	if replay_time, replaying := artifacts.ReplayTime(); replaying {
+		return replay_time
+	}
+	return time.Now()
+}
+
+func (s *Server) populate(dashboard *Dashboard) (*v1.MatricesSpec, error) {
+	matricesSpec, err := config.ParseMatricesConfigFile(dashboard.ConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file: %v", err)
+	}
+
+	if err = populate.PopulateTestMatrices(matricesSpec, s.flags.TestHistory, s.flags.Concurrency); err != nil {
+		return nil, fmt.Errorf("error fetching the matrix results: %v", err)
+	}
+
+	populate.PopulateTestStepLogs(matricesSpec)
+
+	return matricesSpec, nil
+}
+
+// Refresh populates the dashboard again. On failure, the previously
+// populated matrices remain served.
+func (s *Server) Refresh(dashboard *Dashboard) error {
+	s.refreshMutex.Lock()
+	defer s.refreshMutex.Unlock()
+
+	start := time.Now()
+	matricesSpec, err := s.populate(dashboard)
+
+	dashboard.mutex.Lock()
+	defer dashboard.mutex.Unlock()
+
+	dashboard.lastAttempt = now()
+	dashboard.refreshError = err
+	if err != nil {
+		log.Warningf("Failed to refresh the dashboard '%s': %v", dashboard.Name, err)
+		return err
+	}
+
+	dashboard.matricesSpec = matricesSpec
+	dashboard.lastRefresh = dashboard.lastAttempt
+	log.Infof("Dashboard '%s' refreshed in %s", dashboard.Name, time.Since(start).Round(time.Millisecond))
+
+	return nil
+}
+
+// RefreshAll refreshes all the dashboards, and returns the number of
+// failed refreshes.
+func (s *Server) RefreshAll() int {
+	failures := 0
+	for _, dashboard := range s.dashboards {
+		if err := s.Refresh(dashboard); err != nil {
+			failures += 1
+		}
+	}
+
+	return failures
+}
+
+// RefreshLoop refreshes all the dashboards immediately, then every
+// `interval`, until `stop` is closed.
+func (s *Server) RefreshLoop(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.RefreshAll()
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Handler returns the HTTP handler of the server:
+//
+//   /                   index of the dashboards, with their refresh status
+//   /healthz            liveness probe
+//   /api/v1/            JSON API, see APIPrefix
+//   /<dashboard>/       redirects to the first page of the dashboard
+//   /<dashboard>/<page> page of the dashboard, rendered from its template
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc(APIPrefix, s.serveAPI)
+	mux.HandleFunc("/", s.serveDashboards)
+
+	return mux
+}
+
+func (s *Server) serveDashboards(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/" {
+		s.serveIndex(w, r)
+		return
+	}
+
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	dashboard := s.Dashboard(path[0])
+	if dashboard == nil || len(path) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	if len(path) == 1 || path[1] == "" {
+		http.Redirect(w, r, "/" + dashboard.Name + "/" + s.pages[0].Name, http.StatusFound)
+		return
+	}
+
+	for _, template_page := range s.pages {
+		if template_page.Name == path[1] {
+			s.servePage(w, dashboard, template_page)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func (s *Server) servePage(w http.ResponseWriter, dashboard *Dashboard, template_page page) {
+	matricesSpec, last_refresh := dashboard.MatricesSpec()
+	if matricesSpec == nil {
+		status := dashboard.Status()
+		msg := fmt.Sprintf("Dashboard '%s' not populated yet", dashboard.Name)
+		if status.RefreshError != "" {
+			msg += ", the last refresh failed: " + status.RefreshError
+		}
+		http.Error(w, msg, http.StatusServiceUnavailable)
+		return
+	}
+
+	generated, err := matrix_tpl.Generate(template_page.TemplateFile, matricesSpec, matrix_tpl.Options{
+		Now: last_refresh,
+		Location: s.flags.location,
+		DateFormat: s.flags.DateFormat,
+		GenerationDateFormat: s.flags.GenerationDateFormat,
+	})
+	if err != nil {
+		log.Warningf("Failed to render the page %s of the dashboard '%s': %v", template_page.Name, dashboard.Name, err)
+		http.Error(w, fmt.Sprintf("error rendering the page: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", template_page.ContentType)
+	w.Header().Set("Last-Modified", last_refresh.UTC().Format(http.TimeFormat))
+	w.Write(generated)
+}
+
+const indexTemplate = `<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>CI dashboards</title>
+  </head>
+  <body>
+    <h1>CI dashboards</h1>
+    <table>
+      <tr><th>Dashboard</th><th>Pages</th><th>Last refresh</th><th>Refresh error</th></tr>
+      {{- range .Dashboards }}
+      <tr class="dashboard" id="dashboard-{{ .Name }}">
+        <td>{{ .Name }}</td>
+        <td>{{ $name := .Name }}{{ range $.Pages }}<a href="/{{ $name }}/{{ .Name }}">{{ .Name }}</a> {{ end }}</td>
+        <td class="last_refresh">{{ if .LastRefresh.IsZero }}never{{ else }}{{ .LastRefresh.Format $.DateFormat }}{{ end }}</td>
+        <td class="refresh_error">{{ if .RefreshError }}<pre>{{ .RefreshError }}</pre>(at {{ .LastAttempt.Format $.DateFormat }}){{ end }}</td>
+      </tr>
+      {{- end }}
+    </table>
+    <p>JSON API: <a href="/api/v1/dashboards">/api/v1/dashboards</a></p>
+  </body>
+</html>
+`
+
+var index = template.Must(template.New("index").Parse(indexTemplate))
+
+func (s *Server) serveIndex(w http.ResponseWriter, r *http.Request) {
+	statuses := []DashboardStatus{}
+	for _, dashboard := range s.dashboards {
+		status := dashboard.Status()
+		status.LastRefresh = status.LastRefresh.In(s.flags.location)
+		status.LastAttempt = status.LastAttempt.In(s.flags.location)
+		statuses = append(statuses, status)
+	}
+
+	content := bytes.Buffer{}
+	err := index.Execute(&content, struct {
+		Dashboards []DashboardStatus
+		Pages []page
+		DateFormat string
+	}{statuses, s.pages, s.flags.GenerationDateFormat})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error rendering the index: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(content.Bytes())
+}
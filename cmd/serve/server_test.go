@@ -0,0 +1,167 @@
+package serve
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift-psap/ci-dashboard/pkg/fakeprow"
+)
+
+const testConfig = `
+version: v1
+test_history: 5
+matrices:
+  nightly:
+    description: Nightly
+    operator_name: Test Operator
+    viewer_url: https://prow.example.com/view/gs/test-bucket/logs
+    artifacts_url: %s
+    artifacts_cache: %s
+    prow_config: periodic-ci-org-repo
+    prow_step: test-step
+    tests:
+      0_main|Main branch:
+      - branch: main
+        test_name: e2e
+        operator_version: "1.8"
+`
+
+func get(t *testing.T, handler http.Handler, url string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", url, nil))
+	return recorder
+}
+
+func TestServer(t *testing.T) {
+	server, err := fakeprow.NewServer(&fakeprow.Bucket{
+		Jobs: []fakeprow.Job{{
+			Name: "periodic-ci-org-repo-main-e2e",
+			StepDir: "artifacts/e2e/test-step/artifacts",
+			Builds: []fakeprow.Build{
+				{Id: "1000", Result: "SUCCESS", Timestamp: 1600000000, StepResult: "SUCCESS"},
+				{
+					Id: "1001", Result: "FAILURE", Timestamp: 1600086400, StepResult: "FAILURE",
+					Failure: "step failed",
+				},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	tmp_dir, err := ioutil.TempDir("", "ci-dashboard-serve-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp_dir)
+
+	config_file := filepath.Join(tmp_dir, "nightly.yml")
+	config := fmt.Sprintf(testConfig, server.ArtifactsURL(), filepath.Join(tmp_dir, "cache"))
+	if err = ioutil.WriteFile(config_file, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	flags := &Flags{
+		ConfigFiles: []string{config_file},
+		TemplateFiles: []string{
+			filepath.Join("..", "..", DefaultTemplateFile),
+			filepath.Join("..", "..", DefaultMailTemplateFile),
+		},
+		RefreshInterval: DefaultRefreshInterval,
+		TestHistory: DefaultTestHistory,
+		Concurrency: DefaultConcurrency,
+		Timezone: "UTC",
+		DateFormat: "02/01/2006 15:04 MST",
+		GenerationDateFormat: DefaultGenerationDateFormat,
+	}
+	if err = flags.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewServer(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := s.Handler()
+
+	if resp := get(t, handler, "/nightly/daily_matrix.html"); resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d before the first refresh, expected 503", resp.Code)
+	}
+	if resp := get(t, handler, "/"); !strings.Contains(resp.Body.String(), `<td class="last_refresh">never</td>`) {
+		t.Errorf("the index does not show the missing refresh:\n%s", resp.Body.String())
+	}
+
+	if failures := s.RefreshAll(); failures != 0 {
+		t.Fatalf("%d refreshes failed", failures)
+	}
+
+	resp := get(t, handler, "/nightly/daily_matrix.html")
+	if resp.Code != http.StatusOK || !strings.HasPrefix(resp.Header().Get("Content-Type"), "text/html") ||
+		!strings.Contains(resp.Body.String(), "periodic-ci-org-repo-main-e2e/1001") {
+		t.Errorf("unexpected matrix page (status %d):\n%s", resp.Code, resp.Body.String())
+	}
+	resp = get(t, handler, "/nightly/daily_matrix.mail.md")
+	if resp.Code != http.StatusOK || !strings.HasPrefix(resp.Header().Get("Content-Type"), "text/markdown") {
+		t.Errorf("unexpected mail page (status %d, %s)", resp.Code, resp.Header().Get("Content-Type"))
+	}
+	if resp = get(t, handler, "/nightly/"); resp.Code != http.StatusFound ||
+		resp.Header().Get("Location") != "/nightly/daily_matrix.html" {
+		t.Errorf("the dashboard does not redirect to its first page (status %d)", resp.Code)
+	}
+	for _, url := range []string{"/unknown/", "/nightly/unknown.html", "/nightly/daily_matrix.html/more"} {
+		if resp = get(t, handler, url); resp.Code != http.StatusNotFound {
+			t.Errorf("got status %d for %s, expected 404", resp.Code, url)
+		}
+	}
+	if resp = get(t, handler, "/healthz"); resp.Code != http.StatusOK {
+		t.Errorf("got status %d for the liveness probe", resp.Code)
+	}
+
+	status := s.Dashboard("nightly").Status()
+	if status.LastRefresh.IsZero() || status.RefreshError != "" {
+		t.Errorf("unexpected status after the refresh: %+v", status)
+	}
+	last_refresh := status.LastRefresh.UTC().Format(DefaultGenerationDateFormat)
+	if resp = get(t, handler, "/"); !strings.Contains(resp.Body.String(), `<td class="last_refresh">` + last_refresh + `</td>`) {
+		t.Errorf("the index does not show the last refresh %s:\n%s", last_refresh, resp.Body.String())
+	}
+
+	// failed refresh: the previous matrices remain served
+	if err = ioutil.WriteFile(config_file, []byte("version: [invalid"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err = s.Refresh(s.Dashboard("nightly")); err == nil {
+		t.Fatalf("the refresh of an invalid configuration succeeded")
+	}
+
+	status = s.Dashboard("nightly").Status()
+	if !status.LastAttempt.After(status.LastRefresh) || !strings.Contains(status.RefreshError, "error parsing config file") {
+		t.Errorf("unexpected status after the failed refresh: %+v", status)
+	}
+	if resp = get(t, handler, "/nightly/daily_matrix.html"); resp.Code != http.StatusOK {
+		t.Errorf("got status %d after the failed refresh, expected the previous matrix", resp.Code)
+	}
+	if resp = get(t, handler, "/"); !strings.Contains(resp.Body.String(), "error parsing config file") {
+		t.Errorf("the index does not show the refresh error:\n%s", resp.Body.String())
+	}
+}
+
+func TestServerDuplicateNames(t *testing.T) {
+	_, err := NewServer(&Flags{
+		ConfigFiles: []string{"a/nightly.yml", "b/nightly.yaml"},
+		TemplateFiles: []string{DefaultTemplateFile},
+	})
+	if err == nil {
+		t.Errorf("the dashboards of configuration files with the same name were accepted")
+	}
+}
@@ -7,5 +7,6 @@ require (
 	github.com/PuerkitoBio/goquery v1.6.1
 	github.com/sirupsen/logrus v1.7.0
 	github.com/urfave/cli/v2 v2.3.0
+	go.etcd.io/bbolt v1.3.6
 	sigs.k8s.io/yaml v1.2.0
 )
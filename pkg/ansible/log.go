@@ -0,0 +1,332 @@
+// Package ansible decodes the JSON logs (`_ansible.log.json`) of the
+// toolbox steps.
+package ansible
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+var (
+	EmptyLogError = fmt.Errorf("empty log")
+	NoStatsError = fmt.Errorf("no stats in the log")
+)
+
+// Stats are the counters of the tasks executed on a host.
+type Stats struct {
+	Ok int `json:"ok"`
+	Changed int `json:"changed"`
+	Failures int `json:"failures"`
+	Ignored int `json:"ignored"`
+	Rescued int `json:"rescued"`
+	Skipped int `json:"skipped"`
+	Unreachable int `json:"unreachable"`
+}
+
+func (s *Stats) add(other Stats) {
+	s.Ok += other.Ok
+	s.Changed += other.Changed
+	s.Failures += other.Failures
+	s.Ignored += other.Ignored
+	s.Rescued += other.Rescued
+	s.Skipped += other.Skipped
+	s.Unreachable += other.Unreachable
+}
+
+type Task struct {
+	Name string
+	// results of the task, indexed by host
+	Results map[string]TaskResult
+}
+
+// Role returns the role of the task, from its "role : task" name.
+func (t *Task) Role() string {
+	if idx := strings.Index(t.Name, " : "); idx != -1 {
+		return t.Name[:idx]
+	}
+	return ""
+}
+
+// ShortName returns the name of the task without its role.
+func (t *Task) ShortName() string {
+	if idx := strings.Index(t.Name, " : "); idx != -1 {
+		return t.Name[idx+3:]
+	}
+	return t.Name
+}
+
+type TaskResult struct {
+	Failed bool
+	Unreachable bool
+	Skipped bool
+	// the failure was ignored (`ignore_errors`)
+	Ignored bool
+
+	Msg string
+	Stderr string
+}
+
+// IsFailure tells if the task failed on the host, and if the failure
+// was not ignored.
+func (r *TaskResult) IsFailure() bool {
+	return (r.Failed || r.Unreachable) && !r.Ignored
+}
+
+// TaskFailure describes a task which failed on a host.
+type TaskFailure struct {
+	Play string
+	Role string
+	Task string
+	Host string
+	Msg string
+	Stderr string
+}
+
+type Play struct {
+	Name string
+	Tasks []Task
+}
+
+type Log struct {
+	Plays []Play
+	// counters of the tasks, indexed by host
+	Stats map[string]Stats
+}
+
+// Hosts returns the names of the hosts with stats, sorted.
+func (l *Log) Hosts() []string {
+	hosts := []string{}
+	for host := range l.Stats {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	return hosts
+}
+
+// FailedTasks returns the tasks which failed, in execution order, and
+// on the hosts sorted by name.
+func (l *Log) FailedTasks() []TaskFailure {
+	failures := []TaskFailure{}
+	for _, play := range l.Plays {
+		for _, task := range play.Tasks {
+			hosts := []string{}
+			for host := range task.Results {
+				hosts = append(hosts, host)
+			}
+			sort.Strings(hosts)
+
+			for _, host := range hosts {
+				result := task.Results[host]
+				if !result.IsFailure() {
+					continue
+				}
+				failures = append(failures, TaskFailure{
+					Play: play.Name,
+					Role: task.Role(),
+					Task: task.ShortName(),
+					Host: host,
+					Msg: result.Msg,
+					Stderr: result.Stderr,
+				})
+			}
+		}
+	}
+
+	return failures
+}
+
+// TotalStats returns the sum of the counters of all the hosts.
+func (l *Log) TotalStats() Stats {
+	total := Stats{}
+	for _, stats := range l.Stats {
+		total.add(stats)
+	}
+
+	return total
+}
+
+// logEntry is an element of the log. The toolbox logs are arrays of
+// plays followed by the stats, while ansible `json` callback generates
+// a single object with the plays and the stats.
+type logEntry struct {
+	Play *struct {
+		Name string `json:"name"`
+	} `json:"play"`
+	Tasks []taskEntry `json:"tasks"`
+
+	Plays []logEntry `json:"plays"`
+	Stats map[string]Stats `json:"stats"`
+}
+
+type taskEntry struct {
+	Task struct {
+		Name string `json:"name"`
+	} `json:"task"`
+	// decoded separately, so that an unexpected result does not
+	// invalidate the whole play
+	Hosts map[string]json.RawMessage `json:"hosts"`
+}
+
+type hostResultEntry struct {
+	Failed bool `json:"failed"`
+	Unreachable bool `json:"unreachable"`
+	Skipped bool `json:"skipped"`
+	IgnoreErrors bool `json:"_ansible_ignore_errors"`
+
+	Msg json.RawMessage `json:"msg"`
+	Stderr json.RawMessage `json:"stderr"`
+
+	// results of the loop items
+	Results []json.RawMessage `json:"results"`
+}
+
+// rawString returns `raw` if it is a JSON string, or its compact JSON
+// encoding otherwise (eg, when `msg` is a list).
+func rawString(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		return str
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw); err != nil {
+		return string(raw)
+	}
+	return compact.String()
+}
+
+func parseTaskResult(raw json.RawMessage) (TaskResult, bool) {
+	entry := hostResultEntry{}
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return TaskResult{}, false
+	}
+
+	result := TaskResult{
+		Failed: entry.Failed,
+		Unreachable: entry.Unreachable,
+		Skipped: entry.Skipped,
+		Ignored: entry.IgnoreErrors,
+		Msg: rawString(entry.Msg),
+		Stderr: rawString(entry.Stderr),
+	}
+
+	if result.Failed && result.Msg == "" && result.Stderr == "" {
+		// failure of a loop, use the message of the first failed item
+		for _, raw_item := range entry.Results {
+			item, ok := parseTaskResult(raw_item)
+			if ok && item.Failed {
+				result.Msg = item.Msg
+				result.Stderr = item.Stderr
+				break
+			}
+		}
+	}
+
+	return result, true
+}
+
+func (l *Log) addEntry(entry *logEntry) {
+	if entry.Play != nil || len(entry.Tasks) != 0 {
+		play := Play{Tasks: []Task{}}
+		if entry.Play != nil {
+			play.Name = entry.Play.Name
+		}
+		for _, task_entry := range entry.Tasks {
+			task := Task{Name: task_entry.Task.Name, Results: map[string]TaskResult{}}
+			for host, raw_result := range task_entry.Hosts {
+				if result, ok := parseTaskResult(raw_result); ok {
+					task.Results[host] = result
+				}
+			}
+			play.Tasks = append(play.Tasks, task)
+		}
+		l.Plays = append(l.Plays, play)
+	}
+
+	for i := range entry.Plays {
+		l.addEntry(&entry.Plays[i])
+	}
+
+	for host, stats := range entry.Stats {
+		l.Stats[host] = stats
+	}
+}
+
+// Parse decodes a toolbox ansible log. It never fails completely: the
+// returned Log contains everything that could be decoded, and the
+// error tells why the log is incomplete (empty, truncated, without
+// stats, ...).
+func Parse(content []byte) (*Log, error) {
+	log := &Log{Plays: []Play{}, Stats: map[string]Stats{}}
+
+	content = bytes.TrimSpace(content)
+	if len(content) == 0 {
+		return log, EmptyLogError
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	if content[0] == '{' {
+		entry := logEntry{}
+		if err := decoder.Decode(&entry); err != nil {
+			return log, fmt.Errorf("invalid log: %v", err)
+		}
+		log.addEntry(&entry)
+	} else {
+		if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+			return log, fmt.Errorf("invalid log: expected a JSON array or object")
+		}
+
+		// first invalid entry, the next ones are still decoded
+		var entry_err error
+		nb_entries := 0
+		for decoder.More() {
+			raw_entry := json.RawMessage{}
+			if err := decoder.Decode(&raw_entry); err != nil {
+				if err == io.ErrUnexpectedEOF {
+					return log, fmt.Errorf("truncated log after %d entries", nb_entries)
+				}
+				return log, fmt.Errorf("invalid entry #%d: %v", nb_entries, err)
+			}
+
+			nb_entries += 1
+			if raw_entry[0] != '{' {
+				// entries of another shape are ignored
+				continue
+			}
+
+			entry := logEntry{}
+			if err := json.Unmarshal(raw_entry, &entry); err != nil {
+				if entry_err == nil {
+					entry_err = fmt.Errorf("invalid entry #%d: %v", nb_entries - 1, err)
+				}
+				continue
+			}
+			log.addEntry(&entry)
+		}
+
+		if nb_entries == 0 {
+			return log, EmptyLogError
+		}
+		if _, err := decoder.Token(); err != nil {
+			return log, fmt.Errorf("truncated log after %d entries", nb_entries)
+		}
+		if entry_err != nil {
+			return log, entry_err
+		}
+	}
+
+	if len(log.Stats) == 0 {
+		return log, NoStatsError
+	}
+
+	return log, nil
+}
@@ -0,0 +1,83 @@
+package ansible
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		fixture string
+		hosts []string
+		total Stats
+		nb_plays int
+		// substring of the expected error, if any
+		err string
+	}{
+		{"toolbox.json", []string{"localhost"}, Stats{Ok: 17, Changed: 3, Failures: 1, Ignored: 2, Skipped: 4}, 1, ""},
+		{"multi_host.json", []string{"localhost", "worker-0"}, Stats{Ok: 8, Changed: 1, Failures: 1, Ignored: 1, Skipped: 2}, 1, ""},
+		{"callback_object.json", []string{"cluster"}, Stats{Ok: 8, Changed: 1, Rescued: 1}, 1, ""},
+		{"empty.json", []string{}, Stats{}, 0, EmptyLogError.Error()},
+		{"empty_array.json", []string{}, Stats{}, 0, EmptyLogError.Error()},
+		{"no_stats.json", []string{}, Stats{}, 1, NoStatsError.Error()},
+		{"truncated.json", []string{}, Stats{}, 0, "truncated log"},
+		{"unexpected_types.json", []string{"localhost"}, Stats{Ok: 4}, 0, "invalid entry #1"},
+		{"not_json.json", []string{}, Stats{}, 0, "invalid log"},
+	} {
+		content, err := ioutil.ReadFile(filepath.Join("testdata", tc.fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		log, err := Parse(content)
+		if log == nil {
+			t.Fatalf("%s: Parse returned a nil log", tc.fixture)
+		}
+
+		if tc.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.fixture, err)
+		} else if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s: got error %v, expected %q", tc.fixture, err, tc.err)
+		}
+
+		if hosts := log.Hosts(); !reflect.DeepEqual(hosts, tc.hosts) {
+			t.Errorf("%s: got hosts %v, expected %v", tc.fixture, hosts, tc.hosts)
+		}
+		if total := log.TotalStats(); total != tc.total {
+			t.Errorf("%s: got total stats %+v, expected %+v", tc.fixture, total, tc.total)
+		}
+		if len(log.Plays) != tc.nb_plays {
+			t.Errorf("%s: got %d plays, expected %d", tc.fixture, len(log.Plays), tc.nb_plays)
+		}
+	}
+}
+
+func TestFailedTasks(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/failed_tasks.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := Parse(content)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	play := "Run gpu_operator_deploy_from_operatorhub"
+	role := "gpu_operator_deploy_from_operatorhub"
+	expected := []TaskFailure{
+		{play, role, "Install the operator", "localhost", "non-zero return code",
+			`error: unable to recognize "subscription.yaml": no matches for kind "Subscription"`},
+		{play, role, "Wait for the nodes", "worker-0", `["node not ready","timeout"]`, ""},
+		{play, role, "Wait for the nodes", "worker-1", "Failed to connect to the host via ssh", ""},
+		{play, role, "Check the pods", "localhost", "One or more items failed", ""},
+		{play, "", "Capture the logs", "localhost", "", "no logs for b"},
+	}
+
+	if failures := log.FailedTasks(); !reflect.DeepEqual(failures, expected) {
+		t.Errorf("got failed tasks:\n%+v\nexpected:\n%+v", failures, expected)
+	}
+}
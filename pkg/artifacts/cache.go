@@ -0,0 +1,493 @@
+package artifacts
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+const (
+	DefaultCacheMutableTTL = 1 * time.Hour
+
+	// names of the files storing the directory listings
+	gcswebListingFile = "?index"
+	gcsListingFile = "?index.gcs.json"
+
+	// suffix of the metadata sidecar of the cache files
+	cacheMetadataSuffix = ".cache-meta.json"
+
+	// directory of the artifacts cache storing the snapshots of the
+	// populated builds
+	SnapshotsDirName = ".snapshots"
+)
+
+// CacheMetadata describes how a cache entry was fetched. It is stored
+// in a sidecar file next to the cached content.
+type CacheMetadata struct {
+	URL string           `json:"url"`
+	StatusCode int       `json:"status_code"`
+	// the artifact does not exist in the source
+	Missing bool         `json:"missing,omitempty"`
+	ETag string          `json:"etag,omitempty"`
+	LastModified string  `json:"last_modified,omitempty"`
+	ContentType string   `json:"content_type,omitempty"`
+	FetchedAt time.Time  `json:"fetched_at"`
+}
+
+func newCacheMetadata(request_url string, resp *httpResponse, missing bool) *CacheMetadata {
+	return &CacheMetadata{
+		URL: request_url,
+		StatusCode: resp.StatusCode,
+		Missing: missing,
+		ETag: resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ContentType: resp.Header.Get("Content-Type"),
+		FetchedAt: time.Now().UTC(),
+	}
+}
+
+func readCacheMetadata(meta_path string) (*CacheMetadata, error) {
+	content, err := ioutil.ReadFile(meta_path)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta CacheMetadata
+	if err = json.Unmarshal(content, &meta); err != nil {
+		return nil, err
+	}
+
+	return &meta, nil
+}
+
+// artifactsCache stores the artifacts fetched from a remote source in
+// the `artifacts_cache` directory.
+//
+// The cache keys are the artifact paths, relative to the matrix
+// `artifacts_url`, with a trailing "/" for the directory listings.
+// The files of the finished builds (the ones with a valid
+// `finished.json`) never change, so they are cached forever. All the
+// other entries (job directory listings, `latest-build.txt`, running
+// builds) are mutable, and expire after `mutable_ttl`.
+type artifactsCache struct {
+	dir string
+	// name of the file storing a directory listing
+	listing_name string
+	mutable_ttl time.Duration
+
+	finished_builds sync.Map
+}
+
+func newArtifactsCache(test_matrix *v1.MatrixSpec, listing_name string) (*artifactsCache, error) {
+	if test_matrix.ArtifactsCache == "" {
+		return nil, nil
+	}
+
+	if isFixtureModeActive() {
+		// all the requests must go through the fixtures
+		log.Debugf("Artifacts cache %s disabled in record/replay mode", test_matrix.ArtifactsCache)
+		return nil, nil
+	}
+
+	cache := &artifactsCache{
+		dir: test_matrix.ArtifactsCache,
+		listing_name: listing_name,
+		mutable_ttl: DefaultCacheMutableTTL,
+	}
+
+	if test_matrix.Cache != nil {
+		var err error
+		cache.mutable_ttl, err = parseDuration(test_matrix.Cache.MutableTTL, DefaultCacheMutableTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache mutable TTL '%s': %v", test_matrix.Cache.MutableTTL, err)
+		}
+	}
+
+	return cache, nil
+}
+
+func (c *artifactsCache) path(key string) string {
+	cache_path := fmt.Sprintf("%s/%s", c.dir, key)
+	if strings.HasSuffix(cache_path, "/") {
+		cache_path += "/" + c.listing_name
+	}
+	return cache_path
+}
+
+// buildKey returns the `<prow_name>/<build_id>` prefix of `key`, or
+// an empty string if `key` isn't part of a build.
+func buildKey(key string) string {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) < 3 || parts[1] == "" {
+		return ""
+	}
+	return parts[0] + "/" + parts[1]
+}
+
+func (c *artifactsCache) isBuildFinished(build_key string) bool {
+	if _, found := c.finished_builds.Load(build_key); found {
+		return true
+	}
+
+	finished_key := build_key + "/finished.json"
+	content, err := ioutil.ReadFile(c.path(finished_key))
+	if err != nil {
+		return false
+	}
+
+	if meta, err := readCacheMetadata(c.metaPath(finished_key)); err == nil && meta.Missing {
+		return false
+	}
+
+	var finished JsonResult
+	if err = json.Unmarshal(content, &finished); err != nil {
+		// not finished, or a 404 page
+		return false
+	}
+
+	c.finished_builds.Store(build_key, true)
+
+	return true
+}
+
+// IsMutable tells if the content of `key` may still change in the
+// remote source.
+func (c *artifactsCache) IsMutable(key string) bool {
+	build_key := buildKey(key)
+	if build_key == "" {
+		// job directory listing, latest-build.txt, ...
+		return true
+	}
+
+	return !c.isBuildFinished(build_key)
+}
+
+func (c *artifactsCache) metaPath(key string) string {
+	return c.path(key) + cacheMetadataSuffix
+}
+
+// cacheEntry is the content of a cache entry and its metadata. The
+// metadata is nil for the entries cached before the sidecars were
+// introduced.
+type cacheEntry struct {
+	Content []byte
+	Meta *CacheMetadata
+	// the entry is mutable and must be revalidated before being used
+	Expired bool
+}
+
+// alwaysRevalidate tells if `key` must be revalidated every time it
+// is used, as it changes with every new build.
+func alwaysRevalidate(key string) bool {
+	return strings.HasSuffix(key, "/latest-build.txt")
+}
+
+// Lookup returns the cache entry of `key`, or nil if it isn't cached.
+func (c *artifactsCache) Lookup(key string) *cacheEntry {
+	if c == nil {
+		return nil
+	}
+
+	cache_path := c.path(key)
+	info, err := os.Stat(cache_path)
+	if err != nil {
+		return nil
+	}
+
+	fetched_at := info.ModTime()
+	meta, err := readCacheMetadata(c.metaPath(key))
+	if err == nil {
+		fetched_at = meta.FetchedAt
+	} else {
+		meta = nil
+	}
+
+	content, err := ioutil.ReadFile(cache_path)
+	if err != nil {
+		return nil
+	}
+
+	expired := false
+	if c.IsMutable(key) && (alwaysRevalidate(key) || time.Since(fetched_at) > c.mutable_ttl) {
+		log.Debugf("Cache entry %s expired", key)
+		expired = true
+	}
+
+	return &cacheEntry{Content: content, Meta: meta, Expired: expired}
+}
+
+// conditionalHeader returns the headers asking the server to send the
+// artifact only if it changed since `entry` was fetched.
+func (entry *cacheEntry) conditionalHeader() http.Header {
+	header := http.Header{}
+	if entry == nil || entry.Meta == nil || entry.Meta.Missing {
+		return header
+	}
+
+	if entry.Meta.ETag != "" {
+		header.Set("If-None-Match", entry.Meta.ETag)
+	}
+	if entry.Meta.LastModified != "" {
+		header.Set("If-Modified-Since", entry.Meta.LastModified)
+	}
+
+	return header
+}
+
+// Revalidated records that the server confirmed, with the 304
+// response described by `not_modified`, that the cached `entry` of
+// `key` is still valid.
+func (c *artifactsCache) Revalidated(key string, entry *cacheEntry, not_modified *CacheMetadata) error {
+	if c == nil {
+		return nil
+	}
+
+	meta := *entry.Meta
+	meta.FetchedAt = not_modified.FetchedAt
+	if not_modified.ETag != "" {
+		meta.ETag = not_modified.ETag
+	}
+	if not_modified.LastModified != "" {
+		meta.LastModified = not_modified.LastModified
+	}
+
+	meta_content, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("error encoding the cache metadata of %s: %v", key, err)
+	}
+
+	// keep the content away from the age-based eviction
+	if err = os.Chtimes(c.path(key), meta.FetchedAt, meta.FetchedAt); err != nil {
+		log.Warningf("Failed to update the modification time of %s: %v", c.path(key), err)
+	}
+
+	return storeInCache(c.metaPath(key), meta_content)
+}
+
+// Put saves `content` and its metadata into the cache entry of `key`.
+// Only the failure to create the cache directory is reported, as the
+// artifact can still be used without being cached.
+func (c *artifactsCache) Put(key string, content []byte, meta *CacheMetadata) error {
+	if c == nil {
+		return nil
+	}
+
+	if err := storeInCache(c.path(key), content); err != nil {
+		return err
+	}
+
+	meta_content, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("error encoding the cache metadata of %s: %v", key, err)
+	}
+
+	return storeInCache(c.metaPath(key), meta_content)
+}
+
+// PutMissing records in the cache that `key` does not exist in the
+// source.
+func (c *artifactsCache) PutMissing(key string, meta *CacheMetadata) error {
+	meta.Missing = true
+	return c.Put(key, []byte{}, meta)
+}
+
+func (c *artifactsCache) Remove(key string) error {
+	if c == nil {
+		return nil
+	}
+
+	return removeCacheFile(c.path(key))
+}
+
+// removeCacheFile removes a cache file and its metadata sidecar.
+func removeCacheFile(cache_path string) error {
+	if err := os.Remove(cache_path + cacheMetadataSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Remove(cache_path)
+}
+
+func storeInCache(cache_path string, content []byte) error {
+	cache_dir, err := filepath.Abs(filepath.Dir(cache_path))
+	if err != nil {
+		log.Warningf("Failed to get cache directory for %s: %v", cache_path, err)
+	}
+
+	err = os.MkdirAll(cache_dir, os.ModePerm)
+	if err != nil {
+		log.Warningf("Failed to create cache directory %s: %v", cache_dir, err)
+		return err
+	}
+
+	if err = WriteFileAtomic(cache_path, content); err != nil {
+		log.Warningf("Failed to write into cache file at %s: %v", cache_path, err)
+	}
+
+	return nil
+}
+
+// WriteFileAtomic stores `content` into `file_path` through a
+// temporary file renamed into place, so that concurrent readers never
+// read a partially written file. The directory of `file_path` must
+// exist.
+func WriteFileAtomic(file_path string, content []byte) error {
+	tmp_file, err := ioutil.TempFile(filepath.Dir(file_path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp_file.Name())
+
+	if _, err = tmp_file.Write(content); err != nil {
+		tmp_file.Close()
+		return err
+	}
+	if err = tmp_file.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp_file.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp_file.Name(), file_path)
+}
+
+type cacheFile struct {
+	path string
+	size int64
+	mod_time time.Time
+}
+
+// EvictCache removes from the `cache_dir` directory the files fetched
+// more than `max_age` ago, then the oldest files until the cache is
+// smaller than `max_size` bytes. Zero values disable the
+// corresponding eviction. The snapshots only expire with `max_age`.
+func EvictCache(cache_dir string, max_age time.Duration, max_size int64) error {
+	if err := evictSnapshots(filepath.Join(cache_dir, SnapshotsDirName), max_age); err != nil {
+		return err
+	}
+
+	files := []cacheFile{}
+	total_size := int64(0)
+	err := filepath.Walk(cache_dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil && os.IsNotExist(err) && path != cache_dir {
+			// metadata sidecar already evicted with its file
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == SnapshotsDirName {
+			// evicted by evictSnapshots
+			return filepath.SkipDir
+		}
+		if info.IsDir() || strings.HasSuffix(path, cacheMetadataSuffix) {
+			// the metadata sidecars are removed with their file
+			return nil
+		}
+
+		if max_age > 0 && time.Since(info.ModTime()) > max_age {
+			log.Debugf("Evicting %s from the cache (age)", path)
+			return removeCacheFile(path)
+		}
+
+		files = append(files, cacheFile{path, info.Size(), info.ModTime()})
+		total_size += info.Size()
+
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error evicting old files from cache %s: %v", cache_dir, err)
+	}
+
+	if max_size <= 0 || total_size <= max_size {
+		return nil
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].mod_time.Before(files[j].mod_time)
+	})
+
+	for _, file := range files {
+		if total_size <= max_size {
+			break
+		}
+		log.Debugf("Evicting %s from the cache (size)", file.path)
+		if err := removeCacheFile(file.path); err != nil {
+			return fmt.Errorf("error evicting %s from the cache: %v", file.path, err)
+		}
+		total_size -= file.size
+	}
+
+	return nil
+}
+
+// evictSnapshots removes the snapshots not used for more than
+// `max_age`. The snapshots are touched when they are loaded, so only
+// the ones of the builds out of the test history expire.
+func evictSnapshots(snapshots_dir string, max_age time.Duration) error {
+	if max_age <= 0 {
+		return nil
+	}
+
+	err := filepath.Walk(snapshots_dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || time.Since(info.ModTime()) <= max_age {
+			return nil
+		}
+
+		log.Debugf("Evicting the snapshot %s from the cache (age)", path)
+		return os.Remove(path)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error evicting old snapshots from %s: %v", snapshots_dir, err)
+	}
+
+	return nil
+}
+
+// SnapshotsDir returns the directory where the snapshots of the
+// populated builds of `test_matrix` are stored, or an empty string if
+// its artifacts cache is disabled.
+func SnapshotsDir(test_matrix *v1.MatrixSpec) string {
+	if test_matrix.ArtifactsCache == "" || isFixtureModeActive() {
+		return ""
+	}
+	return filepath.Join(test_matrix.ArtifactsCache, SnapshotsDirName)
+}
+
+// EvictMatrixCache applies the eviction policy configured in the
+// cache settings of `test_matrix`.
+func EvictMatrixCache(test_matrix *v1.MatrixSpec) error {
+	if test_matrix.ArtifactsCache == "" || test_matrix.Cache == nil {
+		return nil
+	}
+
+	max_age, err := parseDuration(test_matrix.Cache.MaxAge, 0)
+	if err != nil {
+		return fmt.Errorf("invalid cache max age '%s': %v", test_matrix.Cache.MaxAge, err)
+	}
+
+	max_size := test_matrix.Cache.MaxSizeMB * 1024 * 1024
+
+	if max_age == 0 && max_size == 0 {
+		return nil
+	}
+
+	log.Infof("Evicting the old entries of cache %s ...", test_matrix.ArtifactsCache)
+
+	return EvictCache(test_matrix.ArtifactsCache, max_age, max_size)
+}
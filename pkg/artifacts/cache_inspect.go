@@ -0,0 +1,150 @@
+package artifacts
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CacheFile describes a file of the artifacts cache.
+type CacheFile struct {
+	// artifact path, with a trailing "/" for the directory listings
+	Key string
+	Path string
+	Size int64
+	ModTime time.Time
+}
+
+func (f CacheFile) IsListing() bool {
+	return strings.HasSuffix(f.Key, "/")
+}
+
+func (f CacheFile) ProwName() string {
+	return strings.SplitN(f.Key, "/", 2)[0]
+}
+
+// BuildId returns the ID of the build containing the file, or an empty
+// string if the file isn't part of a build.
+func (f CacheFile) BuildId() string {
+	build_key := buildKey(f.Key)
+	if build_key == "" {
+		return ""
+	}
+	return strings.SplitN(build_key, "/", 2)[1]
+}
+
+// Metadata returns the metadata sidecar of the file, or nil if it
+// has none.
+func (f CacheFile) Metadata() *CacheMetadata {
+	meta, err := readCacheMetadata(f.Path + cacheMetadataSuffix)
+	if err != nil {
+		return nil
+	}
+	return meta
+}
+
+// RemoveCacheFile removes a file of the cache and its metadata.
+func RemoveCacheFile(file CacheFile) error {
+	return removeCacheFile(file.Path)
+}
+
+func isListingFile(name string) bool {
+	return name == gcswebListingFile || name == gcsListingFile
+}
+
+// WalkCache calls `cb` on every file of the `cache_dir` artifacts
+// cache.
+func WalkCache(cache_dir string, cb func(file CacheFile) error) error {
+	return filepath.Walk(cache_dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil && os.IsNotExist(err) && path != cache_dir {
+			// metadata sidecar removed by `cb` with its file
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == SnapshotsDirName {
+			// not artifacts
+			return filepath.SkipDir
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".tmp-") ||
+			strings.HasSuffix(info.Name(), cacheMetadataSuffix) {
+			return nil
+		}
+
+		rel_path, err := filepath.Rel(cache_dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel_path)
+		if isListingFile(info.Name()) {
+			key = strings.TrimSuffix(key, info.Name())
+		}
+
+		return cb(CacheFile{Key: key, Path: path, Size: info.Size(), ModTime: info.ModTime()})
+	})
+}
+
+func isHtml(content []byte) bool {
+	start := bytes.ToLower(bytes.TrimSpace(content))
+	if len(start) > 64 {
+		start = start[:64]
+	}
+	return bytes.HasPrefix(start, []byte("<!doctype html")) || bytes.HasPrefix(start, []byte("<html"))
+}
+
+// VerifyCacheFile checks the content of a cache file, and returns a
+// description of the problem if it shouldn't be trusted.
+func VerifyCacheFile(file CacheFile) (string, error) {
+	content, err := ioutil.ReadFile(file.Path)
+	if err != nil {
+		return "", err
+	}
+
+	name := filepath.Base(file.Path)
+
+	if meta := file.Metadata(); meta != nil {
+		if meta.Missing {
+			// authoritative 404
+			return "", nil
+		}
+		if strings.HasSuffix(name, ".json") && strings.HasPrefix(meta.ContentType, "text/html") {
+			return "HTML stored where JSON was expected", nil
+		}
+	}
+
+	switch {
+	case name == gcswebListingFile:
+		if IsPageNotFound(content, file.Key) {
+			return "cached 404 page", nil
+		}
+	case name == gcsListingFile:
+		if !json.Valid(content) {
+			return "invalid directory listing", nil
+		}
+	case strings.HasSuffix(name, ".json"):
+		if isHtml(content) {
+			if IsPageNotFound(content, file.Key) {
+				return "cached 404 page", nil
+			}
+			return "HTML stored where JSON was expected", nil
+		}
+		if !json.Valid(content) {
+			return "truncated or invalid JSON", nil
+		}
+	case strings.HasSuffix(name, ".html") || strings.HasSuffix(name, ".htm"):
+		break
+	default:
+		if IsPageNotFound(content, file.Key) {
+			return "cached 404 page", nil
+		}
+		if isHtml(content) {
+			return "HTML page stored as an artifact", nil
+		}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,171 @@
+package artifacts
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestCache(t *testing.T) (*artifactsCache, func()) {
+	cache_dir, err := ioutil.TempDir("", "ci-dashboard-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := &artifactsCache{
+		dir: cache_dir,
+		listing_name: gcswebListingFile,
+		mutable_ttl: DefaultCacheMutableTTL,
+	}
+
+	return cache, func() { os.RemoveAll(cache_dir) }
+}
+
+func TestCacheIsMutable(t *testing.T) {
+	cache, cleanup := newTestCache(t)
+	defer cleanup()
+
+	meta := &CacheMetadata{StatusCode: 200}
+	cache.Put("job/100/finished.json", []byte(`{"passed": true}`), meta)
+	cache.Put("job/101/started.json", []byte(`{}`), meta)
+	cache.PutMissing("job/102/finished.json", &CacheMetadata{StatusCode: 404})
+	cache.Put("job/103/finished.json", []byte("<html>not found</html>"), meta)
+
+	for _, tc := range []struct {
+		key string
+		mutable bool
+	}{
+		{"job/", true},
+		{"job/latest-build.txt", true},
+		{"job/100/", false},
+		{"job/100/finished.json", false},
+		{"job/100/artifacts/step/ocp.version", false},
+		{"job/101/", true},
+		{"job/101/started.json", true},
+		// finished.json recorded as missing in the source
+		{"job/102/artifacts/", true},
+		// finished.json is a 404 page
+		{"job/103/artifacts/", true},
+		{"job/104/finished.json", true},
+	} {
+		if mutable := cache.IsMutable(tc.key); mutable != tc.mutable {
+			t.Errorf("IsMutable(%q) returned %v, expected %v", tc.key, mutable, tc.mutable)
+		}
+	}
+}
+
+// listCacheFiles returns the files of `cache_dir`, relative to it.
+func listCacheFiles(t *testing.T, cache_dir string) []string {
+	files := []string{}
+	err := filepath.Walk(cache_dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel_path, err := filepath.Rel(cache_dir, path)
+		files = append(files, filepath.ToSlash(rel_path))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+
+	return files
+}
+
+func TestEvictCache(t *testing.T) {
+	// file name -> age in hours, all the files are 100 bytes long
+	ages := map[string]int{
+		"job/latest-build.txt": 1,
+		"job/100/finished.json": 50,
+		"job/100/build-log.txt": 49,
+		"job/101/finished.json": 30,
+		"job/102/finished.json": 2,
+	}
+	// snapshot file name -> age in hours, also 100 bytes long
+	snapshot_ages := map[string]int{
+		"job/100.json": 50,
+		"job/102.json": 2,
+	}
+
+	for _, tc := range []struct {
+		name string
+		max_age time.Duration
+		max_size int64
+		remaining []string
+		// the snapshots are not part of the size of the cache
+		remaining_snapshots []string
+	}{
+		{"no limits", 0, 0, []string{
+			"job/100/build-log.txt", "job/100/finished.json", "job/101/finished.json",
+			"job/102/finished.json", "job/latest-build.txt",
+		}, []string{"job/100.json", "job/102.json"}},
+		{"max age", 24 * time.Hour, 0, []string{
+			"job/102/finished.json", "job/latest-build.txt",
+		}, []string{"job/102.json"}},
+		{"max size", 0, 300, []string{
+			"job/101/finished.json", "job/102/finished.json", "job/latest-build.txt",
+		}, []string{"job/100.json", "job/102.json"}},
+		{"max size, not reached", 0, 500, []string{
+			"job/100/build-log.txt", "job/100/finished.json", "job/101/finished.json",
+			"job/102/finished.json", "job/latest-build.txt",
+		}, []string{"job/100.json", "job/102.json"}},
+		{"max age and size", 40 * time.Hour, 150, []string{
+			"job/latest-build.txt",
+		}, []string{"job/102.json"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cache, cleanup := newTestCache(t)
+			defer cleanup()
+
+			for key, age := range ages {
+				cache.Put(key, []byte(strings.Repeat("x", 100)), &CacheMetadata{StatusCode: 200})
+				mod_time := time.Now().Add(-time.Duration(age) * time.Hour)
+				if err := os.Chtimes(cache.path(key), mod_time, mod_time); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for name, age := range snapshot_ages {
+				snapshot_path := filepath.Join(cache.dir, SnapshotsDirName, name)
+				if err := os.MkdirAll(filepath.Dir(snapshot_path), os.ModePerm); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(snapshot_path, []byte(strings.Repeat("x", 100)), 0644); err != nil {
+					t.Fatal(err)
+				}
+				mod_time := time.Now().Add(-time.Duration(age) * time.Hour)
+				if err := os.Chtimes(snapshot_path, mod_time, mod_time); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := EvictCache(cache.dir, tc.max_age, tc.max_size); err != nil {
+				t.Fatalf("EvictCache failed: %v", err)
+			}
+
+			// the metadata sidecars go away with their file
+			expected := []string{}
+			for _, file := range tc.remaining {
+				expected = append(expected, file, file+cacheMetadataSuffix)
+			}
+			for _, name := range tc.remaining_snapshots {
+				expected = append(expected, SnapshotsDirName+"/"+name)
+			}
+			sort.Strings(expected)
+
+			if remaining := listCacheFiles(t, cache.dir); !reflect.DeepEqual(remaining, expected) {
+				t.Errorf("got %v in the cache, expected %v", remaining, expected)
+			}
+		})
+	}
+
+	if err := EvictCache(filepath.Join(os.TempDir(), "ci-dashboard-missing-cache"), time.Hour, 1); err != nil {
+		t.Errorf("EvictCache of a missing directory failed: %v", err)
+	}
+}
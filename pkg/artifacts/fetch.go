@@ -2,15 +2,9 @@ package artifacts
 
 import (
 	"bytes"
-	"crypto/md5"
-    "encoding/hex"
 	"encoding/json"
 
 	"fmt"
-	"io/ioutil"
-    "net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -25,12 +19,6 @@ const (
 	TypeJsonArray ArtifactType = "type:json-array"
 	TypeHtml                   = "type:html"
 	TypeBytes                  = "type:bytes"
-	// Google GCP hosting doesn't return a 404 error when we request a
-	// file that doesn't exist, but instead serves "an empty dir"
-	// page.  From this page, we strip all the references to the path
-	// we're requesting and compare it against this MD5sum (which was
-	// printf-ed and reinjected here :#)
-	MissingPageMD5Sum          = "b66c9aae6e6cf88de034b25232ba0181"
 )
 
 var MissingPageError = fmt.Errorf("Page does not exist.")
@@ -46,73 +34,40 @@ type JsonResult map[string]interface{}
 type JsonArray []interface{}
 
 func fetchRemoveFromCache(test_matrix *v1.MatrixSpec, path string) error {
-	cache_path := fmt.Sprintf("%s/%s", test_matrix.ArtifactsCache, path)
-	return os.Remove(cache_path)
-}
-
-func IsPageNotFound(content []byte, path string) bool {
-	fname_pos := strings.LastIndex(path,"/")
-	content = []byte(strings.ReplaceAll(string(content), path, ""))
-	content = []byte(strings.ReplaceAll(string(content), path[0:fname_pos+1], ""))
-
-	hash := md5.Sum(content)
-	hashString := hex.EncodeToString(hash[:])
-
-	return hashString == MissingPageMD5Sum
-}
-
-func fetchArtifact(test_matrix *v1.MatrixSpec, path string) ([]byte, error) {
-	cache_path := fmt.Sprintf("%s/%s", test_matrix.ArtifactsCache, path)
-	artifact_url := fmt.Sprintf("%s/%s", test_matrix.ArtifactsURL, path)
-
-	if strings.HasSuffix(cache_path, "/") {
-		cache_path += "/?index"
-	}
-
-	content, err := ioutil.ReadFile(cache_path)
-	if err == nil {
-		if IsPageNotFound(content, path) {
-			log.Debugf("File %s found in the cache as 404", artifact_url)
-			return content, MissingPageError
-		}
-
-		log.Debugf("File %s found in the cache", artifact_url)
-		return content, nil
-	}
-
-	log.Debugf("Fetching %s ...", artifact_url)
-	resp, err := http.Get(artifact_url)
+	source, err := GetSource(test_matrix)
 	if err != nil {
-		return []byte{}, MissingPageError
+		return err
 	}
 
-	defer resp.Body.Close()
-	content, err = ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return []byte{}, fmt.Errorf("error reading %s: %v", artifact_url, err)
+	cached_source, ok := source.(cachedSource)
+	if !ok {
+		return nil
 	}
 
-	cache_dir, err := filepath.Abs(filepath.Dir(cache_path))
-    if err != nil {
-		log.Warningf("Failed to get cache directory for %s: %v", cache_path, err)
-    }
+	return cached_source.RemoveFromCache(path)
+}
 
-	err = os.MkdirAll(cache_dir, os.ModePerm)
+func fetchArtifact(test_matrix *v1.MatrixSpec, path string) ([]byte, error) {
+	source, err := GetSource(test_matrix)
 	if err != nil {
-		log.Warningf("Failed to create cache directory %s: %v", cache_dir, err)
 		return []byte{}, err
-    }
+	}
+
+	return source.ReadFile(path)
+}
 
-	err = ioutil.WriteFile(cache_path, content, 0644)
+func listDirectory(test_matrix *v1.MatrixSpec, path string, dirs_only, files_only bool) ([]string, error) {
+	source, err := GetSource(test_matrix)
 	if err != nil {
-		log.Warningf("Failed to write into cache file at %s: %v", cache_path, err)
+		return nil, err
 	}
 
-	if IsPageNotFound(content, path) {
-		return content, MissingPageError
+	entries, err := source.ListDirectory(path)
+	if err != nil {
+		return nil, err
 	}
 
-	return content, nil
+	return entryNames(entries, dirs_only, files_only), nil
 }
 
 func fetchHtmlArtifact(test_matrix *v1.MatrixSpec, path string) (*goquery.Document, error) {
@@ -182,7 +137,7 @@ func fetchTestResult(test_matrix *v1.MatrixSpec, prow_name, build_id, filename s
 		return result, err
 	}
 	if err != nil {
-		return result, fmt.Errorf("error fetching the test results from %s: %v", file_path, err)
+		return result, fmt.Errorf("error fetching the test results from %s: %w", file_path, err)
 	}
 
 	return result, nil
@@ -203,10 +158,6 @@ func FetchLastTestResult(test_matrix *v1.MatrixSpec, test *v1.TestSpec, filename
 			last_test_path, err)
 	}
 
-	if err = fetchRemoveFromCache(test_matrix, last_test_path); err != nil {
-		log.Warningf("Failed to remove %s from cache : %v", last_test_path, err)
-	}
-
 	last_test_file, err := fetchTestResult(test_matrix, test.ProwName,
 		string(last_test_build_id), filename, filetype)
 	if (err != nil) {
@@ -218,21 +169,16 @@ func FetchLastTestResult(test_matrix *v1.MatrixSpec, test *v1.TestSpec, filename
 	return string(last_test_build_id), last_test_file, nil
 }
 
-func FetchLastNTestResults(test_matrix *v1.MatrixSpec, prow_name string, test_history int, filename string, filetype ArtifactType) ([]string, map[string]ArtifactResult, error) {
+// FetchTestBuildIds returns the IDs of the last `test_history` builds
+// of the `prow_name` job, newest first.
+func FetchTestBuildIds(test_matrix *v1.MatrixSpec, prow_name string, test_history int) ([]string, error) {
 	if test_history <= 0 {
 		panic(fmt.Sprintf("Invalid number of test history required (%d)", test_history))
 	}
-	test_list_path := fmt.Sprintf("%s/", prow_name)
-	test_list_html, err := fetchHtmlArtifact(test_matrix, test_list_path)
-	if err != nil {
-		return nil, nil, fmt.Errorf("error fetching the tests of %s / %s: %v", test_matrix.Name, prow_name, err)
-	}
 
-	test_results := map[string]ArtifactResult{}
-
-	build_ids, err := ListFilesInDirectory(test_list_html, true, false)
+	build_ids, err := listDirectory(test_matrix, prow_name, true, false)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error fetching last test results: %v", err)
+		return nil, fmt.Errorf("error fetching the tests of %s / %s: %w", test_matrix.Name, prow_name, err)
 	}
 
 	// `build_ids` order is "oldest first" (alphanumeric order of timestamps)
@@ -240,9 +186,21 @@ func FetchLastNTestResults(test_matrix *v1.MatrixSpec, prow_name string, test_hi
 		build_ids = build_ids[len(build_ids) - test_history:]
 	}
 
-	build_ids = reverseStringArray(build_ids)
-
 	// `build_ids` order is now "newest first"
+	return reverseStringArray(build_ids), nil
+}
+
+func FetchTestBuildResult(test_matrix *v1.MatrixSpec, prow_name, build_id, filename string, filetype ArtifactType) (ArtifactResult, error) {
+	return fetchTestResult(test_matrix, prow_name, build_id, filename, filetype)
+}
+
+func FetchLastNTestResults(test_matrix *v1.MatrixSpec, prow_name string, test_history int, filename string, filetype ArtifactType) ([]string, map[string]ArtifactResult, error) {
+	build_ids, err := FetchTestBuildIds(test_matrix, prow_name, test_history)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	test_results := map[string]ArtifactResult{}
 
 	for _, test_build_id := range build_ids {
 		test_file, err := fetchTestResult(test_matrix, prow_name, test_build_id, filename, filetype)
@@ -258,44 +216,51 @@ func FetchLastNTestResults(test_matrix *v1.MatrixSpec, prow_name string, test_hi
 	return build_ids, test_results, err
 }
 
-func FetchTestStepResult(test_result *v1.TestResult, filename string, filetype ArtifactType) (ArtifactResult, error) {
+func testStepPath(test_result *v1.TestResult, filename string) string {
 	var prow_step = test_result.TestSpec.Matrix.ProwStep
 	if test_result.TestSpec.ProwStep != "" {
 		// override test_matrix.ProwStep if ProwStep is test_spec.ProwStep is specified
 		prow_step = test_result.TestSpec.ProwStep
 	}
-	step_filename := ""
 	if test_result.TestSpec.IsCiOperator == nil || *test_result.TestSpec.IsCiOperator == true {
-		step_filename = fmt.Sprintf("artifacts/%s/%s/artifacts/%s", test_result.TestSpec.TestName, prow_step, filename)
+		return fmt.Sprintf("artifacts/%s/%s/artifacts/%s", test_result.TestSpec.TestName, prow_step, filename)
 	} else {
-		step_filename = fmt.Sprintf("artifacts/%s/%s", prow_step, filename)
+		return fmt.Sprintf("artifacts/%s/%s", prow_step, filename)
 	}
-	return fetchTestResultResult(test_result, step_filename, filetype)
+}
+
+func FetchTestStepResult(test_result *v1.TestResult, filename string, filetype ArtifactType) (ArtifactResult, error) {
+	return fetchTestResultResult(test_result, testStepPath(test_result, filename), filetype)
+}
+
+// ListTestStepDirectory returns the names of the entries of the
+// `dirname` directory of the test step artifacts.
+func ListTestStepDirectory(test_result *v1.TestResult, dirname string, dirs_only, files_only bool) ([]string, error) {
+	dir_path := fmt.Sprintf("%s/%s/%s", test_result.TestSpec.ProwName, test_result.BuildId,
+		testStepPath(test_result, dirname))
+
+	return listDirectory(test_result.TestSpec.Matrix, dir_path, dirs_only, files_only)
 }
 
 func FetchTestToolboxSteps(test_result *v1.TestResult) ([]string, error) {
-	html_toolbox_steps, err := FetchTestStepResult(test_result, "/", TypeHtml)
-	if err != nil {
+	toolbox_steps, err := ListTestStepDirectory(test_result, "", true, false)
+	if err == MissingPageError {
 		return []string{}, err
 	}
-
-	toolbox_steps, err := ListFilesInDirectory(html_toolbox_steps.Html, true, false)
 	if err != nil {
-		return []string{}, fmt.Errorf("error fetching toolbox steps: %v", err)
+		return []string{}, fmt.Errorf("error fetching toolbox steps: %w", err)
 	}
 
 	return toolbox_steps, nil
 }
 
 func FetchTestMessages(message_dir string, test_result *v1.TestResult) (map[string]string, error) {
-	message_dir_html, err := FetchTestStepResult(test_result, message_dir + "/", TypeHtml)
-	if err != nil {
+	message_files, err := ListTestStepDirectory(test_result, message_dir, false, true)
+	if err == MissingPageError {
 		return map[string]string{}, err
 	}
-
-	message_files, err := ListFilesInDirectory(message_dir_html.Html, false, true)
 	if err != nil {
-		return map[string]string{}, fmt.Errorf("error fetching toolbox steps: %v", err)
+		return map[string]string{}, fmt.Errorf("error fetching toolbox steps: %w", err)
 	}
 
 	messages := map[string]string{}
@@ -310,26 +275,100 @@ func FetchTestMessages(message_dir string, test_result *v1.TestResult) (map[stri
 	return messages, nil
 }
 
-func FetchTestToolboxLogs(test_result *v1.TestResult) (map[string]JsonArray, error) {
+// JUnitSearchDepth is the number of levels of subdirectories of the
+// test step artifacts where the JUnit files are searched.
+const JUnitSearchDepth = 1
+
+func isJUnitFile(filename string) bool {
+	return strings.HasPrefix(filename, "junit") && strings.HasSuffix(filename, ".xml")
+}
+
+func findTestJUnitFiles(test_result *v1.TestResult, dirname string, depth int, junit_files *[]string) error {
+	source, err := GetSource(test_result.TestSpec.Matrix)
+	if err != nil {
+		return err
+	}
+
+	dir_path := fmt.Sprintf("%s/%s/%s", test_result.TestSpec.ProwName, test_result.BuildId,
+		testStepPath(test_result, dirname))
+	entries, err := source.ListDirectory(dir_path)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		entry_path := entry.Name
+		if dirname != "" {
+			entry_path = dirname + "/" + entry.Name
+		}
+
+		if !entry.IsDir {
+			if isJUnitFile(entry.Name) {
+				*junit_files = append(*junit_files, entry_path)
+			}
+			continue
+		}
+		if depth == 0 {
+			continue
+		}
+
+		if err = findTestJUnitFiles(test_result, entry_path, depth - 1, junit_files); err != nil && err != MissingPageError {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FetchTestJUnitFiles returns the content of the JUnit XML files of
+// the test step artifacts, indexed by their path relative to the step
+// directory.
+func FetchTestJUnitFiles(test_result *v1.TestResult) (map[string][]byte, error) {
+	junit_files := []string{}
+	err := findTestJUnitFiles(test_result, "", JUnitSearchDepth, &junit_files)
+	if err == MissingPageError {
+		return map[string][]byte{}, err
+	}
+	if err != nil {
+		return map[string][]byte{}, fmt.Errorf("error looking for the JUnit files: %w", err)
+	}
+
+	contents := map[string][]byte{}
+	for _, junit_file := range junit_files {
+		content, err := FetchTestStepResult(test_result, junit_file, TypeBytes)
+		if err != nil {
+			return map[string][]byte{}, err
+		}
+		contents[junit_file] = content.Bytes
+	}
+
+	return contents, nil
+}
+
+// FetchTestToolboxLogs returns the content of the ansible logs of the
+// toolbox steps, indexed by step name. The logs are not decoded, as
+// they may be truncated.
+func FetchTestToolboxLogs(test_result *v1.TestResult) (map[string][]byte, error) {
 	toolbox_steps, err := FetchTestToolboxSteps(test_result)
 	if err != nil {
-		fmt.Println(err)
-		return map[string]JsonArray{}, err
+		return map[string][]byte{}, err
 	}
-	logs := map[string]JsonArray{}
+	logs := map[string][]byte{}
 
 	for _, toolbox_step := range toolbox_steps {
 		ansible_log_path := toolbox_step + "/_ansible.log.json"
-		json_toolbox_step_logs, err := FetchTestStepResult(test_result, ansible_log_path, TypeJsonArray)
-		if err != nil {
-			log.Debugf("No logs for step %s: %v", toolbox_step, err)
+		toolbox_step_logs, err := FetchTestStepResult(test_result, ansible_log_path, TypeBytes)
+		if err == MissingPageError {
+			log.Debugf("No logs for step %s", toolbox_step)
 			// no `_ansible.log.json` in the current step, meaning
 			// that this directory wasn't generated by a
 			// toolbox+ansible command. Ignore.
 			continue
 		}
-		logs[toolbox_step] = json_toolbox_step_logs.JsonArray
-		fmt.Println(toolbox_step)
+		if err != nil {
+			return map[string][]byte{}, fmt.Errorf("error fetching the logs of step %s: %w", toolbox_step, err)
+		}
+		logs[toolbox_step] = toolbox_step_logs.Bytes
 	}
 
 	return logs, nil
@@ -342,37 +381,3 @@ func reverseStringArray(arr []string) []string {
 	}
 	return arr
 }
-
-func ListFilesInDirectory(html_dir *goquery.Document, dirs_only, files_only bool)([]string, error) {
-	files := []string{}
-
-	html_dir.Find("li.grid-row").EachWithBreak(func(i int, li_tag *goquery.Selection) bool {
-		entry_type, found := li_tag.Find("img").Attr("src")
-
-		if !found {
-			// li-tag doesn't contain an img-tag, this is unexpected
-			// in a directory listing.
-			return true // continue
-		}
-
-		is_dir := entry_type == "/icons/dir.png"
-		if files_only && is_dir || dirs_only && !is_dir {
-			// li-tag is a directory entry, and we don't want directories.
-			// or
-			// li-tag is a file and we don't want files.
-			return true // continue
-		}
-
-		filename := strings.TrimSuffix(strings.TrimSpace(li_tag.Find("a").Text()), "/")
-		if filename == ".." {
-			// skip "parent-dir" entry
-			return true // continue
-		}
-
-		files = append(files, filename)
-
-		return true
-	})
-
-	return files, nil
-}
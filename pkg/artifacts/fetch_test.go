@@ -0,0 +1,68 @@
+package artifacts
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+	"github.com/openshift-psap/ci-dashboard/pkg/fakeprow"
+)
+
+func TestFetchTestToolboxLogs(t *testing.T) {
+	server, err := fakeprow.NewServer(&fakeprow.Bucket{
+		Jobs: []fakeprow.Job{{
+			Name: "periodic-ci-org-repo-main-e2e",
+			StepDir: "artifacts/e2e/test-step/artifacts",
+			Builds: []fakeprow.Build{{
+				Id: "1000", Result: "SUCCESS", StepResult: "SUCCESS",
+				Steps: []fakeprow.Step{{Name: "000__cluster__capture_environment", Ok: 5}},
+				// not generated by a toolbox command
+				Files: map[string]string{"artifacts/e2e/test-step/artifacts/must-gather/nodes.txt": "worker-0"},
+			}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	cache_dir, err := ioutil.TempDir("", "ci-dashboard-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cache_dir)
+
+	retries := 0
+	test := &v1.TestSpec{
+		TestName: "e2e",
+		ProwName: "periodic-ci-org-repo-main-e2e",
+		Matrix: &v1.MatrixSpec{
+			Name: "test",
+			ArtifactsURL: server.ArtifactsURL(),
+			ArtifactsCache: cache_dir,
+			ProwStep: "test-step",
+			HTTP: &v1.HTTPSpec{Retries: &retries},
+		},
+	}
+	test_result := &v1.TestResult{TestSpec: test, BuildId: "1000"}
+
+	ansible_log := "logs/periodic-ci-org-repo-main-e2e/1000/artifacts/e2e/test-step/artifacts/" +
+		"000__cluster__capture_environment/_ansible.log.json"
+	server.SetStatus(ansible_log, http.StatusServiceUnavailable)
+
+	if _, err = FetchTestToolboxLogs(test_result); !IsTransientError(err) {
+		t.Errorf("FetchTestToolboxLogs returned %v, expected a TransientError", err)
+	}
+
+	server.SetStatus(ansible_log, 0)
+
+	logs, err := FetchTestToolboxLogs(test_result)
+	if err != nil {
+		t.Fatalf("FetchTestToolboxLogs failed: %v", err)
+	}
+	if _, found := logs["000__cluster__capture_environment"]; !found || len(logs) != 1 {
+		t.Errorf("got the logs of the steps %v, expected only 000__cluster__capture_environment", logs)
+	}
+}
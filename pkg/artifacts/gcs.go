@@ -0,0 +1,305 @@
+package artifacts
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+const (
+	SourceGcs = "gcs"
+
+	DefaultGcsEndpoint = "https://storage.googleapis.com"
+)
+
+// gcsSource serves the artifacts from the Google Cloud Storage JSON
+// API. The `artifacts_url` of the matrix must be a `gs://bucket/prefix`
+// URL.
+type gcsSource struct {
+	endpoint string
+	bucket string
+	prefix string
+	cache *artifactsCache
+	client *httpClient
+}
+
+type gcsObject struct {
+	Name string `json:"name"`
+	Size string `json:"size"`
+}
+
+type gcsObjectList struct {
+	Prefixes []string `json:"prefixes"`
+	Items []gcsObject `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+func newGcsSource(test_matrix *v1.MatrixSpec) (ArtifactSource, error) {
+	artifacts_url, err := url.Parse(test_matrix.ArtifactsURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid artifacts_url '%s': %v", test_matrix.ArtifactsURL, err)
+	}
+	if artifacts_url.Scheme != "gs" || artifacts_url.Host == "" {
+		return nil, fmt.Errorf("artifacts_url '%s' should be a 'gs://bucket/prefix' URL", test_matrix.ArtifactsURL)
+	}
+
+	endpoint := test_matrix.GcsEndpoint
+	if endpoint == "" {
+		endpoint = DefaultGcsEndpoint
+	}
+
+	client, err := newHTTPClient(test_matrix.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := newArtifactsCache(test_matrix, gcsListingFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gcsSource{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		bucket: artifacts_url.Host,
+		prefix: strings.Trim(artifacts_url.Path, "/"),
+		cache: cache,
+		client: client,
+	}, nil
+}
+
+func (s *gcsSource) objectName(path string) string {
+	path = strings.Trim(path, "/")
+	if s.prefix == "" {
+		return path
+	}
+	if path == "" {
+		return s.prefix
+	}
+	return s.prefix + "/" + path
+}
+
+func (s *gcsSource) objectURL(path string) string {
+	return fmt.Sprintf("%s/storage/v1/b/%s/o/%s", s.endpoint,
+		url.PathEscape(s.bucket), url.PathEscape(s.objectName(path)))
+}
+
+func (s *gcsSource) RemoveFromCache(path string) error {
+	return s.cache.Remove(path)
+}
+
+// get fetches `api_url`, and returns MissingPageError if the API
+// reports that the object does not exist. The response is returned
+// whenever the server answered.
+func (s *gcsSource) get(api_url string, header http.Header) (*httpResponse, error) {
+	log.Debugf("Fetching %s ...", api_url)
+	resp, err := s.client.Get(api_url, header)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, checkStatus(api_url, resp)
+}
+
+func (s *gcsSource) cachedContent(path string, entry *cacheEntry) ([]byte, error) {
+	if entry.Meta != nil && entry.Meta.Missing {
+		log.Debugf("File %s found in the cache as 404", path)
+		return []byte{}, MissingPageError
+	}
+
+	log.Debugf("File %s found in the cache", path)
+	return entry.Content, nil
+}
+
+func (s *gcsSource) ReadFile(path string) ([]byte, error) {
+	entry := s.cache.Lookup(path)
+	if entry != nil && !entry.Expired {
+		return s.cachedContent(path, entry)
+	}
+
+	object_url := s.objectURL(path) + "?alt=media"
+	resp, err := s.get(object_url, entry.conditionalHeader())
+	if resp != nil && resp.StatusCode == http.StatusNotModified && entry != nil {
+		if err = s.cache.Revalidated(path, entry, newCacheMetadata(object_url, resp, false)); err != nil {
+			log.Warningf("Failed to update the cache entry of %s: %v", object_url, err)
+		}
+		return s.cachedContent(path, entry)
+	} else if err == MissingPageError {
+		if err := s.cache.PutMissing(path, newCacheMetadata(object_url, resp, true)); err != nil {
+			return []byte{}, err
+		}
+		return []byte{}, MissingPageError
+	} else if entry != nil && IsTransientError(err) {
+		log.Warningf("Using the expired cache entry of %s: %v", object_url, err)
+		return s.cachedContent(path, entry)
+	} else if err != nil {
+		return []byte{}, err
+	}
+
+	if err = s.cache.Put(path, resp.Body, newCacheMetadata(object_url, resp, false)); err != nil {
+		return []byte{}, err
+	}
+
+	return resp.Body, nil
+}
+
+// errNotModified is returned by list when the server confirmed that
+// the listing did not change.
+var errNotModified = fmt.Errorf("listing not modified")
+
+// list returns the objects and prefixes under `path`. The conditional
+// `header` only applies to the first page of the listing, its metadata
+// is returned with errNotModified if the server answered with a 304.
+func (s *gcsSource) list(path string, max_results int, header http.Header) ([]Entry, *CacheMetadata, error) {
+	prefix := s.objectName(path) + "/"
+	if prefix == "/" {
+		prefix = ""
+	}
+
+	entries := []Entry{}
+	var meta *CacheMetadata
+	page_token := ""
+	for {
+		query := url.Values{}
+		query.Set("prefix", prefix)
+		query.Set("delimiter", "/")
+		if max_results > 0 {
+			query.Set("maxResults", fmt.Sprintf("%d", max_results))
+		}
+		if page_token != "" {
+			query.Set("pageToken", page_token)
+		}
+
+		list_url := fmt.Sprintf("%s/storage/v1/b/%s/o?%s", s.endpoint, url.PathEscape(s.bucket), query.Encode())
+		resp, err := s.get(list_url, header)
+		if meta == nil && resp != nil && resp.StatusCode == http.StatusNotModified {
+			return nil, newCacheMetadata(list_url, resp, false), errNotModified
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if meta == nil {
+			meta = newCacheMetadata(list_url, resp, false)
+		}
+		// the next pages are not conditional
+		header = nil
+
+		var object_list gcsObjectList
+		if err = json.Unmarshal(resp.Body, &object_list); err != nil {
+			return nil, nil, fmt.Errorf("error parsing the JSON of %s: %v", list_url, err)
+		}
+
+		for _, dir_prefix := range object_list.Prefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(dir_prefix, prefix), "/")
+			entries = append(entries, Entry{Name: name, IsDir: true})
+		}
+		for _, object := range object_list.Items {
+			name := strings.TrimPrefix(object.Name, prefix)
+			if name == "" {
+				// directory placeholder object
+				continue
+			}
+			entries = append(entries, Entry{Name: name})
+		}
+
+		page_token = object_list.NextPageToken
+		if page_token == "" || max_results > 0 && len(entries) >= max_results {
+			break
+		}
+	}
+
+	if len(entries) == 0 {
+		// GCS has no real directories, an empty prefix does not exist.
+		meta.Missing = true
+		return nil, meta, MissingPageError
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	return entries, meta, nil
+}
+
+func (s *gcsSource) cachedListing(path string, entry *cacheEntry) ([]Entry, error) {
+	if entry.Meta != nil && entry.Meta.Missing {
+		log.Debugf("Directory %s found in the cache as 404", path)
+		return nil, MissingPageError
+	}
+
+	var entries []Entry
+	if err := json.Unmarshal(entry.Content, &entries); err != nil {
+		return nil, fmt.Errorf("error parsing the cached listing of %s: %v", path, err)
+	}
+
+	log.Debugf("Directory %s found in the cache", path)
+	return entries, nil
+}
+
+func (s *gcsSource) ListDirectory(path string) ([]Entry, error) {
+	cache_key := strings.TrimSuffix(path, "/") + "/"
+
+	entry := s.cache.Lookup(cache_key)
+	if entry != nil {
+		entries, err := s.cachedListing(path, entry)
+		if err != nil && err != MissingPageError {
+			log.Warningf("%v", err)
+			// fetch the listing again, unconditionally
+			entry = nil
+		} else if !entry.Expired {
+			return entries, err
+		}
+	}
+
+	entries, meta, err := s.list(path, 0, entry.conditionalHeader())
+	if err == errNotModified && entry != nil {
+		if err = s.cache.Revalidated(cache_key, entry, meta); err != nil {
+			log.Warningf("Failed to update the cache entry of %s: %v", meta.URL, err)
+		}
+		return s.cachedListing(path, entry)
+	} else if err == MissingPageError {
+		if err := s.cache.PutMissing(cache_key, meta); err != nil {
+			return nil, err
+		}
+		return nil, MissingPageError
+	} else if entry != nil && IsTransientError(err) {
+		log.Warningf("Using the expired cache entry of %s: %v", path, err)
+		return s.cachedListing(path, entry)
+	} else if err != nil {
+		return nil, err
+	}
+
+	content, err := json.Marshal(entries)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding the listing of %s: %v", path, err)
+	}
+
+	if err = s.cache.Put(cache_key, content, meta); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (s *gcsSource) Stat(path string) (Entry, error) {
+	trimmed_path := strings.TrimSuffix(path, "/")
+	name := trimmed_path[strings.LastIndex(trimmed_path, "/")+1:]
+
+	if !strings.HasSuffix(path, "/") {
+		_, err := s.get(s.objectURL(path), nil)
+		if err == nil {
+			return Entry{Name: name}, nil
+		} else if err != MissingPageError {
+			return Entry{}, err
+		}
+	}
+
+	if _, _, err := s.list(path, 1, nil); err != nil {
+		return Entry{}, err
+	}
+
+	return Entry{Name: name, IsDir: true}, nil
+}
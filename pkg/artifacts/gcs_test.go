@@ -0,0 +1,292 @@
+package artifacts
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+// newFakeGcsServer serves `objects` (object name -> content) through
+// the subset of the GCS JSON API used by gcsSource.
+func newFakeGcsServer(t *testing.T, bucket string, objects map[string]string) *httptest.Server {
+	bucket_path := "/storage/v1/b/" + bucket + "/o"
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() == bucket_path {
+			prefix := r.URL.Query().Get("prefix")
+			delimiter := r.URL.Query().Get("delimiter")
+
+			list := gcsObjectList{}
+			prefixes := map[string]bool{}
+			for name := range objects {
+				if !strings.HasPrefix(name, prefix) {
+					continue
+				}
+				rest := strings.TrimPrefix(name, prefix)
+				if idx := strings.Index(rest, delimiter); delimiter != "" && idx != -1 {
+					prefixes[prefix+rest[:idx+1]] = true
+					continue
+				}
+				list.Items = append(list.Items, gcsObject{Name: name})
+			}
+			for dir_prefix := range prefixes {
+				list.Prefixes = append(list.Prefixes, dir_prefix)
+			}
+			sort.Strings(list.Prefixes)
+			sort.Slice(list.Items, func(i, j int) bool {
+				return list.Items[i].Name < list.Items[j].Name
+			})
+
+			content, _ := json.Marshal(list)
+			etag := fmt.Sprintf(`"%x"`, md5.Sum(content))
+			w.Header().Set("ETag", etag)
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Write(content)
+			return
+		}
+
+		if !strings.HasPrefix(r.URL.EscapedPath(), bucket_path+"/") {
+			t.Errorf("unexpected request: %s", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+
+		name, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), bucket_path+"/"))
+		if err != nil {
+			t.Errorf("invalid object name in %s: %v", r.URL, err)
+		}
+		content, found := objects[name]
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+
+		if r.URL.Query().Get("alt") == "media" {
+			etag := fmt.Sprintf(`"%x"`, md5.Sum([]byte(content)))
+			w.Header().Set("ETag", etag)
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Write([]byte(content))
+		} else {
+			json.NewEncoder(w).Encode(gcsObject{Name: name})
+		}
+	}))
+}
+
+func newTestGcsMatrix(t *testing.T, server *httptest.Server) (*v1.MatrixSpec, func()) {
+	cache_dir, err := ioutil.TempDir("", "ci-dashboard-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test_matrix := &v1.MatrixSpec{
+		Name: "test",
+		Source: SourceGcs,
+		ArtifactsURL: "gs://test-bucket/logs",
+		ArtifactsCache: cache_dir,
+		GcsEndpoint: server.URL,
+	}
+
+	return test_matrix, func() { os.RemoveAll(cache_dir) }
+}
+
+func TestGcsSource(t *testing.T) {
+	server := newFakeGcsServer(t, "test-bucket", map[string]string{
+		"logs/job/100/finished.json": `{"passed": true}`,
+		"logs/job/101/finished.json": `{"passed": false}`,
+		"logs/job/101/artifacts/step/ocp.version": "4.18.0\n",
+		"logs/job/latest-build.txt": "101",
+		"logs/other-job/1/finished.json": `{}`,
+	})
+	defer server.Close()
+
+	test_matrix, cleanup := newTestGcsMatrix(t, server)
+	defer cleanup()
+
+	source, err := GetSource(test_matrix)
+	if err != nil {
+		t.Fatalf("GetSource failed: %v", err)
+	}
+
+	content, err := source.ReadFile("job/101/artifacts/step/ocp.version")
+	if err != nil || string(content) != "4.18.0\n" {
+		t.Errorf("ReadFile returned %q, %v", content, err)
+	}
+
+	if _, err = source.ReadFile("job/101/missing.json"); err != MissingPageError {
+		t.Errorf("ReadFile of a missing object returned %v, expected MissingPageError", err)
+	}
+
+	entries, err := source.ListDirectory("job")
+	expected_entries := []Entry{
+		{Name: "100", IsDir: true},
+		{Name: "101", IsDir: true},
+		{Name: "latest-build.txt"},
+	}
+	if err != nil || !reflect.DeepEqual(entries, expected_entries) {
+		t.Errorf("ListDirectory returned %v, %v, expected %v", entries, err, expected_entries)
+	}
+
+	if _, err = source.ListDirectory("missing-job/"); err != MissingPageError {
+		t.Errorf("ListDirectory of a missing directory returned %v, expected MissingPageError", err)
+	}
+
+	if entry, err := source.Stat("job/101/finished.json"); err != nil || entry.IsDir {
+		t.Errorf("Stat of a file returned %v, %v", entry, err)
+	}
+	if entry, err := source.Stat("job/101/artifacts"); err != nil || !entry.IsDir {
+		t.Errorf("Stat of a directory returned %v, %v", entry, err)
+	}
+	if _, err := source.Stat("job/102"); err != MissingPageError {
+		t.Errorf("Stat of a missing entry returned %v, expected MissingPageError", err)
+	}
+}
+
+func TestGcsSourceCache(t *testing.T) {
+	server := newFakeGcsServer(t, "test-bucket", map[string]string{
+		"logs/job/100/finished.json": `{"passed": true}`,
+	})
+
+	test_matrix, cleanup := newTestGcsMatrix(t, server)
+	defer cleanup()
+
+	build_ids, finished_files, err := FetchLastNTestResults(test_matrix, "job", 5, "finished.json", TypeJson)
+	if err != nil {
+		t.Fatalf("FetchLastNTestResults failed: %v", err)
+	}
+	if !reflect.DeepEqual(build_ids, []string{"100"}) || finished_files["100"].Json["passed"] != true {
+		t.Errorf("FetchLastNTestResults returned %v, %v", build_ids, finished_files)
+	}
+
+	source, err := GetSource(test_matrix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = source.ReadFile("job/100/missing.json"); err != MissingPageError {
+		t.Errorf("ReadFile of a missing object returned %v, expected MissingPageError", err)
+	}
+
+	// everything should now be served from the cache
+	server.Close()
+
+	if _, err = source.ReadFile("job/100/missing.json"); err != MissingPageError {
+		t.Errorf("ReadFile of a missing object returned %v from the cache, expected MissingPageError", err)
+	}
+
+	build_ids, finished_files, err = FetchLastNTestResults(test_matrix, "job", 5, "finished.json", TypeJson)
+	if err != nil {
+		t.Fatalf("FetchLastNTestResults failed with the server closed: %v", err)
+	}
+	if !reflect.DeepEqual(build_ids, []string{"100"}) || finished_files["100"].Json["passed"] != true {
+		t.Errorf("FetchLastNTestResults returned %v, %v from the cache", build_ids, finished_files)
+	}
+}
+
+func TestGcsSourceRevalidation(t *testing.T) {
+	objects := map[string]string{
+		"logs/job/latest-build.txt": "100",
+	}
+	server := newFakeGcsServer(t, "test-bucket", objects)
+	defer server.Close()
+
+	not_modified := 0
+	handler := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" && objects["logs/job/latest-build.txt"] == "100" {
+			not_modified++
+		}
+		handler.ServeHTTP(w, r)
+	})
+
+	test_matrix, cleanup := newTestGcsMatrix(t, server)
+	defer cleanup()
+
+	source, err := GetSource(test_matrix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		content, err := source.ReadFile("job/latest-build.txt")
+		if err != nil || string(content) != "100" {
+			t.Errorf("ReadFile returned %q, %v", content, err)
+		}
+	}
+	if not_modified != 1 {
+		t.Errorf("latest-build.txt should have been revalidated once, got %d revalidations", not_modified)
+	}
+
+	objects["logs/job/latest-build.txt"] = "101"
+	content, err := source.ReadFile("job/latest-build.txt")
+	if err != nil || string(content) != "101" {
+		t.Errorf("ReadFile of the updated file returned %q, %v", content, err)
+	}
+}
+
+func TestGcsSourceListingRevalidation(t *testing.T) {
+	objects := map[string]string{
+		"logs/job/100/finished.json": `{"passed": true}`,
+	}
+	server := newFakeGcsServer(t, "test-bucket", objects)
+	defer server.Close()
+
+	not_modified := 0
+	handler := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		if recorder.Code == http.StatusNotModified && r.URL.Query().Get("prefix") == "logs/job/" {
+			not_modified++
+		}
+		for key, values := range recorder.Header() {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(recorder.Code)
+		w.Write(recorder.Body.Bytes())
+	})
+
+	test_matrix, cleanup := newTestGcsMatrix(t, server)
+	defer cleanup()
+	// the job listing expires immediately
+	test_matrix.Cache = &v1.CacheSpec{MutableTTL: "1ns"}
+
+	source, err := GetSource(test_matrix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		entries, err := source.ListDirectory("job")
+		if expected := []Entry{{Name: "100", IsDir: true}}; err != nil || !reflect.DeepEqual(entries, expected) {
+			t.Errorf("ListDirectory returned %v, %v, expected %v", entries, err, expected)
+		}
+	}
+	if not_modified != 1 {
+		t.Errorf("the job listing should have been revalidated once, got %d revalidations", not_modified)
+	}
+
+	objects["logs/job/101/finished.json"] = `{"passed": false}`
+	entries, err := source.ListDirectory("job")
+	if expected := []Entry{{Name: "100", IsDir: true}, {Name: "101", IsDir: true}}; err != nil || !reflect.DeepEqual(entries, expected) {
+		t.Errorf("ListDirectory of the updated job returned %v, %v, expected %v", entries, err, expected)
+	}
+	if not_modified != 1 {
+		t.Errorf("the updated job listing was not fetched again")
+	}
+}
@@ -0,0 +1,195 @@
+package artifacts
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+	"github.com/PuerkitoBio/goquery"
+)
+
+const (
+	// Google GCP hosting doesn't return a 404 error when we request a
+	// file that doesn't exist, but instead serves "an empty dir"
+	// page.  From this page, we strip all the references to the path
+	// we're requesting and compare it against this MD5sum (which was
+	// printf-ed and reinjected here :#)
+	MissingPageMD5Sum = "b66c9aae6e6cf88de034b25232ba0181"
+)
+
+// gcswebSource serves the artifacts from the HTML pages of a gcsweb
+// instance, and stores them in the `artifacts_cache` directory.
+type gcswebSource struct {
+	artifacts_url string
+	cache *artifactsCache
+	client *httpClient
+}
+
+func newGcswebSource(test_matrix *v1.MatrixSpec) (ArtifactSource, error) {
+	if test_matrix.ArtifactsURL == "" {
+		return nil, fmt.Errorf("artifacts_url is required")
+	}
+
+	client, err := newHTTPClient(test_matrix.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := newArtifactsCache(test_matrix, gcswebListingFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gcswebSource{
+		artifacts_url: test_matrix.ArtifactsURL,
+		cache: cache,
+		client: client,
+	}, nil
+}
+
+func (s *gcswebSource) RemoveFromCache(path string) error {
+	return s.cache.Remove(path)
+}
+
+func IsPageNotFound(content []byte, path string) bool {
+	fname_pos := strings.LastIndex(path,"/")
+	content = []byte(strings.ReplaceAll(string(content), path, ""))
+	content = []byte(strings.ReplaceAll(string(content), path[0:fname_pos+1], ""))
+
+	hash := md5.Sum(content)
+	hashString := hex.EncodeToString(hash[:])
+
+	return hashString == MissingPageMD5Sum
+}
+
+func (s *gcswebSource) cachedContent(artifact_url, path string, entry *cacheEntry) ([]byte, error) {
+	if entry.Meta != nil && entry.Meta.Missing || entry.Meta == nil && IsPageNotFound(entry.Content, path) {
+		log.Debugf("File %s found in the cache as 404", artifact_url)
+		return entry.Content, MissingPageError
+	}
+
+	log.Debugf("File %s found in the cache", artifact_url)
+	return entry.Content, nil
+}
+
+func (s *gcswebSource) fetch(path string) ([]byte, error) {
+	artifact_url := fmt.Sprintf("%s/%s", s.artifacts_url, path)
+
+	entry := s.cache.Lookup(path)
+	if entry != nil && !entry.Expired {
+		return s.cachedContent(artifact_url, path, entry)
+	}
+
+	log.Debugf("Fetching %s ...", artifact_url)
+	resp, err := s.client.Get(artifact_url, entry.conditionalHeader())
+	if err != nil {
+		if entry != nil && IsTransientError(err) {
+			log.Warningf("Using the expired cache entry of %s: %v", artifact_url, err)
+			return s.cachedContent(artifact_url, path, entry)
+		}
+		return []byte{}, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && entry != nil {
+		if err = s.cache.Revalidated(path, entry, newCacheMetadata(artifact_url, resp, false)); err != nil {
+			log.Warningf("Failed to update the cache entry of %s: %v", artifact_url, err)
+		}
+		return s.cachedContent(artifact_url, path, entry)
+	}
+
+	err = checkStatus(artifact_url, resp)
+	if err == MissingPageError {
+		if err := s.cache.PutMissing(path, newCacheMetadata(artifact_url, resp, true)); err != nil {
+			return []byte{}, err
+		}
+		return []byte{}, MissingPageError
+	} else if err != nil {
+		// the failed responses are not cached
+		return []byte{}, err
+	}
+	content := resp.Body
+
+	missing := IsPageNotFound(content, path)
+
+	if err = s.cache.Put(path, content, newCacheMetadata(artifact_url, resp, missing)); err != nil {
+		return []byte{}, err
+	}
+
+	if missing {
+		return content, MissingPageError
+	}
+
+	return content, nil
+}
+
+func (s *gcswebSource) ReadFile(path string) ([]byte, error) {
+	return s.fetch(path)
+}
+
+func (s *gcswebSource) ListDirectory(path string) ([]Entry, error) {
+	dir_path := strings.TrimSuffix(path, "/") + "/"
+
+	content, err := s.fetch(dir_path)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the HTML of %s: %v", dir_path, err)
+	}
+
+	return parseGcswebListing(doc), nil
+}
+
+func (s *gcswebSource) Stat(file_path string) (Entry, error) {
+	file_path = strings.TrimSuffix(file_path, "/")
+	entries, err := s.ListDirectory(path.Dir(file_path))
+	if err != nil {
+		return Entry{}, err
+	}
+
+	name := path.Base(file_path)
+	for _, entry := range entries {
+		if entry.Name == name {
+			return entry, nil
+		}
+	}
+
+	return Entry{}, MissingPageError
+}
+
+func parseGcswebListing(html_dir *goquery.Document) []Entry {
+	entries := []Entry{}
+
+	html_dir.Find("li.grid-row").EachWithBreak(func(i int, li_tag *goquery.Selection) bool {
+		entry_type, found := li_tag.Find("img").Attr("src")
+
+		if !found {
+			// li-tag doesn't contain an img-tag, this is unexpected
+			// in a directory listing.
+			return true // continue
+		}
+
+		filename := strings.TrimSuffix(strings.TrimSpace(li_tag.Find("a").Text()), "/")
+		if filename == ".." {
+			// skip "parent-dir" entry
+			return true // continue
+		}
+
+		entries = append(entries, Entry{Name: filename, IsDir: entry_type == "/icons/dir.png"})
+
+		return true
+	})
+
+	return entries
+}
+
+func ListFilesInDirectory(html_dir *goquery.Document, dirs_only, files_only bool)([]string, error) {
+	return entryNames(parseGcswebListing(html_dir), dirs_only, files_only), nil
+}
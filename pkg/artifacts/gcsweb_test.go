@@ -0,0 +1,70 @@
+package artifacts
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+	"github.com/openshift-psap/ci-dashboard/pkg/fakeprow"
+)
+
+func TestGcswebSource(t *testing.T) {
+	server, err := fakeprow.NewServer(&fakeprow.Bucket{
+		Jobs: []fakeprow.Job{{
+			Name: "job",
+			StepDir: "artifacts/step",
+			Builds: []fakeprow.Build{
+				{Id: "100", Result: "SUCCESS", OpenShiftVersion: "4.18.0\n"},
+				{Id: "101"},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	cache_dir, err := ioutil.TempDir("", "ci-dashboard-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cache_dir)
+
+	source, err := GetSource(&v1.MatrixSpec{
+		Name: "test",
+		ArtifactsURL: server.ArtifactsURL(),
+		ArtifactsCache: cache_dir,
+	})
+	if err != nil {
+		t.Fatalf("GetSource failed: %v", err)
+	}
+
+	content, err := source.ReadFile("job/100/artifacts/step/ocp.version")
+	if err != nil || string(content) != "4.18.0\n" {
+		t.Errorf("ReadFile returned %q, %v", content, err)
+	}
+
+	// twice, to go through the cache
+	for i := 0; i < 2; i++ {
+		if _, err = source.ReadFile("job/101/finished.json"); err != MissingPageError {
+			t.Errorf("ReadFile of a missing file returned %v, expected MissingPageError", err)
+		}
+		if _, err = source.ListDirectory("job/102"); err != MissingPageError {
+			t.Errorf("ListDirectory of a missing directory returned %v, expected MissingPageError", err)
+		}
+	}
+	if requests := server.Requests("logs/job/101/finished.json"); requests != 1 {
+		t.Errorf("missing file requested %d times, expected 1", requests)
+	}
+
+	entries, err := source.ListDirectory("job")
+	expected_entries := []Entry{
+		{Name: "100", IsDir: true},
+		{Name: "latest-build.txt"},
+	}
+	if err != nil || !reflect.DeepEqual(entries, expected_entries) {
+		t.Errorf("ListDirectory returned %v, %v, expected %v", entries, err, expected_entries)
+	}
+}
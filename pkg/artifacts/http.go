@@ -0,0 +1,207 @@
+package artifacts
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+const (
+	DefaultHTTPTimeout = 60 * time.Second
+	DefaultHTTPRetries = 3
+	DefaultHTTPBackoffInitial = 1 * time.Second
+	DefaultHTTPBackoffMax = 30 * time.Second
+)
+
+// TransientError reports a request that failed with a transport
+// error, a 5xx or a 429 status, even after being retried. Fetching it
+// again later may succeed.
+type TransientError struct {
+	URL string
+	Err error
+}
+
+func (e *TransientError) Error() string {
+	return fmt.Sprintf("transient failure fetching %s: %v", e.URL, e.Err)
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+// PermanentError reports a request that the server rejected, and
+// that would fail again if retried.
+type PermanentError struct {
+	URL string
+	StatusCode int
+	Status string
+}
+
+func (e *PermanentError) Error() string {
+	return fmt.Sprintf("permanent failure fetching %s: %s", e.URL, e.Status)
+}
+
+func IsTransientError(err error) bool {
+	var transient_err *TransientError
+	return errors.As(err, &transient_err)
+}
+
+func IsPermanentError(err error) bool {
+	var permanent_err *PermanentError
+	return errors.As(err, &permanent_err)
+}
+
+type httpResponse struct {
+	StatusCode int
+	Status string
+	Header http.Header
+	Body []byte
+}
+
+// httpClient performs GET requests with a per-request timeout, and
+// retries the transient failures with an exponential backoff.
+type httpClient struct {
+	client *http.Client
+	retries int
+	backoff_initial time.Duration
+	backoff_max time.Duration
+}
+
+func parseDuration(value string, default_value time.Duration) (time.Duration, error) {
+	if value == "" {
+		return default_value, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func newHTTPClient(http_spec *v1.HTTPSpec) (*httpClient, error) {
+	if http_spec == nil {
+		http_spec = &v1.HTTPSpec{}
+	}
+
+	timeout, err := parseDuration(http_spec.Timeout, DefaultHTTPTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP timeout '%s': %v", http_spec.Timeout, err)
+	}
+
+	backoff_initial, err := parseDuration(http_spec.BackoffInitial, DefaultHTTPBackoffInitial)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP initial backoff '%s': %v", http_spec.BackoffInitial, err)
+	}
+
+	backoff_max, err := parseDuration(http_spec.BackoffMax, DefaultHTTPBackoffMax)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP maximum backoff '%s': %v", http_spec.BackoffMax, err)
+	}
+
+	retries := DefaultHTTPRetries
+	if http_spec.Retries != nil {
+		retries = *http_spec.Retries
+	}
+
+	return &httpClient{
+		client: &http.Client{Timeout: timeout, Transport: newFixtureTransport()},
+		retries: retries,
+		backoff_initial: backoff_initial,
+		backoff_max: backoff_max,
+	}, nil
+}
+
+func isRetryableStatus(status_code int) bool {
+	return status_code >= 500 || status_code == http.StatusTooManyRequests
+}
+
+func (c *httpClient) backoff(attempt int, header http.Header) time.Duration {
+	delay := c.backoff_initial << uint(attempt)
+	// honor the server request, if any
+	if seconds, err := strconv.Atoi(header.Get("Retry-After")); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	}
+	if delay > c.backoff_max || delay <= 0 {
+		delay = c.backoff_max
+	}
+	return delay
+}
+
+func (c *httpClient) do(request_url string, header http.Header) (*httpResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, request_url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing the request of %s: %v", request_url, err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &httpResponse{
+		StatusCode: resp.StatusCode,
+		Status: resp.Status,
+		Header: resp.Header,
+		Body: content,
+	}, nil
+}
+
+// Get fetches `request_url`, retrying the transient failures. The
+// response is returned whatever its status code, except when the
+// retries are exhausted, where a TransientError is returned.
+func (c *httpClient) Get(request_url string, header http.Header) (*httpResponse, error) {
+	var last_err error
+	for attempt := 0; ; attempt++ {
+		resp, err := c.do(request_url, header)
+		if err == nil && !isRetryableStatus(resp.StatusCode) {
+			return resp, nil
+		}
+
+		var unrecorded_err *UnrecordedRequestError
+		if errors.As(err, &unrecorded_err) {
+			// retrying won't help
+			return nil, unrecorded_err
+		}
+
+		var delay time.Duration
+		if err != nil {
+			last_err = err
+			delay = c.backoff(attempt, http.Header{})
+		} else {
+			last_err = fmt.Errorf("%s", resp.Status)
+			delay = c.backoff(attempt, resp.Header)
+		}
+
+		if attempt >= c.retries {
+			break
+		}
+
+		log.Debugf("Fetching %s failed (%v), retrying in %v ...", request_url, last_err, delay)
+		time.Sleep(delay)
+	}
+
+	return nil, &TransientError{URL: request_url, Err: last_err}
+}
+
+// checkStatus turns the non-200 responses into errors: a 404 status
+// is a MissingPageError, the others are PermanentErrors.
+func checkStatus(request_url string, resp *httpResponse) error {
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusNotFound:
+		return MissingPageError
+	default:
+		return &PermanentError{URL: request_url, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+}
@@ -0,0 +1,51 @@
+package artifacts
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+func TestHTTPClientRetries(t *testing.T) {
+	retries := 2
+	client, err := newHTTPClient(&v1.HTTPSpec{Retries: &retries, BackoffInitial: "1ms", BackoffMax: "5ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status_codes := []int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status_code := status_codes[0]
+		status_codes = status_codes[1:]
+		w.WriteHeader(status_code)
+	}))
+	defer server.Close()
+
+	status_codes = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}
+	resp, err := client.Get(server.URL, nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Get should have succeeded after 2 retries, got %v, %v", resp, err)
+	}
+
+	status_codes = []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}
+	if _, err = client.Get(server.URL, nil); !IsTransientError(err) {
+		t.Errorf("Get should have failed with a transient error, got %v", err)
+	}
+
+	status_codes = []int{http.StatusForbidden}
+	resp, err = client.Get(server.URL, nil)
+	if err != nil {
+		t.Fatalf("Get should not retry a 403 status, got %v", err)
+	}
+	if err = checkStatus(server.URL, resp); !IsPermanentError(err) {
+		t.Errorf("a 403 status should be a permanent error, got %v", err)
+	}
+
+	status_codes = []int{http.StatusNotFound}
+	resp, _ = client.Get(server.URL, nil)
+	if err = checkStatus(server.URL, resp); err != MissingPageError {
+		t.Errorf("a 404 status should be a MissingPageError, got %v", err)
+	}
+}
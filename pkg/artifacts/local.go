@@ -0,0 +1,87 @@
+package artifacts
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+const (
+	SourceLocal = "local"
+
+	LocalURLScheme = "file://"
+)
+
+// localSource serves the artifacts from a local directory tree
+// mirroring the `<prow_name>/<build_id>/artifacts/...` layout of the
+// Prow bucket. The `artifacts_url` of the matrix must be a `file://`
+// path. Local artifacts are never copied into the artifacts cache.
+type localSource struct {
+	root string
+}
+
+func newLocalSource(test_matrix *v1.MatrixSpec) (ArtifactSource, error) {
+	if !strings.HasPrefix(test_matrix.ArtifactsURL, LocalURLScheme) {
+		return nil, fmt.Errorf("artifacts_url '%s' should be a '%s' path", test_matrix.ArtifactsURL, LocalURLScheme)
+	}
+
+	root := strings.TrimPrefix(test_matrix.ArtifactsURL, LocalURLScheme)
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid artifacts directory: %v", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("artifacts directory '%s' is not a directory", root)
+	}
+
+	return &localSource{root: root}, nil
+}
+
+func (s *localSource) localPath(path string) string {
+	// cleaning the path with a leading "/" drops the ".." elements
+	// that would escape the root directory.
+	return filepath.Join(s.root, filepath.Clean(filepath.FromSlash("/" + path)))
+}
+
+func localError(err error) error {
+	if os.IsNotExist(err) {
+		return MissingPageError
+	}
+	return err
+}
+
+func (s *localSource) ReadFile(path string) ([]byte, error) {
+	content, err := ioutil.ReadFile(s.localPath(path))
+	if err != nil {
+		return []byte{}, localError(err)
+	}
+
+	return content, nil
+}
+
+func (s *localSource) ListDirectory(path string) ([]Entry, error) {
+	files, err := ioutil.ReadDir(s.localPath(path))
+	if err != nil {
+		return nil, localError(err)
+	}
+
+	entries := []Entry{}
+	for _, file := range files {
+		entries = append(entries, Entry{Name: file.Name(), IsDir: file.IsDir()})
+	}
+
+	return entries, nil
+}
+
+func (s *localSource) Stat(path string) (Entry, error) {
+	info, err := os.Stat(s.localPath(path))
+	if err != nil {
+		return Entry{}, localError(err)
+	}
+
+	return Entry{Name: info.Name(), IsDir: info.IsDir()}, nil
+}
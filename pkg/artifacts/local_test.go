@@ -0,0 +1,156 @@
+package artifacts
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+func newTestLocalTree(t *testing.T, files map[string]string) (string, func()) {
+	root, err := ioutil.TempDir("", "ci-dashboard-local-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for file_path, content := range files {
+		local_path := filepath.Join(root, filepath.FromSlash(file_path))
+		if err := os.MkdirAll(filepath.Dir(local_path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(local_path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root, func() { os.RemoveAll(root) }
+}
+
+func TestLocalSource(t *testing.T) {
+	root, cleanup := newTestLocalTree(t, map[string]string{
+		"job/100/finished.json": `{"passed": true}`,
+		"job/101/finished.json": `{"passed": false}`,
+		"job/101/artifacts/step/ocp.version": "4.18.0\n",
+		"job/latest-build.txt": "101",
+	})
+	defer cleanup()
+
+	test_matrix := &v1.MatrixSpec{Name: "test", ArtifactsURL: LocalURLScheme + root}
+	if source_type := sourceType(test_matrix); source_type != SourceLocal {
+		t.Fatalf("got source type %s for a file:// URL, expected %s", source_type, SourceLocal)
+	}
+
+	source, err := GetSource(test_matrix)
+	if err != nil {
+		t.Fatalf("GetSource failed: %v", err)
+	}
+
+	content, err := source.ReadFile("job/101/artifacts/step/ocp.version")
+	if err != nil || string(content) != "4.18.0\n" {
+		t.Errorf("ReadFile returned %q, %v", content, err)
+	}
+	if _, err = source.ReadFile("job/101/missing.json"); err != MissingPageError {
+		t.Errorf("ReadFile of a missing file returned %v, expected MissingPageError", err)
+	}
+	// the paths cannot escape the root directory
+	if _, err = source.ReadFile("../" + filepath.Base(root) + "/job/latest-build.txt"); err != MissingPageError {
+		t.Errorf("ReadFile of a path outside the root returned %v, expected MissingPageError", err)
+	}
+
+	entries, err := source.ListDirectory("job")
+	expected_entries := []Entry{
+		{Name: "100", IsDir: true},
+		{Name: "101", IsDir: true},
+		{Name: "latest-build.txt"},
+	}
+	if err != nil || !reflect.DeepEqual(entries, expected_entries) {
+		t.Errorf("ListDirectory returned %v, %v, expected %v", entries, err, expected_entries)
+	}
+	if _, err = source.ListDirectory("missing-job/"); err != MissingPageError {
+		t.Errorf("ListDirectory of a missing directory returned %v, expected MissingPageError", err)
+	}
+
+	if entry, err := source.Stat("job/101/finished.json"); err != nil || entry.IsDir || entry.Name != "finished.json" {
+		t.Errorf("Stat of a file returned %v, %v", entry, err)
+	}
+	if entry, err := source.Stat("job/101/artifacts"); err != nil || !entry.IsDir {
+		t.Errorf("Stat of a directory returned %v, %v", entry, err)
+	}
+	if _, err := source.Stat("job/102"); err != MissingPageError {
+		t.Errorf("Stat of a missing entry returned %v, expected MissingPageError", err)
+	}
+}
+
+func TestLocalSourceBuildArtifacts(t *testing.T) {
+	root, cleanup := newTestLocalTree(t, map[string]string{
+		"job/100/finished.json": `{"passed": true}`,
+		"job/101/finished.json": `{"passed": false}`,
+		"job/102/started.json": `{}`,
+		"job/102/artifacts/e2e/step/artifacts/_WARNING/nodes": "not enough nodes",
+		"job/102/artifacts/e2e/step/artifacts/000__cluster/_ansible.log.json": "[]",
+		"job/latest-build.txt": "102",
+	})
+	defer cleanup()
+
+	cache_dir, err := ioutil.TempDir("", "ci-dashboard-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cache_dir)
+
+	test_matrix := &v1.MatrixSpec{
+		Name: "test",
+		ArtifactsURL: LocalURLScheme + root,
+		ArtifactsCache: cache_dir,
+		ProwStep: "step",
+	}
+
+	build_ids, finished_files, err := FetchLastNTestResults(test_matrix, "job", 2, "finished.json", TypeJson)
+	if err != nil && err != MissingPageError {
+		t.Fatalf("FetchLastNTestResults failed: %v", err)
+	}
+	if !reflect.DeepEqual(build_ids, []string{"102", "101"}) || finished_files["101"].Json["passed"] != false {
+		t.Errorf("FetchLastNTestResults returned %v, %v", build_ids, finished_files)
+	}
+
+	test_result := &v1.TestResult{
+		BuildId: "102",
+		TestSpec: &v1.TestSpec{ProwName: "job", TestName: "e2e", Matrix: test_matrix},
+	}
+	messages, err := FetchTestMessages("_WARNING", test_result)
+	if err != nil || messages["nodes"] != "not enough nodes" {
+		t.Errorf("FetchTestMessages returned %v, %v", messages, err)
+	}
+	if steps, err := FetchTestToolboxSteps(test_result); err != nil || !reflect.DeepEqual(steps, []string{"000__cluster", "_WARNING"}) {
+		t.Errorf("FetchTestToolboxSteps returned %v, %v", steps, err)
+	}
+	if _, err = FetchTestMessages("_ERROR", test_result); err != MissingPageError {
+		t.Errorf("FetchTestMessages of a missing directory returned %v, expected MissingPageError", err)
+	}
+
+	// the local artifacts are never copied into the cache
+	if cached, err := ioutil.ReadDir(cache_dir); err != nil || len(cached) != 0 {
+		t.Errorf("the local artifacts were cached: %v, %v", cached, err)
+	}
+}
+
+func TestLocalSourceInvalidRoot(t *testing.T) {
+	root, cleanup := newTestLocalTree(t, map[string]string{"file.txt": "content"})
+	defer cleanup()
+
+	for _, artifacts_url := range []string{
+		LocalURLScheme + filepath.Join(root, "missing"),
+		LocalURLScheme + filepath.Join(root, "file.txt"),
+	} {
+		if _, err := GetSource(&v1.MatrixSpec{Name: "test", ArtifactsURL: artifacts_url}); err == nil {
+			t.Errorf("GetSource of %s succeeded", artifacts_url)
+		}
+	}
+
+	if _, err := newLocalSource(&v1.MatrixSpec{Name: "test", ArtifactsURL: root}); err == nil {
+		t.Errorf("newLocalSource accepted an artifacts_url without the %s scheme", LocalURLScheme)
+	}
+}
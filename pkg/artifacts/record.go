@@ -0,0 +1,276 @@
+package artifacts
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	fixtureIndexFile = "index.json"
+	fixtureBodiesDir = "bodies"
+	fixtureVersion = 1
+)
+
+// UnrecordedRequestError reports a request performed in replay mode
+// that isn't part of the fixture archive.
+type UnrecordedRequestError struct {
+	URL string
+}
+
+func (e *UnrecordedRequestError) Error() string {
+	return fmt.Sprintf("request of %s not found in the replay fixtures", e.URL)
+}
+
+type fixtureResponse struct {
+	StatusCode int        `json:"status_code"`
+	Header http.Header    `json:"header,omitempty"`
+	// path of the body file, relative to the archive directory
+	Body string           `json:"body"`
+}
+
+// fixtureIndex is the index of a fixture archive: a directory with the
+// `index.json` file, and the response bodies, named after their
+// SHA256 checksum, in the `bodies` directory.
+type fixtureIndex struct {
+	Version int                           `json:"version"`
+	RecordedAt time.Time                  `json:"recorded_at"`
+	Responses map[string]fixtureResponse  `json:"responses"`
+}
+
+// fixtures holds the record or replay state of the artifacts
+// fetcher. At most one of the modes can be active.
+var fixtures = struct {
+	sync.Mutex
+	dir string
+	recording bool
+	replaying bool
+	index fixtureIndex
+	misses map[string]bool
+}{}
+
+func isFixtureModeActive() bool {
+	fixtures.Lock()
+	defer fixtures.Unlock()
+
+	return fixtures.recording || fixtures.replaying
+}
+
+// StartRecording stores all the HTTP requests performed by the
+// artifacts sources into the `dir` fixture archive, until
+// StopRecording is called. The artifacts cache is disabled, so that
+// every artifact gets recorded.
+func StartRecording(dir string) error {
+	fixtures.Lock()
+	defer fixtures.Unlock()
+
+	if fixtures.recording || fixtures.replaying {
+		return fmt.Errorf("record or replay mode already active")
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, fixtureBodiesDir), os.ModePerm); err != nil {
+		return fmt.Errorf("error creating the fixture directory %s: %v", dir, err)
+	}
+
+	fixtures.dir = dir
+	fixtures.recording = true
+	fixtures.index = fixtureIndex{
+		Version: fixtureVersion,
+		RecordedAt: time.Now().UTC(),
+		Responses: map[string]fixtureResponse{},
+	}
+
+	log.Infof("Recording the artifacts requests into %s", dir)
+
+	return nil
+}
+
+// StopRecording writes the index of the fixture archive.
+func StopRecording() error {
+	fixtures.Lock()
+	defer fixtures.Unlock()
+
+	if !fixtures.recording {
+		return nil
+	}
+	fixtures.recording = false
+
+	content, err := json.MarshalIndent(fixtures.index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding the fixture index: %v", err)
+	}
+
+	index_path := filepath.Join(fixtures.dir, fixtureIndexFile)
+	if err = ioutil.WriteFile(index_path, content, 0644); err != nil {
+		return fmt.Errorf("error writing the fixture index %s: %v", index_path, err)
+	}
+
+	log.Infof("%d artifacts requests recorded into %s", len(fixtures.index.Responses), fixtures.dir)
+
+	return nil
+}
+
+// StartReplay serves all the HTTP requests of the artifacts sources
+// from the `dir` fixture archive. The artifacts cache is disabled, and
+// the requests missing from the archive fail with an
+// UnrecordedRequestError.
+func StartReplay(dir string) error {
+	fixtures.Lock()
+	defer fixtures.Unlock()
+
+	if fixtures.recording || fixtures.replaying {
+		return fmt.Errorf("record or replay mode already active")
+	}
+
+	index_path := filepath.Join(dir, fixtureIndexFile)
+	content, err := ioutil.ReadFile(index_path)
+	if err != nil {
+		return fmt.Errorf("error reading the fixture index: %v", err)
+	}
+
+	var index fixtureIndex
+	if err = json.Unmarshal(content, &index); err != nil {
+		return fmt.Errorf("error parsing the fixture index %s: %v", index_path, err)
+	}
+	if index.Version != fixtureVersion {
+		return fmt.Errorf("unsupported fixture version %d in %s", index.Version, index_path)
+	}
+
+	fixtures.dir = dir
+	fixtures.replaying = true
+	fixtures.index = index
+	fixtures.misses = map[string]bool{}
+
+	log.Infof("Replaying the artifacts requests from %s (recorded at %s)", dir, index.RecordedAt)
+
+	return nil
+}
+
+// StopReplay goes back to fetching the artifacts from their source.
+func StopReplay() {
+	fixtures.Lock()
+	defer fixtures.Unlock()
+
+	fixtures.replaying = false
+	fixtures.index = fixtureIndex{}
+}
+
+// ReplayTime returns the time when the replayed fixtures were
+// recorded, so that the replayed outputs can be reproduced exactly.
+func ReplayTime() (time.Time, bool) {
+	fixtures.Lock()
+	defer fixtures.Unlock()
+
+	return fixtures.index.RecordedAt, fixtures.replaying
+}
+
+// ReplayMisses returns the URLs requested in replay mode but missing
+// from the fixture archive.
+func ReplayMisses() []string {
+	fixtures.Lock()
+	defer fixtures.Unlock()
+
+	misses := []string{}
+	for miss := range fixtures.misses {
+		misses = append(misses, miss)
+	}
+	sort.Strings(misses)
+
+	return misses
+}
+
+// fixtureTransport records or replays the requests going through it.
+type fixtureTransport struct {
+	next http.RoundTripper
+}
+
+func newFixtureTransport() http.RoundTripper {
+	return &fixtureTransport{next: http.DefaultTransport}
+}
+
+func (t *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fixtures.Lock()
+	replaying := fixtures.replaying
+	recording := fixtures.recording
+	fixtures.Unlock()
+
+	if replaying {
+		return t.replay(req)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || !recording {
+		return resp, err
+	}
+
+	return t.record(req, resp)
+}
+
+func (t *fixtureTransport) record(req *http.Request, resp *http.Response) (*http.Response, error) {
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	checksum := sha256.Sum256(content)
+	body_path := filepath.Join(fixtureBodiesDir, hex.EncodeToString(checksum[:]))
+
+	header := resp.Header.Clone()
+	header.Del("Date")
+
+	fixtures.Lock()
+	defer fixtures.Unlock()
+
+	if err = ioutil.WriteFile(filepath.Join(fixtures.dir, body_path), content, 0644); err != nil {
+		return nil, fmt.Errorf("error recording the body of %s: %v", req.URL, err)
+	}
+
+	fixtures.index.Responses[req.URL.String()] = fixtureResponse{
+		StatusCode: resp.StatusCode,
+		Header: header,
+		Body: filepath.ToSlash(body_path),
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(content))
+
+	return resp, nil
+}
+
+func (t *fixtureTransport) replay(req *http.Request) (*http.Response, error) {
+	fixtures.Lock()
+	defer fixtures.Unlock()
+
+	recorded, found := fixtures.index.Responses[req.URL.String()]
+	if !found {
+		log.Errorf("Request of %s not found in the replay fixtures", req.URL)
+		fixtures.misses[req.URL.String()] = true
+		return nil, &UnrecordedRequestError{URL: req.URL.String()}
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(fixtures.dir, filepath.FromSlash(recorded.Body)))
+	if err != nil {
+		return nil, fmt.Errorf("error reading the recorded body of %s: %v", req.URL, err)
+	}
+
+	return &http.Response{
+		Status: fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode: recorded.StatusCode,
+		Proto: "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: recorded.Header.Clone(),
+		Body: ioutil.NopCloser(bytes.NewReader(content)),
+		ContentLength: int64(len(content)),
+		Request: req,
+	}, nil
+}
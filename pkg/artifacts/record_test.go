@@ -0,0 +1,65 @@
+package artifacts
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	server := newFakeGcsServer(t, "test-bucket", map[string]string{
+		"logs/job/100/finished.json": `{"passed": true}`,
+	})
+
+	fixture_dir, err := ioutil.TempDir("", "ci-dashboard-fixtures-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(fixture_dir)
+
+	if err = StartRecording(fixture_dir); err != nil {
+		t.Fatalf("StartRecording failed: %v", err)
+	}
+
+	recorded_matrix, cleanup := newTestGcsMatrix(t, server)
+	defer cleanup()
+
+	build_ids, finished_files, err := FetchLastNTestResults(recorded_matrix, "job", 5, "finished.json", TypeJson)
+	if err != nil || len(build_ids) != 1 || finished_files["100"].Json["passed"] != true {
+		t.Fatalf("FetchLastNTestResults returned %v, %v, %v while recording", build_ids, finished_files, err)
+	}
+
+	if err = StopRecording(); err != nil {
+		t.Fatalf("StopRecording failed: %v", err)
+	}
+
+	server.Close()
+
+	if err = StartReplay(fixture_dir); err != nil {
+		t.Fatalf("StartReplay failed: %v", err)
+	}
+	defer StopReplay()
+
+	replayed_matrix, cleanup := newTestGcsMatrix(t, server)
+	defer cleanup()
+
+	build_ids, finished_files, err = FetchLastNTestResults(replayed_matrix, "job", 5, "finished.json", TypeJson)
+	if err != nil || len(build_ids) != 1 || finished_files["100"].Json["passed"] != true {
+		t.Errorf("FetchLastNTestResults returned %v, %v, %v while replaying", build_ids, finished_files, err)
+	}
+
+	if misses := ReplayMisses(); len(misses) != 0 {
+		t.Errorf("unexpected replay misses: %v", misses)
+	}
+
+	source, err := GetSource(replayed_matrix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = source.ReadFile("job/100/started.json"); err == nil || IsTransientError(err) {
+		t.Errorf("ReadFile of an unrecorded file should fail without being retried, got %v", err)
+	}
+	if misses := ReplayMisses(); len(misses) != 1 {
+		t.Errorf("the unrecorded request should be reported, got %v", misses)
+	}
+}
@@ -0,0 +1,117 @@
+package artifacts
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+const (
+	SourceGcsweb = "gcsweb"
+
+	DefaultSource = SourceGcsweb
+)
+
+// Entry describes a file or a directory of an ArtifactSource.
+type Entry struct {
+	Name string
+	IsDir bool
+}
+
+// ArtifactSource gives access to the artifacts of a Prow bucket. Paths
+// are relative to the matrix `artifacts_url`, and missing files or
+// directories are reported with MissingPageError.
+type ArtifactSource interface {
+	// ReadFile returns the content of the file at `path`.
+	ReadFile(path string) ([]byte, error)
+	// ListDirectory returns the entries of the directory at `path`.
+	ListDirectory(path string) ([]Entry, error)
+	// Stat returns the entry describing `path`.
+	Stat(path string) (Entry, error)
+}
+
+// cachedSource is implemented by the sources storing the artifacts
+// in the `artifacts_cache` directory.
+type cachedSource interface {
+	RemoveFromCache(path string) error
+}
+
+type sourceFactory func(test_matrix *v1.MatrixSpec) (ArtifactSource, error)
+
+var sourceFactories = map[string]sourceFactory{
+	SourceGcsweb: newGcswebSource,
+	SourceGcs: newGcsSource,
+	SourceLocal: newLocalSource,
+}
+
+var sources = struct {
+	sync.Mutex
+	instances map[string]ArtifactSource
+}{instances: map[string]ArtifactSource{}}
+
+func sourceType(test_matrix *v1.MatrixSpec) string {
+	if test_matrix.Source != "" {
+		return test_matrix.Source
+	}
+
+	if strings.HasPrefix(test_matrix.ArtifactsURL, LocalURLScheme) {
+		return SourceLocal
+	}
+
+	return DefaultSource
+}
+
+// GetSource returns the ArtifactSource serving the artifacts of
+// `test_matrix`. Sources are shared between the matrices pointing to
+// the same artifacts.
+func GetSource(test_matrix *v1.MatrixSpec) (ArtifactSource, error) {
+	source_type := sourceType(test_matrix)
+	key := strings.Join([]string{source_type, test_matrix.ArtifactsURL, test_matrix.ArtifactsCache,
+		test_matrix.GcsEndpoint, httpSpecKey(test_matrix.HTTP)}, "|")
+
+	sources.Lock()
+	defer sources.Unlock()
+
+	if source, found := sources.instances[key]; found {
+		return source, nil
+	}
+
+	factory, found := sourceFactories[source_type]
+	if !found {
+		return nil, fmt.Errorf("invalid artifacts source '%s' for matrix %s", source_type, test_matrix.Name)
+	}
+
+	source, err := factory(test_matrix)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the '%s' artifacts source of matrix %s: %v",
+			source_type, test_matrix.Name, err)
+	}
+
+	sources.instances[key] = source
+
+	return source, nil
+}
+
+func httpSpecKey(http_spec *v1.HTTPSpec) string {
+	if http_spec == nil {
+		return ""
+	}
+	retries := ""
+	if http_spec.Retries != nil {
+		retries = fmt.Sprintf("%d", *http_spec.Retries)
+	}
+	return strings.Join([]string{http_spec.Timeout, retries, http_spec.BackoffInitial, http_spec.BackoffMax}, ",")
+}
+
+func entryNames(entries []Entry, dirs_only, files_only bool) []string {
+	names := []string{}
+	for _, entry := range entries {
+		if files_only && entry.IsDir || dirs_only && !entry.IsDir {
+			continue
+		}
+		names = append(names, entry.Name)
+	}
+	return names
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+var failureCategories = map[string]bool{
+	v1.FailureCategoryInfraFlake: true,
+	v1.FailureCategoryProductBug: true,
+	v1.FailureCategoryTestBug: true,
+}
+
+var failureSources = map[string]bool{
+	v1.FailureSourceBuildLog: true,
+	v1.FailureSourceFailure: true,
+	v1.FailureSourceAnsible: true,
+	v1.FailureSourceJUnit: true,
+}
+
+func validateFailureRules(rules []v1.FailureRule) error {
+	for idx, rule := range rules {
+		if rule.Name == "" {
+			return fmt.Errorf("failure rule #%d has no name", idx)
+		}
+		if !failureCategories[rule.Category] {
+			return fmt.Errorf("failure rule '%s' has an invalid category '%s'", rule.Name, rule.Category)
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("failure rule '%s' has an invalid pattern: %v", rule.Name, err)
+		}
+		for _, source := range rule.Sources {
+			if !failureSources[source] {
+				return fmt.Errorf("failure rule '%s' has an invalid source '%s'", rule.Name, source)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidateMatricesSpec checks the settings which cannot be checked
+// while parsing the configuration file.
+func ValidateMatricesSpec(spec *v1.MatricesSpec) error {
+	if err := validateFailureRules(spec.FailureRules); err != nil {
+		return err
+	}
+
+	for matrix_name, matrix := range spec.Matrices {
+		if err := validateFailureRules(matrix.FailureRules); err != nil {
+			return fmt.Errorf("matrix %s: %v", matrix_name, err)
+		}
+	}
+
+	return nil
+}
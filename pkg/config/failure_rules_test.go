@@ -0,0 +1,34 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+func TestValidateFailureRules(t *testing.T) {
+	valid := v1.FailureRule{Name: "rule", Category: v1.FailureCategoryInfraFlake, Pattern: "timeout"}
+
+	for _, tc := range []struct {
+		rule v1.FailureRule
+		err string
+	}{
+		{valid, ""},
+		{v1.FailureRule{Category: v1.FailureCategoryTestBug, Pattern: "x"}, "has no name"},
+		{v1.FailureRule{Name: "rule", Category: "flake", Pattern: "x"}, "invalid category"},
+		{v1.FailureRule{Name: "rule", Category: v1.FailureCategoryProductBug, Pattern: "(unclosed"}, "invalid pattern"},
+		{v1.FailureRule{Name: "rule", Category: v1.FailureCategoryProductBug, Pattern: "x", Sources: []string{"logs"}},
+			"invalid source"},
+	} {
+		spec := &v1.MatricesSpec{
+			Matrices: map[string]v1.MatrixSpec{"matrix": {FailureRules: []v1.FailureRule{tc.rule}}},
+		}
+		err := ValidateMatricesSpec(spec)
+		if tc.err == "" && err != nil {
+			t.Errorf("%+v: unexpected error: %v", tc.rule, err)
+		} else if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%+v: got error %v, expected %q", tc.rule, err, tc.err)
+		}
+	}
+}
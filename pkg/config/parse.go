@@ -32,6 +32,9 @@ func ParseMatricesConfigFile(configFile string) (*v1.MatricesSpec, error) {
 	if err != nil {
 		return nil, fmt.Errorf("unmarshal error: %v", err)
 	}
+	if err = ValidateMatricesSpec(&spec); err != nil {
+		return nil, fmt.Errorf("validation error: %v", err)
+	}
 	fmt.Println("--")
 	str, err := yaml.Marshal(spec)
 	fmt.Println(string(str))
@@ -0,0 +1,312 @@
+// Package fakeprow serves a synthetic Prow artifacts bucket with a
+// gcsweb-like web server, so that the dashboard commands can be
+// exercised without network access.
+package fakeprow
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	DefaultBucketName = "test-bucket"
+	LogsDir = "logs"
+)
+
+// Bucket describes the content of the fake artifacts bucket.
+type Bucket struct {
+	// name of the bucket, in the URL of the artifacts
+	Name string `json:"name,omitempty"`
+
+	Jobs []Job `json:"jobs"`
+}
+
+// Job describes the builds of a Prow job, stored in `logs/<name>/`.
+type Job struct {
+	Name string `json:"name"`
+	// path of the test step artifacts, relative to the build
+	// directory, eg "artifacts/<test_name>/<prow_step>/artifacts"
+	StepDir string `json:"step_dir"`
+
+	Builds []Build `json:"builds"`
+}
+
+// Build describes a build of a Prow job. The empty fields do not
+// generate any file.
+type Build struct {
+	Id string `json:"id"`
+	// result of the build (SUCCESS, FAILURE, ...). No finished.json
+	// is generated if empty, as for the builds still running.
+	Result string `json:"result,omitempty"`
+	// finish time of the build
+	Timestamp int64 `json:"timestamp,omitempty"`
+	// start time of the build, stored in started.json
+	StartTimestamp int64 `json:"start_timestamp,omitempty"`
+
+	// creation time and timeout of the Prow job, stored in
+	// prowjob.json
+	CreationTimestamp int64 `json:"creation_timestamp,omitempty"`
+	Timeout string `json:"timeout,omitempty"`
+
+	// result of the test step, stored in the step finished.json
+	StepResult string `json:"step_result,omitempty"`
+	// content of the FAILURE file of the test step
+	Failure string `json:"failure,omitempty"`
+
+	OpenShiftVersion string `json:"openshift_version,omitempty"`
+	OperatorVersion string `json:"operator_version,omitempty"`
+	CiArtifactsVersion string `json:"ci_artifacts_version,omitempty"`
+
+	// messages of the test step, indexed by type (_INFO, _WARNING,
+	// _ERROR, _FLAKE) then by name
+	Messages map[string]map[string]string `json:"messages,omitempty"`
+
+	Steps []Step `json:"steps,omitempty"`
+
+	// additional files, relative to the build directory
+	Files map[string]string `json:"files,omitempty"`
+}
+
+// Step describes a toolbox step of the test step, with its
+// `_ansible.log.json` file.
+type Step struct {
+	Name string `json:"name"`
+	Ok int `json:"ok"`
+	Failures int `json:"failures"`
+	Ignored int `json:"ignored"`
+
+	// tasks of the ansible log which failed
+	FailedTasks []FailedTask `json:"failed_tasks,omitempty"`
+
+	// content of the FLAKE and EXPECTED_FAIL files
+	Flake string `json:"flake,omitempty"`
+	ExpectedFail string `json:"expected_fail,omitempty"`
+
+	// additional files, relative to the step directory
+	Files map[string]string `json:"files,omitempty"`
+}
+
+// FailedTask is an ansible task which failed on a host.
+type FailedTask struct {
+	// "role : task" name of the task
+	Name string `json:"name"`
+	// "localhost" if empty
+	Host string `json:"host,omitempty"`
+	Msg string `json:"msg,omitempty"`
+	Stderr string `json:"stderr,omitempty"`
+}
+
+func LoadBucket(filename string) (*Bucket, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the bucket description from %s: %v", filename, err)
+	}
+
+	bucket := &Bucket{}
+	if err = yaml.Unmarshal(content, bucket); err != nil {
+		return nil, fmt.Errorf("error parsing the bucket description from %s: %v", filename, err)
+	}
+
+	return bucket, nil
+}
+
+func (b *Bucket) bucketName() string {
+	if b.Name == "" {
+		return DefaultBucketName
+	}
+	return b.Name
+}
+
+// Files generates the files of the bucket, indexed by their path
+// from the root of the bucket.
+func (b *Bucket) Files() (map[string][]byte, error) {
+	files := map[string][]byte{}
+
+	for _, job := range b.Jobs {
+		if job.Name == "" {
+			return nil, fmt.Errorf("invalid job without a name")
+		}
+		job_dir := path.Join(LogsDir, job.Name)
+
+		build_ids := []string{}
+		for _, build := range job.Builds {
+			if build.Id == "" {
+				return nil, fmt.Errorf("invalid build without an id in job %s", job.Name)
+			}
+
+			if err := build.addFiles(files, path.Join(job_dir, build.Id), job.StepDir); err != nil {
+				return nil, fmt.Errorf("error generating the files of %s/%s: %v", job.Name, build.Id, err)
+			}
+			build_ids = append(build_ids, build.Id)
+		}
+
+		if len(build_ids) != 0 {
+			sort.Strings(build_ids)
+			files[path.Join(job_dir, "latest-build.txt")] = []byte(build_ids[len(build_ids) - 1])
+		}
+	}
+
+	return files, nil
+}
+
+func addJsonFile(files map[string][]byte, file_path string, value interface{}) error {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	files[file_path] = content
+
+	return nil
+}
+
+func (build *Build) addFiles(files map[string][]byte, build_dir, step_dir string) error {
+	step_dir = path.Join(build_dir, step_dir)
+
+	if build.Result != "" {
+		finished := map[string]interface{}{
+			"passed": build.Result == "SUCCESS",
+			"result": build.Result,
+			"timestamp": build.Timestamp,
+		}
+		if err := addJsonFile(files, path.Join(build_dir, "finished.json"), finished); err != nil {
+			return err
+		}
+	}
+
+	if build.StartTimestamp != 0 {
+		started := map[string]interface{}{
+			"timestamp": build.StartTimestamp,
+		}
+		if err := addJsonFile(files, path.Join(build_dir, "started.json"), started); err != nil {
+			return err
+		}
+	}
+
+	if build.CreationTimestamp != 0 || build.Timeout != "" {
+		prowjob := map[string]interface{}{
+			"kind": "ProwJob",
+			"metadata": map[string]interface{}{},
+			"spec": map[string]interface{}{},
+		}
+		if build.CreationTimestamp != 0 {
+			prowjob["metadata"] = map[string]interface{}{
+				"creationTimestamp": time.Unix(build.CreationTimestamp, 0).UTC().Format(time.RFC3339),
+			}
+		}
+		if build.Timeout != "" {
+			prowjob["spec"] = map[string]interface{}{
+				"decoration_config": map[string]interface{}{"timeout": build.Timeout},
+			}
+		}
+		if err := addJsonFile(files, path.Join(build_dir, "prowjob.json"), prowjob); err != nil {
+			return err
+		}
+	}
+
+	if build.StepResult != "" {
+		finished := map[string]interface{}{
+			"passed": build.StepResult == "SUCCESS",
+			"result": build.StepResult,
+			"timestamp": build.Timestamp,
+		}
+		if err := addJsonFile(files, path.Join(step_dir, "finished.json"), finished); err != nil {
+			return err
+		}
+	}
+
+	optional_files := map[string]string{
+		"FAILURE": build.Failure,
+		"ocp.version": build.OpenShiftVersion,
+		"operator.version": build.OperatorVersion,
+		"ci_artifact.git_version": build.CiArtifactsVersion,
+	}
+	for filename, content := range optional_files {
+		if content != "" {
+			files[path.Join(step_dir, filename)] = []byte(content)
+		}
+	}
+
+	for message_type, messages := range build.Messages {
+		for name, content := range messages {
+			files[path.Join(step_dir, message_type, name)] = []byte(content)
+		}
+	}
+
+	for _, step := range build.Steps {
+		if err := step.addFiles(files, path.Join(step_dir, step.Name)); err != nil {
+			return err
+		}
+	}
+
+	for filename, content := range build.Files {
+		files[path.Join(build_dir, filename)] = []byte(content)
+	}
+
+	return nil
+}
+
+func (step *Step) addFiles(files map[string][]byte, dir string) error {
+	if step.Name == "" {
+		return fmt.Errorf("invalid step without a name")
+	}
+
+	tasks := []interface{}{}
+	for _, failed_task := range step.FailedTasks {
+		host := failed_task.Host
+		if host == "" {
+			host = "localhost"
+		}
+		tasks = append(tasks, map[string]interface{}{
+			"task": map[string]interface{}{"name": failed_task.Name},
+			"hosts": map[string]interface{}{
+				host: map[string]interface{}{
+					"failed": true,
+					"msg": failed_task.Msg,
+					"stderr": failed_task.Stderr,
+				},
+			},
+		})
+	}
+
+	ansible_log := []interface{}{
+		map[string]interface{}{
+			"play": map[string]interface{}{"name": step.Name},
+			"tasks": tasks,
+		},
+		map[string]interface{}{
+			"stats": map[string]interface{}{
+				"localhost": map[string]int{
+					"ok": step.Ok,
+					"failures": step.Failures,
+					"ignored": step.Ignored,
+					"changed": 0,
+					"skipped": 0,
+					"rescued": 0,
+					"unreachable": 0,
+				},
+			},
+		},
+	}
+	if err := addJsonFile(files, path.Join(dir, "_ansible.log.json"), ansible_log); err != nil {
+		return err
+	}
+
+	if step.Flake != "" {
+		files[path.Join(dir, "FLAKE")] = []byte(step.Flake)
+	}
+	if step.ExpectedFail != "" {
+		files[path.Join(dir, "EXPECTED_FAIL")] = []byte(step.ExpectedFail)
+	}
+
+	for filename, content := range step.Files {
+		files[path.Join(dir, filename)] = []byte(content)
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package fakeprow
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Server is a gcsweb-like web server serving the files of a Bucket.
+type Server struct {
+	*httptest.Server
+
+	bucket_name string
+
+	mutex sync.Mutex
+	files map[string][]byte
+	statuses map[string]int
+	requests map[string]int
+}
+
+// NewServer starts a server serving the content of `bucket`. The
+// caller must Close it when done.
+func NewServer(bucket *Bucket) (*Server, error) {
+	files, err := bucket.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		bucket_name: bucket.bucketName(),
+		files: files,
+		statuses: map[string]int{},
+		requests: map[string]int{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+
+	return s, nil
+}
+
+// ArtifactsURL returns the URL of the `logs` directory of the bucket,
+// to be used as `artifacts_url` of the test matrices.
+func (s *Server) ArtifactsURL() string {
+	return fmt.Sprintf("%s/gcs/%s/%s", s.URL, s.bucket_name, LogsDir)
+}
+
+// SetFile creates or replaces a file of the bucket. `file_path` is
+// relative to the root of the bucket.
+func (s *Server) SetFile(file_path string, content []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.files[file_path] = content
+}
+
+// RemoveFile removes a file of the bucket.
+func (s *Server) RemoveFile(file_path string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.files, file_path)
+}
+
+// SetStatus makes the server reply to the requests of `file_path`
+// with the `status_code` error, e.g. to simulate a gcsweb outage. A
+// zero `status_code` serves the file again.
+func (s *Server) SetStatus(file_path string, status_code int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if status_code == 0 {
+		delete(s.statuses, file_path)
+	} else {
+		s.statuses[file_path] = status_code
+	}
+}
+
+// Requests returns the number of requests received for `file_path`,
+// relative to the root of the bucket. The directories end with a "/".
+func (s *Server) Requests(file_path string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.requests[file_path]
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	prefix := fmt.Sprintf("/gcs/%s/", s.bucket_name)
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	file_path := strings.TrimPrefix(r.URL.Path, prefix)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.requests[file_path] += 1
+
+	if status_code, found := s.statuses[file_path]; found {
+		http.Error(w, http.StatusText(status_code), status_code)
+		return
+	}
+
+	if content, found := s.files[file_path]; found {
+		w.Write(content)
+		return
+	}
+
+	// gcsweb serves the missing paths as an "empty dir" page, which is
+	// only recognized by its checksum: reply with a real 404 instead
+	entries := s.listDirectory(strings.TrimSuffix(file_path, "/"))
+	if len(entries) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(s.listingPage(r.URL.Path, entries))
+}
+
+type listingEntry struct {
+	name string
+	is_dir bool
+	size int
+}
+
+// listDirectory returns the entries of the `dir_path` directory, sorted
+// by name as GCS does.
+func (s *Server) listDirectory(dir_path string) []listingEntry {
+	prefix := dir_path + "/"
+	if dir_path == "" {
+		prefix = ""
+	}
+
+	found := map[string]listingEntry{}
+	for file_path, content := range s.files {
+		if !strings.HasPrefix(file_path, prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(file_path, prefix)
+		if slash := strings.Index(name, "/"); slash != -1 {
+			found[name[:slash]] = listingEntry{name: name[:slash], is_dir: true}
+		} else {
+			found[name] = listingEntry{name: name, size: len(content)}
+		}
+	}
+
+	entries := []listingEntry{}
+	for _, entry := range found {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	return entries
+}
+
+func (s *Server) listingPage(url_path string, entries []listingEntry) []byte {
+	dir_url := strings.TrimSuffix(url_path, "/") + "/"
+
+	var page strings.Builder
+	fmt.Fprintf(&page, "<!doctype html>\n<html>\n<head>\n<title>GCS browser: %s</title>\n</head>\n<body>\n",
+		html.EscapeString(s.bucket_name))
+	fmt.Fprintf(&page, "<header><h1>%s</h1></header>\n", html.EscapeString(dir_url))
+	page.WriteString("<ul class=\"resource-grid\">\n")
+	page.WriteString("<li class=\"pure-g grid-row header\"><div class=\"pure-u-2-5\">Name</div>" +
+		"<div class=\"pure-u-1-5\">Size</div><div class=\"pure-u-2-5\">Modified</div></li>\n")
+	fmt.Fprintf(&page, "<li class=\"pure-g grid-row\"><div class=\"pure-u-2-5\"><a href=\"%s../\">" +
+		"<img src=\"/icons/back.png\"> ..</a></div><div class=\"pure-u-1-5\">-</div><div class=\"pure-u-2-5\">-</div></li>\n",
+		html.EscapeString(dir_url))
+
+	for _, entry := range entries {
+		name := entry.name
+		icon := "/icons/file.png"
+		size := fmt.Sprintf("%d", entry.size)
+		if entry.is_dir {
+			name += "/"
+			icon = "/icons/dir.png"
+			size = "-"
+		}
+
+		fmt.Fprintf(&page, "<li class=\"pure-g grid-row\"><div class=\"pure-u-2-5\"><a href=\"%s%s\">" +
+			"<img src=\"%s\"> %s</a></div><div class=\"pure-u-1-5\">%s</div><div class=\"pure-u-2-5\">-</div></li>\n",
+			html.EscapeString(dir_url), html.EscapeString(name), icon, html.EscapeString(name), size)
+	}
+	page.WriteString("</ul>\n</body>\n</html>\n")
+
+	return []byte(page.String())
+}
@@ -0,0 +1,82 @@
+package fakeprow
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func get(t *testing.T, url string) (int, string) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading %s failed: %v", url, err)
+	}
+
+	return resp.StatusCode, string(body)
+}
+
+func TestServer(t *testing.T) {
+	bucket, err := LoadBucket("testdata/bucket.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := NewServer(bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	job_url := server.ArtifactsURL() + "/periodic-ci-org-repo-main-e2e"
+	step_url := job_url + "/1001/artifacts/e2e/test-step/artifacts"
+
+	if status, body := get(t, job_url + "/latest-build.txt"); status != http.StatusOK || body != "1001" {
+		t.Errorf("latest-build.txt: got %d %q", status, body)
+	}
+
+	if _, body := get(t, step_url + "/FAILURE"); body != "step failed" {
+		t.Errorf("FAILURE: got %q", body)
+	}
+
+	if _, body := get(t, step_url + "/_WARNING/nodes"); body != "not enough nodes" {
+		t.Errorf("_WARNING/nodes: got %q", body)
+	}
+
+	_, body := get(t, step_url + "/000__cluster__capture_environment/_ansible.log.json")
+	if !strings.Contains(body, `"failures":1`) {
+		t.Errorf("_ansible.log.json does not contain the step stats: %s", body)
+	}
+
+	_, listing := get(t, job_url + "/")
+	for _, entry := range []string{`<img src="/icons/dir.png"> 1000/`, `<img src="/icons/dir.png"> 1001/`,
+		`<img src="/icons/file.png"> latest-build.txt`} {
+		if !strings.Contains(listing, entry) {
+			t.Errorf("job listing does not contain %q: %s", entry, listing)
+		}
+	}
+
+	if status, _ := get(t, job_url + "/1000/artifacts/e2e/test-step/artifacts/FAILURE"); status != http.StatusNotFound {
+		t.Errorf("missing file: got status %d, expected 404", status)
+	}
+
+	failure_path := "logs/periodic-ci-org-repo-main-e2e/1001/artifacts/e2e/test-step/artifacts/FAILURE"
+	server.SetStatus(failure_path, http.StatusServiceUnavailable)
+	if status, _ := get(t, step_url + "/FAILURE"); status != http.StatusServiceUnavailable {
+		t.Errorf("FAILURE with an error status: got status %d, expected 503", status)
+	}
+	server.SetStatus(failure_path, 0)
+	if status, _ := get(t, step_url + "/FAILURE"); status != http.StatusOK {
+		t.Errorf("FAILURE after resetting its status: got status %d, expected 200", status)
+	}
+
+	if server.Requests("logs/periodic-ci-org-repo-main-e2e/") != 1 {
+		t.Errorf("job listing requests: got %d, expected 1", server.Requests("logs/periodic-ci-org-repo-main-e2e/"))
+	}
+}
@@ -0,0 +1,249 @@
+// Package history stores the results of the builds into a local bbolt
+// database, so that they remain available after leaving the
+// `test_history` window of the matrices.
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+	"github.com/openshift-psap/ci-dashboard/pkg/populate"
+)
+
+// the builds are stored in a bucket per Prow job, nested in this
+// bucket, and indexed by build id
+var buildsBucket = []byte("builds")
+
+// maximum time to wait for another process to release the database
+const OpenTimeout = 10 * time.Second
+
+var log = logrus.New()
+
+func GetLogger() *logrus.Logger {
+	return log
+}
+
+type DB struct {
+	db *bolt.DB
+}
+
+// Open opens the history database at `path`, and creates it if it
+// does not exist.
+func Open(path string) (*DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("error creating the directory of %s: %v", path, err)
+	}
+
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: OpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("error opening the history database %s: %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(buildsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initializing the history database %s: %v", path, err)
+	}
+
+	return &DB{db: db}, nil
+}
+
+// OpenReadOnly opens the existing history database at `path` for
+// reading. It only takes a shared lock on the database, so several
+// readers can use it at the same time.
+func OpenReadOnly(path string) (*DB, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: OpenTimeout, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("error opening the history database %s: %v", path, err)
+	}
+
+	return &DB{db: db}, nil
+}
+
+func (h *DB) Close() error {
+	return h.db.Close()
+}
+
+// IngestStats counts the builds written by an ingestion.
+type IngestStats struct {
+	Added int
+	Updated int
+	// final records already in the database
+	Skipped int
+}
+
+// Ingest stores the builds of all the tests of `matrices_spec`. The
+// final records already in the database are not rewritten, so that
+// each ingestion only adds the new builds, and updates the builds
+// which were running or could not be fetched.
+func (h *DB) Ingest(matrices_spec *v1.MatricesSpec) (IngestStats, error) {
+	stats := IngestStats{}
+	now := time.Now().Unix()
+
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		builds := tx.Bucket(buildsBucket)
+
+		for _, test_matrix := range matrices_spec.Matrices {
+			for _, tests := range test_matrix.Tests {
+				for _, test := range tests {
+					if len(test.OldTests) == 0 {
+						continue
+					}
+
+					job, err := builds.CreateBucketIfNotExists([]byte(test.ProwName))
+					if err != nil {
+						return fmt.Errorf("error creating the bucket of %s: %v", test.ProwName, err)
+					}
+
+					for _, test_result := range test.OldTests {
+						key := []byte(test_result.BuildId)
+						if content := job.Get(key); content != nil {
+							stored := Record{}
+							if err := json.Unmarshal(content, &stored); err == nil && stored.IsFinal() {
+								stats.Skipped += 1
+								continue
+							}
+							stats.Updated += 1
+						} else {
+							stats.Added += 1
+						}
+
+						record := NewRecord(test_result)
+						record.ProwName = test.ProwName
+						record.RecordTime = now
+
+						content, err := json.Marshal(record)
+						if err != nil {
+							return fmt.Errorf("error encoding %s/%s: %v", test.ProwName, test_result.BuildId, err)
+						}
+						if err = job.Put(key, content); err != nil {
+							return fmt.Errorf("error storing %s/%s: %v", test.ProwName, test_result.BuildId, err)
+						}
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return IngestStats{}, err
+	}
+
+	return stats, nil
+}
+
+// ProwNames returns the names of the Prow jobs with stored builds,
+// sorted.
+func (h *DB) ProwNames() ([]string, error) {
+	names := []string{}
+	err := h.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(buildsBucket).ForEach(func(name, value []byte) error {
+			if value == nil {
+				// nested bucket
+				names = append(names, string(name))
+			}
+			return nil
+		})
+	})
+
+	return names, err
+}
+
+// Records returns the stored builds of `filter.ProwName` (or of all
+// the Prow jobs if empty) matching `filter`, newest first.
+func (h *DB) Records(filter Filter) ([]*Record, error) {
+	prow_names := []string{filter.ProwName}
+	if filter.ProwName == "" {
+		var err error
+		if prow_names, err = h.ProwNames(); err != nil {
+			return nil, err
+		}
+	}
+
+	records := []*Record{}
+	err := h.db.View(func(tx *bolt.Tx) error {
+		for _, prow_name := range prow_names {
+			job := tx.Bucket(buildsBucket).Bucket([]byte(prow_name))
+			if job == nil {
+				continue
+			}
+
+			err := job.ForEach(func(build_id, content []byte) error {
+				record := &Record{}
+				if err := json.Unmarshal(content, record); err != nil {
+					log.Warningf("Ignoring the invalid record %s/%s: %v", prow_name, build_id, err)
+					return nil
+				}
+				if filter.Match(record) {
+					records = append(records, record)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading the history: %v", err)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].FinishTime != records[j].FinishTime {
+			return records[i].FinishTime > records[j].FinishTime
+		}
+		return records[i].BuildId > records[j].BuildId
+	})
+
+	return records, nil
+}
+
+// TestResults returns the stored builds of `filter.ProwName` matching
+// `filter` as test results, newest first.
+func (h *DB) TestResults(filter Filter) ([]*v1.TestResult, error) {
+	records, err := h.Records(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	test_results := []*v1.TestResult{}
+	for _, record := range records {
+		test_results = append(test_results, record.TestResult())
+	}
+
+	return test_results, nil
+}
+
+// PopulateHistoryStats computes the statistics of the stored builds of
+// each test of `matrices_spec`, finished after `since`.
+func (h *DB) PopulateHistoryStats(matrices_spec *v1.MatricesSpec, since time.Time) error {
+	matrices_spec.HistorySince = v1.NewTimestamp(since)
+
+	for _, test_matrix := range matrices_spec.Matrices {
+		for _, tests := range test_matrix.Tests {
+			for test_idx := range tests {
+				test := &tests[test_idx]
+
+				test_results, err := h.TestResults(Filter{ProwName: test.ProwName, Since: since})
+				if err != nil {
+					return err
+				}
+				test.HistoryStats = populate.ComputeTestStats(test_results)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+func testMatrices(builds ...*v1.TestResult) *v1.MatricesSpec {
+	test := v1.TestSpec{ProwName: "periodic-e2e", OldTests: builds}
+	for _, build := range builds {
+		build.TestSpec = &test
+	}
+
+	return &v1.MatricesSpec{Matrices: map[string]v1.MatrixSpec{
+		"nightly": {Tests: map[string][]v1.TestSpec{"main": {test}}},
+	}}
+}
+
+func testBuild(build_id string, day int, passed bool, operator_version, openshift_version string) *v1.TestResult {
+	result := "FAILURE"
+	if passed {
+		result = "SUCCESS"
+	}
+	return &v1.TestResult{
+		BuildId: build_id,
+		Passed: passed,
+		Result: result,
+		FinishTime: v1.NewTimestamp(time.Date(2024, 9, day, 12, 0, 0, 0, time.UTC)),
+		OperatorVersion: operator_version,
+		OpenShiftVersion: openshift_version,
+		Messages: map[v1.TestMessageType]map[string]string{},
+	}
+}
+
+func TestIngest(t *testing.T) {
+	tmp_dir, err := ioutil.TempDir("", "ci-dashboard-history-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp_dir)
+
+	history_db, err := Open(filepath.Join(tmp_dir, "history", "builds.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history_db.Close()
+
+	failed := testBuild("1", 1, false, "24.6.1", "4.17.3")
+	failed.Messages[v1.TestMessageTypeFlake] = map[string]string{"install": "known flake"}
+	failed.Classification = &v1.FailureClassification{Rule: "cluster-login", Category: v1.FailureCategoryInfraFlake}
+	failed.ToolboxStepsResults = []v1.ToolboxStepResult{{Name: "000__cluster", Ok: 3, Failures: 1,
+		FailedTasks: []v1.TaskFailure{{Role: "cluster", Task: "Get the nodes", Host: "localhost", Msg: "failed"}}}}
+	running := &v1.TestResult{BuildId: "3", Result: "N/A"}
+
+	ingested, err := history_db.Ingest(testMatrices(running, testBuild("2", 2, true, "24.6.2", "4.17.3"), failed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ingested != (IngestStats{Added: 3}) {
+		t.Errorf("unexpected first ingestion: %+v", ingested)
+	}
+
+	// the running build finished, and a new one started
+	ingested, err = history_db.Ingest(testMatrices(testBuild("4", 4, true, "24.3.0", "4.17.10"),
+		testBuild("3", 3, false, "24.6.2", "4.16.0"), testBuild("2", 2, false, "", "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ingested != (IngestStats{Added: 1, Updated: 1, Skipped: 1}) {
+		t.Errorf("unexpected second ingestion: %+v", ingested)
+	}
+
+	records, err := history_db.Records(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	build_ids := []string{}
+	for _, record := range records {
+		build_ids = append(build_ids, record.BuildId)
+	}
+	if len(build_ids) != 4 || build_ids[0] != "4" || build_ids[3] != "1" {
+		t.Fatalf("got builds %v, expected newest first [4 3 2 1]", build_ids)
+	}
+	if !records[2].Passed {
+		t.Errorf("the final record of build 2 was overwritten")
+	}
+
+	records, err = history_db.Records(Filter{ProwName: "periodic-e2e", OperatorVersion: "24.6", OpenShiftVersion: "4.17"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].BuildId != "2" || records[1].BuildId != "1" {
+		t.Errorf("unexpected records of 24.6 on 4.17: %+v", records)
+	}
+
+	since := time.Date(2024, 9, 2, 0, 0, 0, 0, time.UTC)
+	if records, err = history_db.Records(Filter{Since: since}); err != nil || len(records) != 3 {
+		t.Errorf("got %d records since %s, expected 3 (%v)", len(records), since, err)
+	}
+
+	if names, err := history_db.ProwNames(); err != nil || len(names) != 1 || names[0] != "periodic-e2e" {
+		t.Errorf("unexpected Prow names: %v (%v)", names, err)
+	}
+
+	restored, err := history_db.TestResults(Filter{OperatorVersion: "24.6.1"})
+	if err != nil || len(restored) != 1 {
+		t.Fatalf("could not restore build 1: %v", err)
+	}
+	build := restored[0]
+	if build.Passed || !build.FinishTime.Time.Equal(failed.FinishTime.Time) ||
+		build.Messages[v1.TestMessageTypeFlake]["install"] != "known flake" ||
+		build.Classification == nil || build.Classification.Category != v1.FailureCategoryInfraFlake ||
+		len(build.ToolboxStepsResults) != 1 || build.ToolboxStepsResults[0].FailedTasks[0].Task != "Get the nodes" {
+		t.Errorf("build 1 was not restored correctly: %+v", build)
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	tmp_dir, err := ioutil.TempDir("", "ci-dashboard-history-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp_dir)
+
+	db_path := filepath.Join(tmp_dir, "history", "builds.db")
+	if _, err = OpenReadOnly(db_path); err == nil {
+		t.Errorf("OpenReadOnly of a missing database succeeded")
+	}
+	if _, err = os.Stat(filepath.Dir(db_path)); !os.IsNotExist(err) {
+		t.Errorf("OpenReadOnly created the directory of the missing database")
+	}
+
+	history_db, err := Open(db_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = history_db.Ingest(testMatrices(testBuild("1", 1, true, "24.6.1", "4.17.3"))); err != nil {
+		t.Fatal(err)
+	}
+	history_db.Close()
+
+	// the readers do not lock each other out
+	readers := []*DB{}
+	for i := 0; i < 2; i++ {
+		reader, err := OpenReadOnly(db_path)
+		if err != nil {
+			t.Fatalf("OpenReadOnly failed: %v", err)
+		}
+		defer reader.Close()
+		readers = append(readers, reader)
+	}
+
+	for _, reader := range readers {
+		if records, err := reader.Records(Filter{}); err != nil || len(records) != 1 {
+			t.Errorf("got %d records from the read-only database, expected 1 (%v)", len(records), err)
+		}
+	}
+	if _, err = readers[0].Ingest(testMatrices(testBuild("2", 2, true, "24.6.1", "4.17.3"))); err == nil {
+		t.Errorf("Ingest into the read-only database succeeded")
+	}
+}
+
+func TestPopulateHistoryStats(t *testing.T) {
+	tmp_dir, err := ioutil.TempDir("", "ci-dashboard-history-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp_dir)
+
+	history_db, err := Open(filepath.Join(tmp_dir, "builds.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history_db.Close()
+
+	flake := testBuild("2", 2, false, "24.6.1", "4.17.3")
+	flake.FlakeFailure = true
+	_, err = history_db.Ingest(testMatrices(testBuild("4", 4, false, "24.6.1", "4.17.3"),
+		testBuild("3", 3, true, "24.6.1", "4.17.3"), flake, testBuild("1", 1, true, "24.6.1", "4.17.3")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the history of the matrix is shorter than the stored one
+	matrices_spec := testMatrices(testBuild("4", 4, false, "24.6.1", "4.17.3"))
+	since := time.Date(2024, 9, 2, 0, 0, 0, 0, time.UTC)
+	if err = history_db.PopulateHistoryStats(matrices_spec, since); err != nil {
+		t.Fatalf("PopulateHistoryStats failed: %v", err)
+	}
+
+	if !matrices_spec.HistorySince.Valid || !matrices_spec.HistorySince.Time.Equal(since) {
+		t.Errorf("got the history window %v, expected %v", matrices_spec.HistorySince, since)
+	}
+	stats := matrices_spec.Matrices["nightly"].Tests["main"][0].HistoryStats
+	if stats == nil || stats.Builds != 3 || stats.Passed != 1 || stats.Failed != 1 || stats.Flakes != 1 {
+		t.Errorf("unexpected statistics of the builds since %v: %+v", since, stats)
+	}
+}
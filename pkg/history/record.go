@@ -0,0 +1,235 @@
+package history
+
+import (
+	"time"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+// StepRecord is the result of a toolbox step of a build.
+type StepRecord struct {
+	Name string `json:"name"`
+	Ok int `json:"ok"`
+	Failures int `json:"failures"`
+	Ignored int `json:"ignored"`
+	ExpectedFailure string `json:"expected_failure,omitempty"`
+	FlakeFailure string `json:"flake_failure,omitempty"`
+	ParseError string `json:"parse_error,omitempty"`
+	FailedTasks []v1.TaskFailure `json:"failed_tasks,omitempty"`
+}
+
+// Record is the stored form of a v1.TestResult. The times are Unix
+// timestamps and the durations are in seconds, 0 when unknown.
+type Record struct {
+	ProwName string `json:"prow_name"`
+	BuildId string `json:"build_id"`
+	// time when the record was stored
+	RecordTime int64 `json:"record_time"`
+
+	Passed bool `json:"passed"`
+	Result string `json:"result"`
+	FetchError string `json:"fetch_error,omitempty"`
+
+	CreationTime int64 `json:"creation_time,omitempty"`
+	StartTime int64 `json:"start_time,omitempty"`
+	FinishTime int64 `json:"finish_time,omitempty"`
+	Duration int64 `json:"duration,omitempty"`
+	QueueTime int64 `json:"queue_time,omitempty"`
+	Timeout int64 `json:"timeout,omitempty"`
+
+	StepExecuted bool `json:"step_executed"`
+	StepPassed bool `json:"step_passed"`
+	StepResult string `json:"step_result,omitempty"`
+	StepFailure string `json:"step_failure,omitempty"`
+
+	OperatorVersion string `json:"operator_version,omitempty"`
+	OpenShiftVersion string `json:"openshift_version,omitempty"`
+	CiArtifactsVersion string `json:"ci_artifacts_version,omitempty"`
+
+	// indexed by message type (_INFO, _WARNING, ...), then by name
+	Messages map[string]map[string]string `json:"messages,omitempty"`
+
+	Steps []StepRecord `json:"steps,omitempty"`
+	Ok int `json:"ok"`
+	Failures int `json:"failures"`
+	Ignored int `json:"ignored"`
+	FlakeFailure bool `json:"flake_failure,omitempty"`
+
+	// category and rule of the failure classification
+	FailureCategory string `json:"failure_category,omitempty"`
+	FailureRule string `json:"failure_rule,omitempty"`
+	FailureSignature string `json:"failure_signature,omitempty"`
+}
+
+var messageTypes = []v1.TestMessageType{
+	v1.TestMessageTypeInfo,
+	v1.TestMessageTypeWarning,
+	v1.TestMessageTypeError,
+	v1.TestMessageTypeFlake,
+}
+
+func unixTime(t v1.Timestamp) int64 {
+	if !t.Valid {
+		return 0
+	}
+	return t.Time.Unix()
+}
+
+func timestamp(unix int64) v1.Timestamp {
+	if unix == 0 {
+		return v1.Timestamp{}
+	}
+	return v1.NewTimestamp(time.Unix(unix, 0))
+}
+
+func NewRecord(test_result *v1.TestResult) *Record {
+	record := &Record{
+		BuildId: test_result.BuildId,
+		Passed: test_result.Passed,
+		Result: test_result.Result,
+		FetchError: test_result.FetchError,
+
+		CreationTime: unixTime(test_result.CreationTime),
+		StartTime: unixTime(test_result.StartTime),
+		FinishTime: unixTime(test_result.FinishTime),
+		Duration: int64(test_result.Duration.Seconds()),
+		QueueTime: int64(test_result.QueueTime.Seconds()),
+		Timeout: int64(test_result.Timeout.Seconds()),
+
+		StepExecuted: test_result.StepExecuted,
+		StepPassed: test_result.StepPassed,
+		StepResult: test_result.StepResult,
+		StepFailure: test_result.StepFailure,
+
+		OperatorVersion: test_result.OperatorVersion,
+		OpenShiftVersion: test_result.OpenShiftVersion,
+		CiArtifactsVersion: test_result.CiArtifactsVersion,
+
+		Ok: test_result.Ok,
+		Failures: test_result.Failures,
+		Ignored: test_result.Ignored,
+		FlakeFailure: test_result.FlakeFailure,
+		FailureSignature: test_result.FailureSignature,
+	}
+	if test_result.TestSpec != nil {
+		record.ProwName = test_result.TestSpec.ProwName
+	}
+	if test_result.Classification != nil {
+		record.FailureCategory = test_result.Classification.Category
+		record.FailureRule = test_result.Classification.Rule
+	}
+
+	for message_type, messages := range test_result.Messages {
+		if len(messages) == 0 {
+			continue
+		}
+		if record.Messages == nil {
+			record.Messages = map[string]map[string]string{}
+		}
+		record.Messages[message_type.String()] = messages
+	}
+
+	for _, step := range test_result.ToolboxStepsResults {
+		record.Steps = append(record.Steps, StepRecord{
+			Name: step.Name,
+			Ok: step.Ok,
+			Failures: step.Failures,
+			Ignored: step.Ignored,
+			ExpectedFailure: step.ExpectedFailure,
+			FlakeFailure: step.FlakeFailure,
+			ParseError: step.ParseError,
+			FailedTasks: step.FailedTasks,
+		})
+	}
+
+	return record
+}
+
+// IsFinal tells if the record will not change anymore: the build was
+// finished, and all its artifacts could be fetched.
+func (r *Record) IsFinal() bool {
+	return r.Result != "" && r.Result != "N/A" && r.FetchError == ""
+}
+
+// TestResult converts the record back into a test result, without
+// its TestSpec.
+func (r *Record) TestResult() *v1.TestResult {
+	test_result := &v1.TestResult{
+		BuildId: r.BuildId,
+		Passed: r.Passed,
+		Result: r.Result,
+		FetchError: r.FetchError,
+
+		CreationTime: timestamp(r.CreationTime),
+		StartTime: timestamp(r.StartTime),
+		FinishTime: timestamp(r.FinishTime),
+		Duration: time.Duration(r.Duration) * time.Second,
+		QueueTime: time.Duration(r.QueueTime) * time.Second,
+		Timeout: time.Duration(r.Timeout) * time.Second,
+
+		StepExecuted: r.StepExecuted,
+		StepPassed: r.StepPassed,
+		StepResult: r.StepResult,
+		StepFailure: r.StepFailure,
+
+		OperatorVersion: r.OperatorVersion,
+		OpenShiftVersion: r.OpenShiftVersion,
+		CiArtifactsVersion: r.CiArtifactsVersion,
+
+		Messages: map[v1.TestMessageType]map[string]string{},
+
+		Ok: r.Ok,
+		Failures: r.Failures,
+		Ignored: r.Ignored,
+		FlakeFailure: r.FlakeFailure,
+		FailureSignature: r.FailureSignature,
+	}
+	if r.FailureCategory != "" {
+		test_result.Classification = &v1.FailureClassification{
+			Rule: r.FailureRule,
+			Category: r.FailureCategory,
+		}
+	}
+
+	for _, message_type := range messageTypes {
+		if messages, found := r.Messages[message_type.String()]; found {
+			test_result.Messages[message_type] = messages
+		}
+	}
+
+	for _, step := range r.Steps {
+		test_result.ToolboxSteps = append(test_result.ToolboxSteps, step.Name)
+		test_result.ToolboxStepsResults = append(test_result.ToolboxStepsResults, v1.ToolboxStepResult{
+			Name: step.Name,
+			Ok: step.Ok,
+			Failures: step.Failures,
+			Ignored: step.Ignored,
+			ExpectedFailure: step.ExpectedFailure,
+			FlakeFailure: step.FlakeFailure,
+			ParseError: step.ParseError,
+			FailedTasks: step.FailedTasks,
+		})
+	}
+
+	return test_result
+}
+
+// Filter selects the records of a query. The empty fields match all
+// the records.
+type Filter struct {
+	ProwName string
+	// builds finished after this time
+	Since time.Time
+	OperatorVersion string
+	OpenShiftVersion string
+}
+
+func (f *Filter) Match(record *Record) bool {
+	if f.ProwName != "" && record.ProwName != f.ProwName {
+		return false
+	} else if !f.Since.IsZero() && (record.FinishTime == 0 || time.Unix(record.FinishTime, 0).Before(f.Since)) {
+		return false
+	}
+	return v1.MatchVersion(record.OperatorVersion, f.OperatorVersion) &&
+		v1.MatchVersion(record.OpenShiftVersion, f.OpenShiftVersion)
+}
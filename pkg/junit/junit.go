@@ -0,0 +1,118 @@
+// Package junit parses the JUnit XML reports generated by the test
+// suites (Ginkgo, pytest, ...).
+package junit
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusPassed Status  = "passed"
+	StatusFailed Status  = "failed"
+	StatusSkipped Status = "skipped"
+)
+
+// Suite is a <testsuite> element. The <testsuites> root element is
+// parsed as a Suite without testcases.
+type Suite struct {
+	XMLName xml.Name
+	Name string `xml:"name,attr"`
+	Time string `xml:"time,attr"`
+
+	Suites []Suite `xml:"testsuite"`
+	TestCases []TestCase `xml:"testcase"`
+}
+
+type TestCase struct {
+	Name string `xml:"name,attr"`
+	ClassName string `xml:"classname,attr"`
+	Time string `xml:"time,attr"`
+
+	Failure *Result `xml:"failure"`
+	Error *Result `xml:"error"`
+	Skipped *Result `xml:"skipped"`
+
+	// name of the suite containing the testcase
+	Suite string `xml:"-"`
+}
+
+// Result is the content of the <failure>, <error> and <skipped>
+// elements.
+type Result struct {
+	Message string `xml:"message,attr"`
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+// Parse parses a JUnit report, with either a <testsuites> or a
+// <testsuite> root element.
+func Parse(content []byte) (*Suite, error) {
+	suite := &Suite{}
+	if err := xml.Unmarshal(content, suite); err != nil {
+		return nil, fmt.Errorf("error parsing the JUnit XML: %v", err)
+	}
+
+	if suite.XMLName.Local != "testsuites" && suite.XMLName.Local != "testsuite" {
+		return nil, fmt.Errorf("unexpected JUnit root element <%s>", suite.XMLName.Local)
+	}
+
+	return suite, nil
+}
+
+// AllTestCases returns the testcases of the suite and of its nested
+// suites, in document order.
+func (s *Suite) AllTestCases() []TestCase {
+	testcases := []TestCase{}
+	for _, testcase := range s.TestCases {
+		testcase.Suite = s.Name
+		testcases = append(testcases, testcase)
+	}
+	for i := range s.Suites {
+		testcases = append(testcases, s.Suites[i].AllTestCases()...)
+	}
+
+	return testcases
+}
+
+func (tc *TestCase) Status() Status {
+	if tc.Failure != nil || tc.Error != nil {
+		return StatusFailed
+	} else if tc.Skipped != nil {
+		return StatusSkipped
+	}
+	return StatusPassed
+}
+
+// Duration returns the duration of the testcase, or 0 if it is not
+// specified or invalid.
+func (tc *TestCase) Duration() time.Duration {
+	// some generators use a thousands separator
+	seconds, err := strconv.ParseFloat(strings.ReplaceAll(tc.Time, ",", ""), 64)
+	if err != nil {
+		return 0
+	}
+
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// Message returns the message of the failure, error or skip of the
+// testcase, or its text if it has no message attribute.
+func (tc *TestCase) Message() string {
+	for _, result := range []*Result{tc.Failure, tc.Error, tc.Skipped} {
+		if result == nil {
+			continue
+		}
+		if result.Message != "" {
+			return result.Message
+		}
+		return strings.TrimSpace(result.Text)
+	}
+
+	return ""
+}
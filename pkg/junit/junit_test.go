@@ -0,0 +1,67 @@
+package junit
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+type expectedTestCase struct {
+	suite string
+	name string
+	status Status
+	duration time.Duration
+	message string
+}
+
+func checkTestCases(t *testing.T, filename string, expected []expectedTestCase) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suite, err := Parse(content)
+	if err != nil {
+		t.Fatalf("%s: Parse failed: %v", filename, err)
+	}
+
+	testcases := suite.AllTestCases()
+	if len(testcases) != len(expected) {
+		t.Fatalf("%s: got %d testcases, expected %d", filename, len(testcases), len(expected))
+	}
+
+	for i, testcase := range testcases {
+		if testcase.Suite != expected[i].suite || testcase.Name != expected[i].name ||
+			testcase.Status() != expected[i].status || testcase.Duration() != expected[i].duration ||
+			testcase.Message() != expected[i].message {
+			t.Errorf("%s: testcase %d: got %q/%q %s %v %q, expected %v", filename, i,
+				testcase.Suite, testcase.Name, testcase.Status(), testcase.Duration(), testcase.Message(), expected[i])
+		}
+	}
+}
+
+func TestParseGinkgo(t *testing.T) {
+	checkTestCases(t, "testdata/junit_ginkgo.xml", []expectedTestCase{
+		{"GPU Operator e2e", "[BeforeSuite]", StatusPassed, 12250 * time.Millisecond, ""},
+		{"GPU Operator e2e", "GPU Operator deploys the ClusterPolicy", StatusPassed, 1100500 * time.Millisecond, ""},
+		{"GPU Operator e2e", "GPU Operator runs gpu-burn", StatusFailed, 120 * time.Second,
+			"Timed out after 600s.\nExpected pod to be Succeeded"},
+		{"GPU Operator e2e", "GPU Operator upgrades", StatusSkipped, 0, "skipped - upgrade not requested"},
+	})
+}
+
+func TestParsePytest(t *testing.T) {
+	checkTestCases(t, "testdata/junit_pytest.xml", []expectedTestCase{
+		{"pytest", "test_labels", StatusPassed, 1500 * time.Millisecond, ""},
+		{"pytest", "test_workers", StatusFailed, 1600 * time.Millisecond,
+			"Traceback (most recent call last):\nConnectionError: cluster unreachable"},
+	})
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, content := range []string{"", "not xml", "<html><body>empty dir</body></html>"} {
+		if _, err := Parse([]byte(content)); err == nil {
+			t.Errorf("Parse(%q) did not fail", content)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package populate
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+	"github.com/openshift-psap/ci-dashboard/pkg/artifacts"
+)
+
+// compiled patterns of the failure rules, indexed by pattern
+var failurePatterns sync.Map
+
+func failurePattern(pattern string) (*regexp.Regexp, error) {
+	if re, found := failurePatterns.Load(pattern); found {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	failurePatterns.Store(pattern, re)
+
+	return re, nil
+}
+
+func ruleHasSource(rule *v1.FailureRule, source string) bool {
+	if len(rule.Sources) == 0 {
+		return true
+	}
+	for _, rule_source := range rule.Sources {
+		if rule_source == source {
+			return true
+		}
+	}
+	return false
+}
+
+// failureTexts returns the failure details of `test_result` indexed by
+// source. The build log is only fetched if a rule needs it.
+func failureTexts(test_result *v1.TestResult, rules []v1.FailureRule) map[string]string {
+	texts := map[string]string{
+		v1.FailureSourceFailure: test_result.StepFailure,
+	}
+
+	ansible_failures := []string{}
+	for _, step := range test_result.ToolboxStepsResults {
+		for _, failure := range step.FailedTasks {
+			ansible_failures = append(ansible_failures, failure.Task + ": " + failure.Msg + "\n" + failure.Stderr)
+		}
+	}
+	texts[v1.FailureSourceAnsible] = strings.Join(ansible_failures, "\n")
+
+	junit_failures := []string{}
+	for _, testcase := range test_result.JUnitTestCases {
+		if testcase.Status == "failed" {
+			junit_failures = append(junit_failures, testcase.Name + ": " + testcase.Message)
+		}
+	}
+	texts[v1.FailureSourceJUnit] = strings.Join(junit_failures, "\n")
+
+	for idx := range rules {
+		if !ruleHasSource(&rules[idx], v1.FailureSourceBuildLog) {
+			continue
+		}
+
+		build_log, err := artifacts.FetchTestBuildResult(test_result.TestSpec.Matrix, test_result.TestSpec.ProwName,
+			test_result.BuildId, "build-log.txt", artifacts.TypeBytes)
+		if err == artifacts.MissingPageError {
+			log.Debugf("No build log for the test %s/%s", test_result.TestSpec.ProwName, test_result.BuildId)
+		} else if err != nil {
+			log.Warningf("Failed to fetch the build log of the test %s/%s: %v",
+				test_result.TestSpec.ProwName, test_result.BuildId, err)
+		} else {
+			texts[v1.FailureSourceBuildLog] = string(build_log.Bytes)
+		}
+		break
+	}
+
+	return texts
+}
+
+// ClassifyTestFailure applies the failure rules of the matrix to the
+// failed `test_result`. The first rule matching is recorded.
+func ClassifyTestFailure(test_result *v1.TestResult) {
+	test_result.Classification = nil
+	if test_result.Passed || test_result.TestSpec == nil || test_result.TestSpec.Matrix == nil {
+		return
+	}
+
+	rules := test_result.TestSpec.Matrix.FailureRules
+	if len(rules) == 0 {
+		return
+	}
+
+	texts := failureTexts(test_result, rules)
+	sources := []string{v1.FailureSourceFailure, v1.FailureSourceAnsible, v1.FailureSourceJUnit, v1.FailureSourceBuildLog}
+
+	for idx := range rules {
+		rule := &rules[idx]
+		re, err := failurePattern(rule.Pattern)
+		if err != nil {
+			log.Warningf("Invalid pattern of the failure rule %s: %v", rule.Name, err)
+			continue
+		}
+
+		for _, source := range sources {
+			if !ruleHasSource(rule, source) {
+				continue
+			}
+			text := texts[source]
+			loc := re.FindStringIndex(text)
+			if loc == nil {
+				continue
+			}
+			match := text[loc[0]:loc[1]]
+
+			log.Debugf("Test %s/%s classified as %s by the rule %s",
+				test_result.TestSpec.ProwName, test_result.BuildId, rule.Category, rule.Name)
+			test_result.Classification = &v1.FailureClassification{
+				Rule: rule.Name,
+				Category: rule.Category,
+				Ticket: rule.Ticket,
+				Description: rule.Description,
+				Source: source,
+				Match: truncateMessage(match),
+			}
+			return
+		}
+	}
+}
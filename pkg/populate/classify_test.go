@@ -0,0 +1,60 @@
+package populate
+
+import (
+	"testing"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+func TestClassifyTestFailure(t *testing.T) {
+	matrix := &v1.MatrixSpec{
+		Name: "test",
+		FailureRules: []v1.FailureRule{
+			{Name: "junit-timeout", Category: v1.FailureCategoryTestBug, Pattern: `[Tt]imed out after \d+s`,
+				Sources: []string{v1.FailureSourceJUnit}},
+			{Name: "image-pull", Category: v1.FailureCategoryInfraFlake, Pattern: `ErrImagePull|ImagePullBackOff`,
+				Ticket: "https://issues.example.com/CI-1"},
+			{Name: "driver-crash", Category: v1.FailureCategoryProductBug, Pattern: `nvidia-driver.*CrashLoopBackOff`},
+		},
+	}
+	test_spec := &v1.TestSpec{ProwName: "job", Matrix: matrix}
+
+	for _, tc := range []struct {
+		name string
+		result v1.TestResult
+		rule string
+		source string
+		match string
+	}{
+		{"passed", v1.TestResult{Passed: true, StepFailure: "ErrImagePull"}, "", "", ""},
+		{"no match", v1.TestResult{StepFailure: "unknown error"}, "", "", ""},
+		{"failure file", v1.TestResult{StepFailure: "pod/gpu-burn: ImagePullBackOff"},
+			"image-pull", v1.FailureSourceFailure, "ImagePullBackOff"},
+		{"ansible", v1.TestResult{ToolboxStepsResults: []v1.ToolboxStepResult{{
+			FailedTasks: []v1.TaskFailure{{Task: "Wait for the driver", Msg: "pod nvidia-driver-daemonset-x7z is in CrashLoopBackOff"}},
+		}}}, "driver-crash", v1.FailureSourceAnsible, "nvidia-driver-daemonset-x7z is in CrashLoopBackOff"},
+		{"source restricted", v1.TestResult{StepFailure: "Timed out after 600s"}, "", "", ""},
+		{"rules order", v1.TestResult{
+			StepFailure: "ErrImagePull",
+			JUnitTestCases: []v1.JUnitTestCase{{Name: "deploys", Status: "failed", Message: "Timed out after 600s"}},
+		}, "junit-timeout", v1.FailureSourceJUnit, "Timed out after 600s"},
+	} {
+		test_result := tc.result
+		test_result.TestSpec = test_spec
+		ClassifyTestFailure(&test_result)
+
+		if tc.rule == "" {
+			if test_result.Classification != nil {
+				t.Errorf("%s: unexpected classification %+v", tc.name, test_result.Classification)
+			}
+			continue
+		}
+
+		classification := test_result.Classification
+		if classification == nil || classification.Rule != tc.rule || classification.Source != tc.source ||
+			classification.Match != tc.match {
+			t.Errorf("%s: got classification %+v, expected rule %s, source %s, match %q",
+				tc.name, classification, tc.rule, tc.source, tc.match)
+		}
+	}
+}
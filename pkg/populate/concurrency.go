@@ -0,0 +1,32 @@
+package populate
+
+import (
+	"sync"
+)
+
+const DefaultConcurrency = 4
+
+// runConcurrently calls `job` with every index in [0, nb_jobs), with
+// at most `concurrency` jobs running at the same time, and returns
+// when all of them have completed.
+func runConcurrently(concurrency, nb_jobs int, job func(idx int)) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, concurrency)
+
+	for idx := 0; idx < nb_jobs; idx++ {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(idx int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			job(idx)
+		}(idx)
+	}
+
+	wg.Wait()
+}
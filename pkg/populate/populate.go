@@ -2,11 +2,15 @@ package populate
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/openshift-psap/ci-dashboard/pkg/ansible"
 	"github.com/openshift-psap/ci-dashboard/pkg/artifacts"
+	"github.com/openshift-psap/ci-dashboard/pkg/junit"
+	"github.com/openshift-psap/ci-dashboard/pkg/signature"
 
 	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
 )
@@ -24,13 +28,60 @@ func PopulateTestFromFinished(test *v1.TestResult, test_finished artifacts.Artif
 	}
 	if test_finished.Json["timestamp"] != nil {
 		ts := test_finished.Json["timestamp"].(float64)
-		test.FinishDate = time.Unix(int64(ts), 0).Format("2006-01-02 15:04")
-	} else {
-		test.FinishDate = "N/A"
+		test.FinishTime = v1.NewTimestamp(time.Unix(int64(ts), 0))
+	}
+	computeTestDurations(test)
+
+	return nil
+}
+
+func PopulateTestFromStarted(test *v1.TestResult, test_started artifacts.ArtifactResult) error {
+	if ts, ok := test_started.Json["timestamp"].(float64); ok {
+		test.StartTime = v1.NewTimestamp(time.Unix(int64(ts), 0))
+	}
+	computeTestDurations(test)
+
+	return nil
+}
+
+// PopulateTestFromProwJob reads the creation time and the timeout of
+// the build from its Prow job definition.
+func PopulateTestFromProwJob(test *v1.TestResult, prowjob artifacts.ArtifactResult) error {
+	if metadata, ok := prowjob.Json["metadata"].(map[string]interface{}); ok {
+		if creation, ok := metadata["creationTimestamp"].(string); ok {
+			creation_time, err := time.Parse(time.RFC3339, creation)
+			if err != nil {
+				return fmt.Errorf("invalid creationTimestamp '%s': %v", creation, err)
+			}
+			test.CreationTime = v1.NewTimestamp(creation_time)
+		}
+	}
+
+	if spec, ok := prowjob.Json["spec"].(map[string]interface{}); ok {
+		if decoration_config, ok := spec["decoration_config"].(map[string]interface{}); ok {
+			if timeout, ok := decoration_config["timeout"].(string); ok {
+				duration, err := time.ParseDuration(timeout)
+				if err != nil {
+					return fmt.Errorf("invalid timeout '%s': %v", timeout, err)
+				}
+				test.Timeout = duration
+			}
+		}
 	}
+	computeTestDurations(test)
+
 	return nil
 }
 
+func computeTestDurations(test *v1.TestResult) {
+	if duration := test.FinishTime.Sub(test.StartTime); duration >= 0 {
+		test.Duration = duration
+	}
+	if queue_time := test.StartTime.Sub(test.CreationTime); queue_time >= 0 {
+		test.QueueTime = queue_time
+	}
+}
+
 func PopulateTestFromStepFinished(test_result *v1.TestResult, step_test_finished artifacts.ArtifactResult) error {
 	if step_test_finished.Json["passed"] != nil {
 		test_result.StepPassed = step_test_finished.Json["passed"].(bool)
@@ -53,7 +104,7 @@ func PopulateTestMessages(test_result *v1.TestResult) error {
 		messages, err := artifacts.FetchTestMessages(message_type.String(), test_result)
 		if err != nil {
 			if err != artifacts.MissingPageError {
-				log.Warningf("Failed to get the '%s' messages of the test %s/%s: %v",
+				fetchFailed(test_result, err, "Failed to get the '%s' messages of the test %s/%s: %v",
 					message_type.String(), test_result.TestSpec.ProwName, test_result.BuildId, err)
 			}
 			continue
@@ -71,27 +122,92 @@ func PopulateTestMessages(test_result *v1.TestResult) error {
 	return nil
 }
 
-func PopulateTestFromToolboxLogs(test_result *v1.TestResult, toolbox_logs map[string]artifacts.JsonArray) error {
+// PopulateTestJUnit parses the JUnit files of the test step artifacts
+// into the testcases of `test_result`. The files that cannot be parsed
+// are ignored.
+func PopulateTestJUnit(test_result *v1.TestResult) error {
+	junit_files, err := artifacts.FetchTestJUnitFiles(test_result)
+	if err != nil {
+		return err
+	}
+
+	test_result.JUnitTestCases = []v1.JUnitTestCase{}
+	for _, junit_file := range sortedFileNames(junit_files) {
+		suite, err := junit.Parse(junit_files[junit_file])
+		if err != nil {
+			log.Warningf("Failed to parse the JUnit file %s of the test %s/%s: %v",
+				junit_file, test_result.TestSpec.ProwName, test_result.BuildId, err)
+			continue
+		}
+
+		for _, testcase := range suite.AllTestCases() {
+			test_result.JUnitTestCases = append(test_result.JUnitTestCases, v1.JUnitTestCase{
+				File: junit_file,
+				Suite: testcase.Suite,
+				ClassName: testcase.ClassName,
+				Name: testcase.Name,
+				Status: string(testcase.Status()),
+				Duration: testcase.Duration(),
+				Message: truncateMessage(testcase.Message()),
+			})
+		}
+	}
+
+	return nil
+}
+
+// MaxMessageLength is the maximum length of the failure messages kept
+// in the test results.
+const MaxMessageLength = 2048
+
+// truncateMessage truncates `message` to MaxMessageLength, and marks it
+// as truncated.
+func truncateMessage(message string) string {
+	if truncated := signature.Truncate(message, MaxMessageLength); truncated != message {
+		return truncated + " [...]"
+	}
+	return message
+}
+
+func PopulateTestFromToolboxLogs(test_result *v1.TestResult, toolbox_logs map[string][]byte) error {
 	test_result.Ok = 0
 	test_result.Failures = 0
 	test_result.Ignored = 0
 	test_result.Messages[v1.TestMessageTypeFlake] = make(map[string]string)
 
-	for toolbox_step_name, toolbox_step_json := range toolbox_logs {
-		fmt.Println(toolbox_step_name)
+	for _, toolbox_step_name := range sortedFileNames(toolbox_logs) {
+		step_result := v1.ToolboxStepResult{Name: toolbox_step_name}
 
-		stats := toolbox_step_json[len(toolbox_step_json)-1].(map[string]interface{})["stats"].(map[string]interface{})["localhost"].(map[string]interface{})
-		ok := int(stats["ok"].(float64))
-		failures := int(stats["failures"].(float64))
-		ignored := int(stats["ignored"].(float64))
+		// the stats of a partial log are still used
+		ansible_log, err := ansible.Parse(toolbox_logs[toolbox_step_name])
+		if err != nil {
+			log.Warningf("Failed to parse the ansible log of the step %s of the test %s/%s: %v",
+				toolbox_step_name, test_result.TestSpec.ProwName, test_result.BuildId, err)
+			step_result.ParseError = err.Error()
+		}
+		stats := ansible_log.TotalStats()
+		ok := stats.Ok
+		failures := stats.Failures
+		ignored := stats.Ignored
 		log.Debugf("Step %s: ok %d, failures %d, ignored %d", toolbox_step_name, ok, failures, ignored)
 
-		test_result.ToolboxStepsResults = append(test_result.ToolboxStepsResults, v1.ToolboxStepResult{Name: toolbox_step_name, Ok: ok, Failures: failures, Ignored: ignored})
-		stepResults := &test_result.ToolboxStepsResults[len(test_result.ToolboxStepsResults)-1]
+		step_result.Ok = ok
+		step_result.Failures = failures
+		step_result.Ignored = ignored
+		for _, failure := range ansible_log.FailedTasks() {
+			step_result.FailedTasks = append(step_result.FailedTasks, v1.TaskFailure{
+				Role: failure.Role,
+				Task: failure.Task,
+				Host: failure.Host,
+				Msg: truncateMessage(failure.Msg),
+				Stderr: truncateMessage(failure.Stderr),
+			})
+		}
 
-		step_files_html, err := artifacts.FetchTestStepResult(test_result, toolbox_step_name + "/", artifacts.TypeHtml)
+		test_result.ToolboxStepsResults = append(test_result.ToolboxStepsResults, step_result)
+		stepResults := &test_result.ToolboxStepsResults[len(test_result.ToolboxStepsResults)-1]
 
-		step_files, err := artifacts.ListFilesInDirectory(step_files_html.Html, false, true)
+		step_files, err := artifacts.ListTestStepDirectory(test_result, toolbox_step_name, false, true)
 		if err != nil {
 			return fmt.Errorf("error fetching toolbox step files: %v", err)
 		}
@@ -102,7 +218,7 @@ func PopulateTestFromToolboxLogs(test_result *v1.TestResult, toolbox_logs map[st
 				contentBytes, err := artifacts.FetchTestStepResult(test_result, path, artifacts.TypeBytes)
 				var content string
 				if err != nil {
-					log.Warningf("error fetching the FLAKE results of %s (%s): %v", path, err)
+					fetchFailed(test_result, err, "error fetching the FLAKE results of %s: %v", path, err)
 					content = "message cannot be downloaded"
 				} else {
 					content = string(contentBytes.Bytes)
@@ -119,7 +235,7 @@ func PopulateTestFromToolboxLogs(test_result *v1.TestResult, toolbox_logs map[st
 				path := toolbox_step_name + "/" + step_filename
 				contentBytes, err := artifacts.FetchTestStepResult(test_result, path, artifacts.TypeBytes)
 				if err != nil {
-					log.Warningf("error fetching the EXPECTED_FAIL results of %s (%s): %v", path, err)
+					fetchFailed(test_result, err, "error fetching the EXPECTED_FAIL results of %s: %v", path, err)
 					stepResults.ExpectedFailure = "message cannot be downloaded"
 				} else {
 					content := string(contentBytes.Bytes)
@@ -134,34 +250,52 @@ func PopulateTestFromToolboxLogs(test_result *v1.TestResult, toolbox_logs map[st
 		test_result.Ok += ok
 		test_result.Failures += failures
 		test_result.Ignored += ignored
-
-		fmt.Println("--------------------------");
 	}
 
-	log.Debugf("Test: ok %d, failures %d, ignored %d, expected fail: %d",
+	log.Debugf("Test: ok %d, failures %d, ignored %d",
 		test_result.Ok, test_result.Failures, test_result.Ignored)
 
 	return nil
 }
 
+func populateTestToolboxLogs(test_result *v1.TestResult) {
+	test_toolbox_logs, err := artifacts.FetchTestToolboxLogs(test_result)
+	if err == artifacts.MissingPageError {
+		log.Warningf("Failed to get the toolbox steps of the test %s/%s: %v",
+			test_result.TestSpec.ProwName, test_result.BuildId, err)
+	} else if err != nil {
+		// not snapshotted, the logs are fetched again on the next run
+		fetchFailed(test_result, err, "Failed to get the toolbox logs of the test %s/%s: %v",
+			test_result.TestSpec.ProwName, test_result.BuildId, err)
+	} else if err = PopulateTestFromToolboxLogs(test_result, test_toolbox_logs); err != nil {
+		log.Warningf("Failed to get the toolbox step logs of the test %s/%s: %v",
+			test_result.TestSpec.ProwName, test_result.BuildId, err)
+	}
+}
+
 func PopulateTestStepLogs(matrices_spec *v1.MatricesSpec) {
 	var populateTestStepLogs = func(test_result *v1.TestResult) error {
-		test_toolbox_logs, err := artifacts.FetchTestToolboxLogs(test_result)
-		if err != nil {
-			log.Warningf("Failed to get the toolbox steps of the test %s/%s: %v",
-				test_result.TestSpec.ProwName, test_result.BuildId, err)
-			return nil
+		if !test_result.FromSnapshot {
+			populateTestToolboxLogs(test_result)
 		}
-		if err = PopulateTestFromToolboxLogs(test_result, test_toolbox_logs); err != nil {
-			log.Warningf("Failed to get the toolbox step logs of the test %s/%s: %v",
+
+		if err := saveSnapshot(test_result); err != nil {
+			log.Warningf("Failed to save the snapshot of the test %s/%s: %v",
 				test_result.TestSpec.ProwName, test_result.BuildId, err)
-			return nil
 		}
 
+		// all the failure details are known now
+		ClassifyTestFailure(test_result)
+		test_result.FailureSignature = signature.FromTestResult(test_result)
+
 		return nil
 	}
 
-	TraverseAllTestResults(matrices_spec, populateTestStepLogs)
+	TraverseAllTestResultsConcurrently(matrices_spec, populateTestStepLogs)
+
+	matrices_spec.FailureClusters = signature.ClusterFailures(matrices_spec)
+	matrices_spec.Regressions = DetectRegressions(matrices_spec)
+	ComputeStats(matrices_spec)
 }
 
 func TraverseAllTestResults(matrices_spec *v1.MatricesSpec, cb func(test_result *v1.TestResult) error) error {
@@ -179,14 +313,58 @@ func TraverseAllTestResults(matrices_spec *v1.MatricesSpec, cb func(test_result
 	return nil
 }
 
-func populateTestResult(test *v1.TestSpec, build_id string, finished_file artifacts.ArtifactResult) *v1.TestResult {
+// TraverseAllTestResultsConcurrently calls `cb` on every test result,
+// running at most `matrices_spec.Concurrency` callbacks at the same
+// time. The first error (in traversal order) is returned once all the
+// callbacks have completed.
+func TraverseAllTestResultsConcurrently(matrices_spec *v1.MatricesSpec, cb func(test_result *v1.TestResult) error) error {
+	test_results := []*v1.TestResult{}
+	TraverseAllTestResults(matrices_spec, func(test_result *v1.TestResult) error {
+		test_results = append(test_results, test_result)
+		return nil
+	})
+
+	errs := make([]error, len(test_results))
+	runConcurrently(matrices_spec.Concurrency, len(test_results), func(idx int) {
+		errs[idx] = cb(test_results[idx])
+	})
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchFailed logs the failure to fetch an artifact of `test_result`,
+// and records it in the test result, unless the artifact is only
+// missing.
+func fetchFailed(test_result *v1.TestResult, err error, format string, args ...interface{}) {
+	log.Warningf(format, args...)
+
+	if !artifacts.IsTransientError(err) && !artifacts.IsPermanentError(err) {
+		return
+	}
+
+	if test_result.FetchError == "" {
+		test_result.FetchError = err.Error()
+	}
+}
+
+func populateTestResult(test *v1.TestSpec, build_id string) *v1.TestResult {
 	test_result := &v1.TestResult{
 		TestSpec: test,
 		BuildId: build_id,
 		Messages: make(map[v1.TestMessageType]map[string]string),
 	}
 
-	var err error
+	finished_file, err := artifacts.FetchTestBuildResult(test.Matrix, test.ProwName, build_id,
+		"finished.json", artifacts.TypeJson)
+	if err != nil {
+		fetchFailed(test_result, err, "error fetching the results of %s:%s/%s (finished.json): %v",
+			test.Matrix.Name, test.ProwName, build_id, err)
+	}
 
 	if err = PopulateTestFromFinished(test_result, finished_file); err != nil {
 		log.Warningf("Failed to store the last results of test %s/%s: %v",
@@ -194,23 +372,42 @@ func populateTestResult(test *v1.TestSpec, build_id string, finished_file artifa
 		return test_result
 	}
 
+	started_file, err := artifacts.FetchTestBuildResult(test.Matrix, test.ProwName, build_id,
+		"started.json", artifacts.TypeJson)
+	if err == artifacts.MissingPageError {
+		log.Infof("No started.json for test %s/%s", test.ProwName, build_id)
+	} else if err != nil {
+		fetchFailed(test_result, err, "error fetching the start of %s:%s/%s (started.json): %v",
+			test.Matrix.Name, test.ProwName, build_id, err)
+	} else if err = PopulateTestFromStarted(test_result, started_file); err != nil {
+		log.Warningf("Failed to store the start of test %s/%s: %v", test.ProwName, build_id, err)
+	}
+
+	prowjob_file, err := artifacts.FetchTestBuildResult(test.Matrix, test.ProwName, build_id,
+		"prowjob.json", artifacts.TypeJson)
+	if err == artifacts.MissingPageError {
+		log.Debugf("No prowjob.json for test %s/%s", test.ProwName, build_id)
+	} else if err != nil {
+		fetchFailed(test_result, err, "error fetching the Prow job of %s:%s/%s (prowjob.json): %v",
+			test.Matrix.Name, test.ProwName, build_id, err)
+	} else if err = PopulateTestFromProwJob(test_result, prowjob_file); err != nil {
+		log.Warningf("Failed to parse the Prow job of test %s/%s: %v", test.ProwName, build_id, err)
+	}
+
 	test_result.ToolboxSteps, err = artifacts.FetchTestToolboxSteps(test_result)
 	if err != nil {
-		log.Warningf("Failed to parse the steps of test %s/%s: %v",
+		fetchFailed(test_result, err, "Failed to parse the steps of test %s/%s: %v",
 			test.ProwName, test_result.BuildId, err)
 	}
 
 	step_test_result_finished, err := artifacts.FetchTestStepResult(test_result, "finished.json", artifacts.TypeJson)
-	if err != nil {
-		// if finished.json can be parsed as an HTML file, the file certainly does'nt exist --> do not warn about it
-		_, err_json_as_html := artifacts.FetchTestStepResult(test_result, "finished.json", artifacts.TypeHtml)
-		if err_json_as_html == artifacts.MissingPageError {
-			log.Infof("No results for test step %s/%s: %v",
-				test.ProwName, test_result.BuildId, err)
-		} else if err_json_as_html != nil {
-			log.Warningf("Failed to fetch the results of test step %s/%s: %v",
-				test.ProwName, test_result.BuildId, err)
-		}
+	if err == artifacts.MissingPageError {
+		// the step didn't finish, or wasn't executed --> do not warn about it
+		log.Infof("No results for test step %s/%s: %v",
+			test.ProwName, test_result.BuildId, err)
+	} else if err != nil {
+		fetchFailed(test_result, err, "Failed to fetch the results of test step %s/%s: %v",
+			test.ProwName, test_result.BuildId, err)
 	}
 
 	if err = PopulateTestFromStepFinished(test_result, step_test_result_finished); err != nil {
@@ -225,9 +422,10 @@ func populateTestResult(test *v1.TestSpec, build_id string, finished_file artifa
 				if !test_result.StepExecuted {
 					test_result.StepExecuted = true
 				}
+				test_result.StepFailure = truncateMessage(content)
 			}
 		} else if err != artifacts.MissingPageError {
-			log.Warningf("Failed to check if %s/%s is a failure: %v", test.ProwName, test_result.BuildId, err)
+			fetchFailed(test_result, err, "Failed to check if %s/%s is a failure: %v", test.ProwName, test_result.BuildId, err)
 		}
 
 	}
@@ -236,6 +434,13 @@ func populateTestResult(test *v1.TestSpec, build_id string, finished_file artifa
 		log.Warningf("Failed to fetch the messages of test step %s/%s: %v", test.ProwName, test_result.BuildId, err)
 	}
 
+	if err = PopulateTestJUnit(test_result); err == artifacts.MissingPageError {
+		log.Debugf("No test step artifacts to look for JUnit files in %s/%s", test.ProwName, test_result.BuildId)
+	} else if err != nil {
+		fetchFailed(test_result, err, "Failed to fetch the JUnit files of test step %s/%s: %v",
+			test.ProwName, test_result.BuildId, err)
+	}
+
 	/* --- */
 
 	ocpVersion_content, err := artifacts.FetchTestStepResult(test_result, "ocp.version", artifacts.TypeBytes)
@@ -245,7 +450,7 @@ func populateTestResult(test *v1.TestSpec, build_id string, finished_file artifa
 	} else if err == artifacts.MissingPageError {
 			log.Infof("OpenShift version file (%s/%s) was not generated.", test.ProwName, test_result.BuildId)
 	} else {
-		log.Warningf("Failed to read the OpenShift version (%s/%s): %v", test.ProwName, test_result.BuildId, err)
+		fetchFailed(test_result, err, "Failed to read the OpenShift version (%s/%s): %v", test.ProwName, test_result.BuildId, err)
 	}
 	if strings.Contains(test_result.OpenShiftVersion, "doctype") {
 		// 404 page not recognized
@@ -261,7 +466,7 @@ func populateTestResult(test *v1.TestSpec, build_id string, finished_file artifa
 	} else if err == artifacts.MissingPageError {
 		log.Infof("Operator version file (%s/%s) was not generated.", test.ProwName, test_result.BuildId)
 	} else {
-		log.Warningf("Failed to read the Operator version (%s/%s): %v", test.ProwName, test_result.BuildId, err)
+		fetchFailed(test_result, err, "Failed to read the Operator version (%s/%s): %v", test.ProwName, test_result.BuildId, err)
 	}
 	if strings.Contains(test_result.OperatorVersion, "doctype") {
 		// 404 page not recognized
@@ -277,7 +482,7 @@ func populateTestResult(test *v1.TestSpec, build_id string, finished_file artifa
 	} else if err == artifacts.MissingPageError {
 		log.Infof("ci-artifacts version file (%s/%s) was not generated.", test.ProwName, test_result.BuildId)
 	} else {
-		log.Warningf("Failed to read the ci-artifacts version (%s/%s): %v", test.ProwName, test_result.BuildId, err)
+		fetchFailed(test_result, err, "Failed to read the ci-artifacts version (%s/%s): %v", test.ProwName, test_result.BuildId, err)
 	}
 	if strings.Contains(test_result.CiArtifactsVersion, "doctype") {
 		// 404 page not recognized
@@ -288,7 +493,7 @@ func populateTestResult(test *v1.TestSpec, build_id string, finished_file artifa
 	return test_result
 }
 
-func populateTest(test_matrix *v1.MatrixSpec, test_group string, test *v1.TestSpec, test_history int) error {
+func prepareTest(test_matrix *v1.MatrixSpec, test_group string, test *v1.TestSpec) {
 	test.TestGroup = test_group
 	test.Matrix = test_matrix
 
@@ -302,42 +507,158 @@ func populateTest(test_matrix *v1.MatrixSpec, test_group string, test *v1.TestSp
 
 		test.ProwName = fmt.Sprintf("%s-%s-%s", test_matrix.ProwConfig, branch, test.TestName)
 	}
+}
 
-	test_build_ids, finished_files, err := artifacts.FetchLastNTestResults(test_matrix, test.ProwName, test_history,
-		"finished.json", artifacts.TypeJson)
-	if err != nil {
-		return fmt.Errorf("Failed to fetch the last %d test results for %s: %v", test_history, test.ProwName, err)
+type testBuilds struct {
+	test *v1.TestSpec
+	build_ids []string
+}
+
+func fetchTestBuilds(test *v1.TestSpec, test_history int) (testBuilds, error) {
+	test_build_ids, err := artifacts.FetchTestBuildIds(test.Matrix, test.ProwName, test_history)
+	if artifacts.IsTransientError(err) {
+		// do not fail the whole generation, the next one may succeed
+		log.Warningf("Failed to fetch the last %d test results for %s, skipping it: %v",
+			test_history, test.ProwName, err)
+		return testBuilds{test: test}, nil
+	} else if err != nil {
+		return testBuilds{}, fmt.Errorf("Failed to fetch the last %d test results for %s: %v", test_history, test.ProwName, err)
 	}
-	for _, build_id := range test_build_ids {
-		test_result := populateTestResult(test, build_id, finished_files[build_id])
 
-		test.OldTests = append(test.OldTests, test_result)
+	return testBuilds{test: test, build_ids: test_build_ids}, nil
+}
+
+func sortedMatrixNames(matrices map[string]v1.MatrixSpec) []string {
+	names := []string{}
+	for name := range matrices {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
+}
 
-	return nil
+func sortedTestGroups(tests map[string][]v1.TestSpec) []string {
+	groups := []string{}
+	for group := range tests {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
 }
 
-func PopulateTestMatrices(matricesSpec *v1.MatricesSpec, test_history int) error {
-	// override matricesSpec.TestHistory if we received a flag value
-	if test_history >= 0 {
-		matricesSpec.TestHistory = test_history
-	} else {
-		test_history = matricesSpec.TestHistory
+func sortedFileNames(files map[string][]byte) []string {
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
+}
 
-	for matrix_name := range matricesSpec.Matrices {
+// PrepareTestMatrices completes the matrices and tests specifications
+// (names, Prow job names, inherited settings), and returns all the
+// tests, in a deterministic order.
+func PrepareTestMatrices(matricesSpec *v1.MatricesSpec) []*v1.TestSpec {
+	all_tests := []*v1.TestSpec{}
+	for _, matrix_name := range sortedMatrixNames(matricesSpec.Matrices) {
 		test_matrix := matricesSpec.Matrices[matrix_name]
 		test_matrix.Name = matrix_name
+		if test_matrix.HTTP == nil {
+			// inherit the HTTP settings of the matrices
+			test_matrix.HTTP = matricesSpec.HTTP
+		}
+		if test_matrix.Cache == nil {
+			// inherit the cache settings of the matrices
+			test_matrix.Cache = matricesSpec.Cache
+		}
+		// the rules of the matrix are checked first
+		test_matrix.FailureRules = append(append([]v1.FailureRule{}, test_matrix.FailureRules...),
+			matricesSpec.FailureRules...)
 
 		log.Printf("* %s: %s\n", test_matrix.Name, test_matrix.Description)
-		for test_group, tests := range test_matrix.Tests {
+		for _, test_group := range sortedTestGroups(test_matrix.Tests) {
+			tests := test_matrix.Tests[test_group]
 			for test_idx := range tests {
-				if err := populateTest(&test_matrix, test_group, &tests[test_idx], test_history); err != nil {
-					return err
-				}
+				prepareTest(&test_matrix, test_group, &tests[test_idx])
+				all_tests = append(all_tests, &tests[test_idx])
 			}
 		}
+		// test_matrix is a copy of the map value
+		matricesSpec.Matrices[matrix_name] = test_matrix
+	}
+
+	return all_tests
+}
+
+func PopulateTestMatrices(matricesSpec *v1.MatricesSpec, test_history, concurrency int) error {
+	// override matricesSpec.TestHistory if we received a flag value
+	if test_history >= 0 {
+		matricesSpec.TestHistory = test_history
+	} else {
+		test_history = matricesSpec.TestHistory
+	}
+
+	// override matricesSpec.Concurrency if we received a flag value
+	if concurrency > 0 {
+		matricesSpec.Concurrency = concurrency
+	} else if matricesSpec.Concurrency <= 0 {
+		matricesSpec.Concurrency = DefaultConcurrency
+	}
+
+	all_tests := PrepareTestMatrices(matricesSpec)
+
+	evicted_caches := map[string]bool{}
+	for _, test := range all_tests {
+		if evicted_caches[test.Matrix.ArtifactsCache] {
+			continue
+		}
+		if err := artifacts.EvictMatrixCache(test.Matrix); err != nil {
+			log.Warningf("Failed to evict the old entries of the cache of %s: %v", test.Matrix.Name, err)
+		}
+		evicted_caches[test.Matrix.ArtifactsCache] = true
 	}
 
+	// first pass: fetch the list of builds of each test
+
+	all_builds := make([]testBuilds, len(all_tests))
+	errs := make([]error, len(all_tests))
+	runConcurrently(matricesSpec.Concurrency, len(all_tests), func(idx int) {
+		all_builds[idx], errs[idx] = fetchTestBuilds(all_tests[idx], test_history)
+	})
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	// second pass: populate each build of each test
+
+	type buildRef struct {
+		builds *testBuilds
+		idx int
+	}
+
+	build_refs := []buildRef{}
+	for test_idx := range all_builds {
+		builds := &all_builds[test_idx]
+		builds.test.OldTests = make([]*v1.TestResult, len(builds.build_ids))
+		for build_idx := range builds.build_ids {
+			build_refs = append(build_refs, buildRef{builds, build_idx})
+		}
+	}
+
+	runConcurrently(matricesSpec.Concurrency, len(build_refs), func(idx int) {
+		builds := build_refs[idx].builds
+		build_id := builds.build_ids[build_refs[idx].idx]
+
+		if test_result := loadSnapshot(builds.test, build_id); test_result != nil {
+			builds.test.OldTests[build_refs[idx].idx] = test_result
+			return
+		}
+
+		builds.test.OldTests[build_refs[idx].idx] = populateTestResult(builds.test, build_id)
+	})
+
 	return nil
 }
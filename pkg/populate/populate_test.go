@@ -0,0 +1,347 @@
+package populate
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+	"github.com/openshift-psap/ci-dashboard/pkg/artifacts"
+	"github.com/openshift-psap/ci-dashboard/pkg/fakeprow"
+)
+
+const testJUnit = `<testsuites>
+  <testsuite name="e2e">
+    <testcase name="deploys the operator" time="10.5"></testcase>
+    <testcase name="runs the workload" time="20">
+      <failure message="workload pod failed">pod failed</failure>
+    </testcase>
+  </testsuite>
+</testsuites>`
+
+const testProwName = "periodic-ci-org-repo-main-e2e"
+
+var testBucket = &fakeprow.Bucket{
+	Jobs: []fakeprow.Job{{
+		Name: testProwName,
+		StepDir: "artifacts/e2e/test-step/artifacts",
+		Builds: []fakeprow.Build{
+			{
+				Id: "1000", Result: "SUCCESS", Timestamp: 1600000000, StepResult: "SUCCESS",
+				OpenShiftVersion: "4.9.0", OperatorVersion: "1.8.0",
+				Steps: []fakeprow.Step{
+					{Name: "000__cluster__capture_environment", Ok: 5},
+					{Name: "001__cluster__set_scale", Files: map[string]string{
+						"_ansible.log.json": `[{"play": {"name": "Run cluster_set_scale"}, "tasks": [`,
+					}},
+				},
+			},
+			{
+				Id: "1001", Result: "FAILURE", Timestamp: 1600086400, StepResult: "FAILURE",
+				Failure: "step failed",
+				Steps: []fakeprow.Step{{Name: "000__cluster__capture_environment", Ok: 3, Failures: 1,
+					Flake: "known flake"}},
+			},
+			{
+				Id: "1002", Result: "FAILURE", Timestamp: 1600172800, StepResult: "FAILURE",
+				// 3h40m run, close to the 4h timeout
+				StartTimestamp: 1600172800 - 220 * 60, CreationTimestamp: 1600172800 - 230 * 60,
+				Timeout: "4h0m0s",
+				Failure: "step failed",
+				Messages: map[string]map[string]string{"_ERROR": {"deploy": "deployment timed out"}},
+				Steps: []fakeprow.Step{{Name: "000__cluster__capture_environment", Ok: 2, Failures: 2,
+					FailedTasks: []fakeprow.FailedTask{{
+						Name: "cluster_capture_environment : Get the nodes",
+						Msg: "non-zero return code",
+						Stderr: "error: You must be logged in to the server",
+					}},
+					Files: map[string]string{"junit_e2e.xml": testJUnit}}},
+			},
+		},
+	}},
+}
+
+// newTestMatrices returns a matrix with the test of testBucket, served
+// by `server`.
+func newTestMatrices(server *fakeprow.Server, cache_dir string) *v1.MatricesSpec {
+	return &v1.MatricesSpec{
+		TestHistory: 5,
+		FailureRules: []v1.FailureRule{{
+			Name: "cluster-login",
+			Category: v1.FailureCategoryInfraFlake,
+			Pattern: "You must be logged in to the server",
+			Sources: []string{v1.FailureSourceAnsible},
+			Ticket: "https://issues.example.com/browse/CI-42",
+		}},
+		Matrices: map[string]v1.MatrixSpec{
+			"nightly": {
+				ArtifactsURL: server.ArtifactsURL(),
+				ArtifactsCache: cache_dir,
+				ProwConfig: "periodic-ci-org-repo",
+				ProwStep: "test-step",
+				Tests: map[string][]v1.TestSpec{
+					"main": {{Branch: "main", TestName: "e2e"}},
+				},
+			},
+		},
+	}
+}
+
+// populateTestBuilds populates `matrices_spec` and returns the builds of
+// its test, indexed by id.
+func populateTestBuilds(t *testing.T, matrices_spec *v1.MatricesSpec) map[string]*v1.TestResult {
+	if err := PopulateTestMatrices(matrices_spec, -1, 2); err != nil {
+		t.Fatalf("PopulateTestMatrices failed: %v", err)
+	}
+	PopulateTestStepLogs(matrices_spec)
+
+	builds := map[string]*v1.TestResult{}
+	for _, test_result := range matrices_spec.Matrices["nightly"].Tests["main"][0].OldTests {
+		builds[test_result.BuildId] = test_result
+	}
+	if len(builds) != 3 {
+		t.Fatalf("got %d builds, expected 3", len(builds))
+	}
+
+	return builds
+}
+
+func TestPrepareTestMatrices(t *testing.T) {
+	retries := 1
+	matrices_spec := &v1.MatricesSpec{
+		HTTP: &v1.HTTPSpec{Retries: &retries},
+		FailureRules: []v1.FailureRule{{Name: "global", Pattern: "error"}},
+		Matrices: map[string]v1.MatrixSpec{
+			"nightly": {
+				ProwConfig: "periodic-ci-org-repo",
+				FailureRules: []v1.FailureRule{{Name: "nightly", Pattern: "timeout"}},
+				Tests: map[string][]v1.TestSpec{
+					"main": {{Branch: "main", TestName: "e2e"}},
+				},
+			},
+		},
+	}
+
+	all_tests := PrepareTestMatrices(matrices_spec)
+	if len(all_tests) != 1 || all_tests[0].ProwName != testProwName || all_tests[0].TestGroup != "main" {
+		t.Fatalf("unexpected tests: %+v", all_tests)
+	}
+
+	for _, test_matrix := range []v1.MatrixSpec{matrices_spec.Matrices["nightly"], *all_tests[0].Matrix} {
+		if test_matrix.Name != "nightly" || test_matrix.HTTP != matrices_spec.HTTP {
+			t.Errorf("the matrix did not inherit the settings of the matrices: %+v", test_matrix)
+		}
+		rules := test_matrix.FailureRules
+		if len(rules) != 2 || rules[0].Name != "nightly" || rules[1].Name != "global" {
+			t.Errorf("got the failure rules %+v, expected the ones of the matrix, then the global ones", rules)
+		}
+	}
+}
+
+func TestPopulateTestMatrices(t *testing.T) {
+	server, err := fakeprow.NewServer(testBucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	cache_dir, err := ioutil.TempDir("", "ci-dashboard-populate-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cache_dir)
+
+	matrices_spec := newTestMatrices(server, cache_dir)
+	builds := populateTestBuilds(t, matrices_spec)
+
+	for build_id, test_result := range builds {
+		if test_result.FetchError != "" {
+			t.Errorf("build %s could not be fetched: %s", build_id, test_result.FetchError)
+		}
+	}
+
+	if testcases := builds["1000"].JUnitTestCases; len(testcases) != 0 {
+		t.Errorf("got JUnit test cases without JUnit report: %+v", testcases)
+	}
+	testcases := builds["1002"].JUnitTestCases
+	if len(testcases) != 2 {
+		t.Fatalf("got %d JUnit test cases, expected 2", len(testcases))
+	}
+	if testcases[0].Name != "deploys the operator" || testcases[0].Status != "passed" ||
+		testcases[0].Duration != 10500 * time.Millisecond ||
+		testcases[0].File != "000__cluster__capture_environment/junit_e2e.xml" {
+		t.Errorf("unexpected passed test case: %+v", testcases[0])
+	}
+	if testcases[1].Name != "runs the workload" || testcases[1].Status != "failed" ||
+		testcases[1].Message != "workload pod failed" {
+		t.Errorf("unexpected failed test case: %+v", testcases[1])
+	}
+
+	test_result := builds["1002"]
+	if !test_result.StartTime.Valid || test_result.StartTime.Time.Unix() != 1600172800 - 220 * 60 ||
+		!test_result.CreationTime.Valid || !test_result.FinishTime.Valid {
+		t.Errorf("unexpected times of the build: %+v", test_result)
+	}
+	if test_result.Duration != 220 * time.Minute || test_result.QueueTime != 10 * time.Minute ||
+		test_result.Timeout != 4 * time.Hour {
+		t.Errorf("got duration %v, queue time %v and timeout %v, expected 3h40m, 10m and 4h",
+			test_result.Duration, test_result.QueueTime, test_result.Timeout)
+	}
+	// no started.json
+	if test_result := builds["1000"]; test_result.StartTime.Valid || test_result.Duration != 0 {
+		t.Errorf("got a start time without started.json: %+v", test_result)
+	}
+
+	// the log of the second step is truncated
+	steps := builds["1000"].ToolboxStepsResults
+	if len(steps) != 2 {
+		t.Fatalf("got %d toolbox steps, expected 2", len(steps))
+	}
+	if steps[0].Ok != 5 || steps[0].ParseError != "" {
+		t.Errorf("unexpected result of the first step: %+v", steps[0])
+	}
+	if steps[1].Name != "001__cluster__set_scale" || !strings.HasPrefix(steps[1].ParseError, "truncated log") {
+		t.Errorf("the truncated log of the second step was not reported: %+v", steps[1])
+	}
+
+	failed_tasks := builds["1002"].ToolboxStepsResults[0].FailedTasks
+	expected_failure := v1.TaskFailure{
+		Role: "cluster_capture_environment",
+		Task: "Get the nodes",
+		Host: "localhost",
+		Msg: "non-zero return code",
+		Stderr: "error: You must be logged in to the server",
+	}
+	if len(failed_tasks) != 1 || failed_tasks[0] != expected_failure {
+		t.Errorf("got failed tasks %+v, expected %+v", failed_tasks, expected_failure)
+	}
+
+	if classification := builds["1002"].Classification; classification == nil ||
+		classification.Rule != "cluster-login" || classification.Category != v1.FailureCategoryInfraFlake ||
+		classification.Source != v1.FailureSourceAnsible ||
+		classification.Ticket != "https://issues.example.com/browse/CI-42" {
+		t.Errorf("unexpected classification of the failed task: %+v", classification)
+	}
+	if builds["1002"].Status() != v1.FailureCategoryInfraFlake {
+		t.Errorf("got status %s, expected %s", builds["1002"].Status(), v1.FailureCategoryInfraFlake)
+	}
+	if classification := builds["1000"].Classification; classification != nil {
+		t.Errorf("the passed build was classified: %+v", classification)
+	}
+
+	expected_signature := "cluster_capture_environment : Get the nodes: non-zero return code"
+	if signature := builds["1002"].FailureSignature; signature != expected_signature {
+		t.Errorf("got the signature %q, expected %q", signature, expected_signature)
+	}
+	if signature := builds["1000"].FailureSignature; signature != "" {
+		t.Errorf("got a signature for the passed build: %q", signature)
+	}
+	signatures := []string{}
+	for _, cluster := range matrices_spec.FailureClusters {
+		signatures = append(signatures, cluster.Signature)
+	}
+	if len(signatures) != 2 {
+		t.Errorf("got the failure clusters %q, expected one per failed build", signatures)
+	}
+}
+
+func TestPopulateTestMatricesFromSnapshots(t *testing.T) {
+	server, err := fakeprow.NewServer(testBucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	cache_dir, err := ioutil.TempDir("", "ci-dashboard-populate-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cache_dir)
+
+	populateTestBuilds(t, newTestMatrices(server, cache_dir))
+
+	// drop the artifacts cache, only the snapshots remain
+	cache_entries, err := ioutil.ReadDir(cache_dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range cache_entries {
+		if entry.Name() != artifacts.SnapshotsDirName {
+			os.RemoveAll(filepath.Join(cache_dir, entry.Name()))
+		}
+	}
+
+	ansible_log := "logs/" + testProwName +
+		"/1002/artifacts/e2e/test-step/artifacts/000__cluster__capture_environment/_ansible.log.json"
+	ansible_log_requests := server.Requests(ansible_log)
+	server.RemoveFile(ansible_log)
+
+	builds := populateTestBuilds(t, newTestMatrices(server, cache_dir))
+	if server.Requests(ansible_log) != ansible_log_requests {
+		t.Errorf("the ansible log of a finished build was fetched again")
+	}
+
+	for build_id, test_result := range builds {
+		if !test_result.FromSnapshot {
+			t.Errorf("build %s was not restored from its snapshot", build_id)
+		}
+	}
+
+	test_result := builds["1002"]
+	if len(test_result.ToolboxStepsResults) != 1 || len(test_result.ToolboxStepsResults[0].FailedTasks) != 1 ||
+		len(test_result.JUnitTestCases) != 2 || test_result.QueueTime != 10 * time.Minute {
+		t.Errorf("the build was not restored correctly: %+v", test_result)
+	}
+	// classified again after the restoration
+	if test_result.Classification == nil || test_result.Classification.Rule != "cluster-login" ||
+		test_result.FailureSignature == "" {
+		t.Errorf("the restored build was not classified: %+v", test_result)
+	}
+	if steps := builds["1000"].ToolboxStepsResults; len(steps) != 2 || steps[1].ParseError == "" {
+		t.Errorf("the parse error was not restored: %+v", steps)
+	}
+}
+
+func TestPopulateTestMatricesToolboxLogError(t *testing.T) {
+	server, err := fakeprow.NewServer(testBucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	cache_dir, err := ioutil.TempDir("", "ci-dashboard-populate-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cache_dir)
+
+	ansible_log := "logs/" + testProwName +
+		"/1002/artifacts/e2e/test-step/artifacts/000__cluster__capture_environment/_ansible.log.json"
+	server.SetStatus(ansible_log, http.StatusServiceUnavailable)
+
+	matrices_spec := newTestMatrices(server, cache_dir)
+	retries := 0
+	test_matrix := matrices_spec.Matrices["nightly"]
+	test_matrix.HTTP = &v1.HTTPSpec{Retries: &retries}
+	matrices_spec.Matrices["nightly"] = test_matrix
+
+	builds := populateTestBuilds(t, matrices_spec)
+	if builds["1002"].FetchError == "" {
+		t.Errorf("the unavailable ansible log was not reported: %+v", builds["1002"])
+	}
+	if builds["1000"].FetchError != "" {
+		t.Errorf("build 1000 could not be fetched: %s", builds["1000"].FetchError)
+	}
+
+	snapshots_dir := filepath.Join(cache_dir, artifacts.SnapshotsDirName, testProwName)
+	if _, err = os.Stat(filepath.Join(snapshots_dir, "1002.json")); !os.IsNotExist(err) {
+		t.Errorf("the build with an unavailable ansible log was snapshotted (%v)", err)
+	}
+	if _, err = os.Stat(filepath.Join(snapshots_dir, "1000.json")); err != nil {
+		t.Errorf("the complete build was not snapshotted: %v", err)
+	}
+}
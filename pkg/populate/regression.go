@@ -0,0 +1,149 @@
+package populate
+
+import (
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+// isFinished tells if `test_result` is a finished build, whose
+// artifacts could be fetched.
+func isFinished(test_result *v1.TestResult) bool {
+	if test_result.Result == "" || test_result.Result == "N/A" {
+		return false
+	}
+	return test_result.FetchError == ""
+}
+
+// isFlake tells if `test_result` failed because of a known flake or of
+// the infrastructure.
+func isFlake(test_result *v1.TestResult) bool {
+	if test_result.Passed {
+		return false
+	} else if test_result.FlakeFailure || len(test_result.Messages[v1.TestMessageTypeFlake]) != 0 {
+		return true
+	}
+	return test_result.Classification != nil &&
+		test_result.Classification.Category == v1.FailureCategoryInfraFlake
+}
+
+// isConclusive tells if the result of `test_result` says something
+// about the tested code: the builds still running, which could not be
+// fetched, or which failed because of a flake are not conclusive.
+func isConclusive(test_result *v1.TestResult) bool {
+	if test_result.Passed {
+		return true
+	}
+	return isFinished(test_result) && !isFlake(test_result)
+}
+
+func versionChanges(last_good, first_bad *v1.TestResult) []v1.VersionChange {
+	changes := []v1.VersionChange{}
+	for _, version := range []struct {
+		name string
+		last_good string
+		first_bad string
+	}{
+		{"operator", last_good.OperatorVersion, first_bad.OperatorVersion},
+		{"openshift", last_good.OpenShiftVersion, first_bad.OpenShiftVersion},
+		{"ci-artifacts", last_good.CiArtifactsVersion, first_bad.CiArtifactsVersion},
+	} {
+		if version.last_good != version.first_bad {
+			changes = append(changes, v1.VersionChange{
+				Name: version.name,
+				LastGood: version.last_good,
+				FirstBad: version.first_bad,
+			})
+		}
+	}
+
+	return changes
+}
+
+// DetectRegression looks for the transition from passing to failing in
+// the history of `test`. `test.OldTests` must be ordered newest first.
+func DetectRegression(test *v1.TestSpec) *v1.Regression {
+	regression := &v1.Regression{Test: test, Status: v1.RegressionStatusUnknown}
+
+	for _, test_result := range test.OldTests {
+		if !isConclusive(test_result) {
+			continue
+		}
+
+		if test_result.Passed {
+			if regression.FailedBuilds == 0 {
+				regression.Status = v1.RegressionStatusPassing
+			} else {
+				regression.Status = v1.RegressionStatusRegressed
+				regression.LastGood = test_result
+				regression.VersionChanges = versionChanges(regression.LastGood, regression.FirstBad)
+			}
+			return regression
+		}
+
+		regression.FailedBuilds += 1
+		regression.FirstBad = test_result
+		regression.Status = v1.RegressionStatusFailing
+	}
+
+	return regression
+}
+
+// DetectRegressions analyzes the history of all the tests, and returns
+// the regressions of the tests which are currently failing, in a
+// deterministic order.
+func DetectRegressions(matrices_spec *v1.MatricesSpec) []*v1.Regression {
+	regressions := []*v1.Regression{}
+
+	for _, matrix_name := range sortedMatrixNames(matrices_spec.Matrices) {
+		test_matrix := matrices_spec.Matrices[matrix_name]
+		for _, test_group := range sortedTestGroups(test_matrix.Tests) {
+			tests := test_matrix.Tests[test_group]
+			for test_idx := range tests {
+				test := &tests[test_idx]
+				test.Regression = DetectRegression(test)
+
+				if test.Regression.Status == v1.RegressionStatusRegressed ||
+					test.Regression.Status == v1.RegressionStatusFailing {
+					regressions = append(regressions, test.Regression)
+				}
+			}
+		}
+	}
+
+	return regressions
+}
+
+// RegressionReportEntry is the machine-readable regression status of
+// a test.
+type RegressionReportEntry struct {
+	Matrix string `json:"matrix"`
+	TestGroup string `json:"test_group"`
+	ProwName string `json:"prow_name"`
+	Regression *v1.Regression `json:"regression"`
+}
+
+// RegressionReport returns the regression status of all the tests, in
+// a deterministic order. DetectRegressions must have been called
+// before.
+func RegressionReport(matrices_spec *v1.MatricesSpec) []RegressionReportEntry {
+	report := []RegressionReportEntry{}
+
+	for _, matrix_name := range sortedMatrixNames(matrices_spec.Matrices) {
+		test_matrix := matrices_spec.Matrices[matrix_name]
+		for _, test_group := range sortedTestGroups(test_matrix.Tests) {
+			for _, test := range test_matrix.Tests[test_group] {
+				if test.Regression == nil {
+					continue
+				}
+
+				report = append(report, RegressionReportEntry{
+					Matrix: matrix_name,
+					TestGroup: test_group,
+					ProwName: test.ProwName,
+					Regression: test.Regression,
+				})
+			}
+		}
+	}
+
+	return report
+}
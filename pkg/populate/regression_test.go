@@ -0,0 +1,138 @@
+package populate
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+func TestDetectRegression(t *testing.T) {
+	passed := func(build_id, operator_version string) *v1.TestResult {
+		return &v1.TestResult{BuildId: build_id, Passed: true, Result: "SUCCESS", OperatorVersion: operator_version}
+	}
+	failed := func(build_id, operator_version string) *v1.TestResult {
+		return &v1.TestResult{BuildId: build_id, Result: "FAILURE", OperatorVersion: operator_version}
+	}
+	known_flake := failed("flake", "1.1")
+	known_flake.FlakeFailure = true
+	infra_flake := failed("infra", "1.1")
+	infra_flake.Classification = &v1.FailureClassification{Category: v1.FailureCategoryInfraFlake}
+	fetch_error := failed("fetch", "")
+	fetch_error.FetchError = "timeout"
+	running := &v1.TestResult{BuildId: "running", Result: "N/A"}
+
+	for _, tc := range []struct {
+		name string
+		// newest first
+		builds []*v1.TestResult
+		status string
+		last_good string
+		first_bad string
+		failed_builds int
+		changes int
+	}{
+		{"no builds", nil, v1.RegressionStatusUnknown, "", "", 0, 0},
+		{"only flakes", []*v1.TestResult{known_flake, infra_flake, running}, v1.RegressionStatusUnknown, "", "", 0, 0},
+		{"passing", []*v1.TestResult{passed("3", "1.1"), failed("2", "1.1"), passed("1", "1.0")},
+			v1.RegressionStatusPassing, "", "", 0, 0},
+		{"passing after flakes", []*v1.TestResult{running, infra_flake, passed("1", "1.0")},
+			v1.RegressionStatusPassing, "", "", 0, 0},
+		{"regressed", []*v1.TestResult{failed("4", "1.1"), fetch_error, failed("3", "1.1"), passed("2", "1.0"), failed("1", "1.0")},
+			v1.RegressionStatusRegressed, "2", "3", 2, 1},
+		{"regressed over flakes", []*v1.TestResult{failed("3", "1.0"), known_flake, infra_flake, passed("1", "1.0")},
+			v1.RegressionStatusRegressed, "1", "3", 1, 0},
+		{"always failing", []*v1.TestResult{failed("2", "1.0"), known_flake, failed("1", "1.0")},
+			v1.RegressionStatusFailing, "", "1", 2, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			regression := DetectRegression(&v1.TestSpec{OldTests: tc.builds})
+
+			if regression.Status != tc.status {
+				t.Fatalf("got status %q, expected %q", regression.Status, tc.status)
+			}
+			if regression.FailedBuilds != tc.failed_builds {
+				t.Errorf("got %d failed builds, expected %d", regression.FailedBuilds, tc.failed_builds)
+			}
+			if len(regression.VersionChanges) != tc.changes {
+				t.Errorf("got %d version changes, expected %d: %+v",
+					len(regression.VersionChanges), tc.changes, regression.VersionChanges)
+			}
+
+			last_good, first_bad := "", ""
+			if regression.LastGood != nil {
+				last_good = regression.LastGood.BuildId
+			}
+			if regression.FirstBad != nil {
+				first_bad = regression.FirstBad.BuildId
+			}
+			if last_good != tc.last_good || first_bad != tc.first_bad {
+				t.Errorf("got last good %q and first bad %q, expected %q and %q",
+					last_good, first_bad, tc.last_good, tc.first_bad)
+			}
+		})
+	}
+}
+
+func TestRegressionReport(t *testing.T) {
+	build := func(build_id string, day int, passed bool, operator_version string) *v1.TestResult {
+		result := "FAILURE"
+		if passed {
+			result = "SUCCESS"
+		}
+		return &v1.TestResult{BuildId: build_id, Passed: passed, Result: result, OperatorVersion: operator_version,
+			FinishTime: v1.NewTimestamp(time.Date(2020, 9, day, 12, 0, 0, 0, time.UTC))}
+	}
+
+	test_matrix := &v1.MatrixSpec{Name: "nightly"}
+	main := []v1.TestSpec{{ProwName: "main-e2e", Matrix: test_matrix,
+		OldTests: []*v1.TestResult{build("1001", 14, true, "1.8.0"), build("1000", 13, false, "1.8.0")}}}
+	release := []v1.TestSpec{{ProwName: "release-e2e", Matrix: test_matrix,
+		OldTests: []*v1.TestResult{build("2002", 15, false, "1.7.1"), build("2001", 14, false, "1.7.1"),
+			build("2000", 13, true, "1.7.0")}}}
+	for _, tests := range [][]v1.TestSpec{main, release} {
+		for _, test_result := range tests[0].OldTests {
+			test_result.TestSpec = &tests[0]
+		}
+	}
+	matrices_spec := &v1.MatricesSpec{Matrices: map[string]v1.MatrixSpec{
+		"nightly": {Tests: map[string][]v1.TestSpec{"1_release": release, "0_main": main}},
+	}}
+
+	regressions := DetectRegressions(matrices_spec)
+	if len(regressions) != 1 || regressions[0].Test != &release[0] {
+		t.Fatalf("got %d regressions, expected the release test only", len(regressions))
+	}
+
+	report := RegressionReport(matrices_spec)
+	if len(report) != 2 {
+		t.Fatalf("got %d tests in the report, expected 2", len(report))
+	}
+	if report[0].ProwName != "main-e2e" || report[0].Regression.Status != v1.RegressionStatusPassing {
+		t.Errorf("unexpected status of the main test: %+v", report[0])
+	}
+
+	entry := report[1]
+	if entry.Matrix != "nightly" || entry.TestGroup != "1_release" || entry.ProwName != "release-e2e" ||
+		entry.Regression != regressions[0] {
+		t.Errorf("unexpected regression of the release test: %+v", entry)
+	}
+
+	content, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`"status":"regressed"`,
+		`"failed_builds":2`,
+		`"last_good":{"prow_name":"release-e2e","build_id":"2000"}`,
+		`"first_bad":{"prow_name":"release-e2e","build_id":"2001"}`,
+		`"version_changes":[{"name":"operator","last_good":"1.7.0","first_bad":"1.7.1"}]`,
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("the regression report entry does not contain %s: %s", expected, content)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package populate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+	"github.com/openshift-psap/ci-dashboard/pkg/artifacts"
+)
+
+// SnapshotSchemaVersion is the version of the content of the build
+// snapshots. It must be increased when the parsing of the artifacts or
+// the v1.TestResult structure change, so that the snapshots of the
+// previous versions are ignored and the builds populated again.
+const SnapshotSchemaVersion = 2
+
+// snapshot is the populated TestResult of a finished build. The
+// failure classification and signature are not part of it, they are
+// computed again on every run. The snapshot is only valid for the
+// configuration of the test it was populated with.
+type snapshot struct {
+	SchemaVersion int `json:"schema_version"`
+	SpecHash string `json:"spec_hash"`
+	CreatedAt time.Time `json:"created_at"`
+	TestResult *v1.TestResult `json:"test_result"`
+}
+
+// snapshotSpec is the configuration of a test which changes how its
+// builds are populated.
+type snapshotSpec struct {
+	TestName string `json:"test_name"`
+	ProwStep string `json:"prow_step"`
+	IsCiOperator *bool `json:"is_ci_operator"`
+	OperatorVersion string `json:"operator_version"`
+	FailureRules []v1.FailureRule `json:"failure_rules"`
+}
+
+// snapshotSpecHash returns the hash of the configuration of `test`
+// used to populate its builds, so that the snapshots are ignored when
+// it changes.
+func snapshotSpecHash(test *v1.TestSpec) string {
+	spec := snapshotSpec{
+		TestName: test.TestName,
+		ProwStep: test.ProwStep,
+		IsCiOperator: test.IsCiOperator,
+		OperatorVersion: test.OperatorVersion,
+	}
+	if test.Matrix != nil {
+		if spec.ProwStep == "" {
+			spec.ProwStep = test.Matrix.ProwStep
+		}
+		spec.FailureRules = test.Matrix.FailureRules
+	}
+
+	content, err := json.Marshal(spec)
+	if err != nil {
+		log.Warningf("Failed to encode the configuration of %s: %v", test.ProwName, err)
+		return ""
+	}
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
+
+func snapshotPath(test *v1.TestSpec, build_id string) string {
+	snapshots_dir := artifacts.SnapshotsDir(test.Matrix)
+	if snapshots_dir == "" {
+		return ""
+	}
+	return filepath.Join(snapshots_dir, test.ProwName, build_id + ".json")
+}
+
+// loadSnapshot returns the test result of the snapshot of `build_id`,
+// or nil if it has no valid snapshot.
+func loadSnapshot(test *v1.TestSpec, build_id string) *v1.TestResult {
+	snapshot_path := snapshotPath(test, build_id)
+	if snapshot_path == "" {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(snapshot_path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warningf("Failed to read the snapshot of %s/%s: %v", test.ProwName, build_id, err)
+		}
+		return nil
+	}
+
+	build_snapshot := snapshot{}
+	if err = json.Unmarshal(content, &build_snapshot); err != nil {
+		log.Warningf("Ignoring the invalid snapshot of %s/%s: %v", test.ProwName, build_id, err)
+		return nil
+	} else if build_snapshot.SchemaVersion != SnapshotSchemaVersion || build_snapshot.TestResult == nil {
+		log.Debugf("Ignoring the snapshot of %s/%s (schema version %d, expected %d)",
+			test.ProwName, build_id, build_snapshot.SchemaVersion, SnapshotSchemaVersion)
+		return nil
+	} else if build_snapshot.SpecHash != snapshotSpecHash(test) {
+		log.Debugf("Ignoring the snapshot of %s/%s, the configuration of the test changed",
+			test.ProwName, build_id)
+		return nil
+	}
+
+	test_result := build_snapshot.TestResult
+	if test_result.BuildId != build_id {
+		log.Warningf("Ignoring the snapshot of %s/%s, it describes the build %s",
+			test.ProwName, build_id, test_result.BuildId)
+		return nil
+	}
+	// keep the snapshot away from the age-based eviction
+	now := time.Now()
+	if err = os.Chtimes(snapshot_path, now, now); err != nil {
+		log.Warningf("Failed to update the modification time of %s: %v", snapshot_path, err)
+	}
+
+	test_result.TestSpec = test
+	test_result.FromSnapshot = true
+	if test_result.Messages == nil {
+		test_result.Messages = map[v1.TestMessageType]map[string]string{}
+	}
+
+	return test_result
+}
+
+// saveSnapshot stores the snapshot of `test_result`, if the build is
+// finished and all its artifacts could be fetched.
+func saveSnapshot(test_result *v1.TestResult) error {
+	if test_result.FromSnapshot || !isFinished(test_result) {
+		return nil
+	}
+
+	snapshot_path := snapshotPath(test_result.TestSpec, test_result.BuildId)
+	if snapshot_path == "" {
+		return nil
+	}
+
+	stored := *test_result
+	stored.Classification = nil
+	stored.FailureSignature = ""
+
+	content, err := json.Marshal(snapshot{
+		SchemaVersion: SnapshotSchemaVersion,
+		SpecHash: snapshotSpecHash(test_result.TestSpec),
+		CreatedAt: time.Now().UTC(),
+		TestResult: &stored,
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding the snapshot: %v", err)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(snapshot_path), os.ModePerm); err != nil {
+		return err
+	}
+
+	// the concurrent readers never see a partial snapshot
+	return artifacts.WriteFileAtomic(snapshot_path, content)
+}
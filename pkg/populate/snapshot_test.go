@@ -0,0 +1,104 @@
+package populate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+func TestSnapshot(t *testing.T) {
+	tmp_dir, err := ioutil.TempDir("", "ci-dashboard-snapshot-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp_dir)
+
+	test := &v1.TestSpec{
+		ProwName: "periodic-e2e",
+		Matrix: &v1.MatrixSpec{ArtifactsCache: tmp_dir},
+	}
+	finished := &v1.TestResult{
+		TestSpec: test,
+		BuildId: "1002",
+		Result: "FAILURE",
+		FinishTime: v1.NewTimestamp(time.Unix(1600172800, 0)),
+		Duration: 220 * time.Minute,
+		StepFailure: "step failed",
+		Messages: map[v1.TestMessageType]map[string]string{
+			v1.TestMessageTypeError: {"deploy": "deployment timed out"},
+		},
+		ToolboxStepsResults: []v1.ToolboxStepResult{{Name: "000__cluster", Ok: 2, Failures: 1,
+			FailedTasks: []v1.TaskFailure{{Task: "Get the nodes", Msg: "non-zero return code"}}}},
+		Classification: &v1.FailureClassification{Rule: "cluster-login"},
+		FailureSignature: "Get the nodes: non-zero return code",
+	}
+	running := &v1.TestResult{TestSpec: test, BuildId: "1003", Result: "N/A"}
+	fetch_error := &v1.TestResult{TestSpec: test, BuildId: "1001", Result: "SUCCESS", FetchError: "timeout"}
+
+	for _, test_result := range []*v1.TestResult{finished, running, fetch_error} {
+		if err = saveSnapshot(test_result); err != nil {
+			t.Fatalf("failed to save the snapshot of %s: %v", test_result.BuildId, err)
+		}
+	}
+
+	if loadSnapshot(test, "1003") != nil || loadSnapshot(test, "1001") != nil {
+		t.Errorf("the snapshot of a running or incomplete build was saved")
+	}
+
+	// the snapshot was not used for two days
+	snapshot_path := snapshotPath(test, "1002")
+	unused_since := time.Now().Add(-48 * time.Hour)
+	if err = os.Chtimes(snapshot_path, unused_since, unused_since); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := loadSnapshot(test, "1002")
+	if restored == nil {
+		t.Fatalf("the snapshot of the finished build was not restored")
+	}
+	if info, err := os.Stat(snapshot_path); err != nil || time.Since(info.ModTime()) > time.Hour {
+		t.Errorf("the snapshot was not touched when loaded, it could be evicted")
+	}
+	if !restored.FromSnapshot || restored.TestSpec != test {
+		t.Errorf("the restored build is not bound to its test")
+	}
+	if !restored.FinishTime.Time.Equal(finished.FinishTime.Time) || restored.Duration != finished.Duration ||
+		restored.Messages[v1.TestMessageTypeError]["deploy"] != "deployment timed out" ||
+		restored.ToolboxStepsResults[0].FailedTasks[0].Msg != "non-zero return code" {
+		t.Errorf("the build was not restored correctly: %+v", restored)
+	}
+	if restored.Classification != nil || restored.FailureSignature != "" {
+		t.Errorf("the failure classification was part of the snapshot")
+	}
+
+	// the test step of the test changed
+	test.ProwStep = "other-step"
+	if loadSnapshot(test, "1002") != nil {
+		t.Errorf("the snapshot of another configuration of the test was restored")
+	}
+	test.ProwStep = ""
+	if loadSnapshot(test, "1002") == nil {
+		t.Errorf("the snapshot was not restored with its configuration")
+	}
+
+	// snapshot of an older version of the parser
+	content, err := ioutil.ReadFile(snapshot_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outdated := []byte(`{"schema_version": 0` + string(content[len(`{"schema_version":1`):]))
+	if err = ioutil.WriteFile(snapshot_path, outdated, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if loadSnapshot(test, "1002") != nil {
+		t.Errorf("the snapshot of an older schema version was restored")
+	}
+
+	if _, err = os.Stat(filepath.Join(tmp_dir, ".snapshots", "periodic-e2e", "1002.json")); err != nil {
+		t.Errorf("the snapshot is not stored in the artifacts cache: %v", err)
+	}
+}
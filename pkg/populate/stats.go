@@ -0,0 +1,221 @@
+package populate
+
+import (
+	"sort"
+	"time"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+// statsAccumulator aggregates the statistics of several tests.
+type statsAccumulator struct {
+	stats v1.TestStats
+	failure_times []time.Time
+}
+
+func (acc *statsAccumulator) add(stats *v1.TestStats, failure_times []time.Time) {
+	acc.stats.Builds += stats.Builds
+	acc.stats.Passed += stats.Passed
+	acc.stats.Failed += stats.Failed
+	acc.stats.Flakes += stats.Flakes
+	acc.stats.Flips += stats.Flips
+	if stats.FailureStreak > acc.stats.FailureStreak {
+		acc.stats.FailureStreak = stats.FailureStreak
+	}
+	acc.failure_times = append(acc.failure_times, failure_times...)
+}
+
+func (acc *statsAccumulator) result() *v1.TestStats {
+	stats := acc.stats
+	if stats.Builds != 0 {
+		stats.PassRate = float64(stats.Passed) / float64(stats.Builds)
+		stats.FlakeRate = float64(stats.Flakes) / float64(stats.Builds)
+	}
+
+	if len(acc.failure_times) >= 2 {
+		sort.Slice(acc.failure_times, func(i, j int) bool {
+			return acc.failure_times[i].Before(acc.failure_times[j])
+		})
+		first := acc.failure_times[0]
+		last := acc.failure_times[len(acc.failure_times) - 1]
+		stats.MeanTimeBetweenFailures = last.Sub(first) / time.Duration(len(acc.failure_times) - 1)
+		stats.MeanTimeBetweenFailuresSeconds = stats.MeanTimeBetweenFailures.Seconds()
+	}
+
+	return &stats
+}
+
+// computeTestStats computes the statistics of the builds of a test,
+// and returns them with the finish time of its failed builds.
+// `test_results` must be ordered newest first.
+func computeTestStats(test_results []*v1.TestResult) (*v1.TestStats, []time.Time) {
+	acc := statsAccumulator{}
+	stats := &acc.stats
+
+	seen_passed := false
+	var previous *v1.TestResult
+	for _, test_result := range test_results {
+		if !isFinished(test_result) {
+			continue
+		}
+
+		stats.Builds += 1
+		if test_result.Passed {
+			stats.Passed += 1
+			seen_passed = true
+		} else {
+			if isFlake(test_result) {
+				stats.Flakes += 1
+			} else {
+				stats.Failed += 1
+			}
+			if !seen_passed {
+				stats.FailureStreak += 1
+			}
+			if test_result.FinishTime.Valid {
+				acc.failure_times = append(acc.failure_times, test_result.FinishTime.Time)
+			}
+		}
+
+		if previous != nil && previous.Passed != test_result.Passed {
+			stats.Flips += 1
+		}
+		previous = test_result
+	}
+
+	return acc.result(), acc.failure_times
+}
+
+// ComputeTestStats computes the statistics of the builds of a test,
+// ordered newest first.
+func ComputeTestStats(test_results []*v1.TestResult) *v1.TestStats {
+	stats, _ := computeTestStats(test_results)
+	return stats
+}
+
+// lessReliable tells if the test `a` is less reliable than the test
+// `b`: lower pass rate, then higher flake rate, then more flips. The
+// tests without builds come last.
+func lessReliable(a, b *v1.TestStats) bool {
+	if (a.Builds == 0) != (b.Builds == 0) {
+		return b.Builds == 0
+	} else if a.PassRate != b.PassRate {
+		return a.PassRate < b.PassRate
+	} else if a.FlakeRate != b.FlakeRate {
+		return a.FlakeRate > b.FlakeRate
+	}
+	return a.Flips > b.Flips
+}
+
+// ComputeStats computes the statistics of all the tests, test groups
+// and matrices, and ranks the tests with failed builds, least reliable
+// first.
+func ComputeStats(matrices_spec *v1.MatricesSpec) {
+	matrices_spec.Stats = map[string]*v1.MatrixStats{}
+	matrices_spec.UnreliableTests = []*v1.TestSpec{}
+
+	for _, matrix_name := range sortedMatrixNames(matrices_spec.Matrices) {
+		test_matrix := matrices_spec.Matrices[matrix_name]
+		matrix_acc := statsAccumulator{}
+		matrix_stats := &v1.MatrixStats{Groups: map[string]*v1.TestStats{}}
+
+		for _, test_group := range sortedTestGroups(test_matrix.Tests) {
+			tests := test_matrix.Tests[test_group]
+			group_acc := statsAccumulator{}
+			for test_idx := range tests {
+				test := &tests[test_idx]
+
+				stats, failure_times := computeTestStats(test.OldTests)
+				test.Stats = stats
+				group_acc.add(stats, failure_times)
+				matrix_acc.add(stats, failure_times)
+
+				if stats.Passed != stats.Builds {
+					matrices_spec.UnreliableTests = append(matrices_spec.UnreliableTests, test)
+				}
+			}
+			matrix_stats.Groups[test_group] = group_acc.result()
+		}
+
+		matrix_stats.TestStats = *matrix_acc.result()
+		matrices_spec.Stats[matrix_name] = matrix_stats
+	}
+
+	sort.SliceStable(matrices_spec.UnreliableTests, func(i, j int) bool {
+		return lessReliable(matrices_spec.UnreliableTests[i].Stats, matrices_spec.UnreliableTests[j].Stats)
+	})
+}
+
+type GroupStatsReport struct {
+	Name string `json:"name"`
+	*v1.TestStats
+}
+
+type MatrixStatsReport struct {
+	Name string `json:"name"`
+	*v1.TestStats
+	Groups []GroupStatsReport `json:"groups"`
+}
+
+type TestStatsReport struct {
+	Matrix string `json:"matrix"`
+	TestGroup string `json:"test_group"`
+	ProwName string `json:"prow_name"`
+	*v1.TestStats
+}
+
+type StatsReport struct {
+	Matrices []MatrixStatsReport `json:"matrices"`
+	// least reliable first
+	Tests []TestStatsReport `json:"tests"`
+}
+
+// NewStatsReport returns the statistics of all the matrices, test
+// groups and tests. ComputeStats must have been called before.
+func NewStatsReport(matrices_spec *v1.MatricesSpec) StatsReport {
+	report := StatsReport{Matrices: []MatrixStatsReport{}, Tests: []TestStatsReport{}}
+
+	for _, matrix_name := range sortedMatrixNames(matrices_spec.Matrices) {
+		matrix_stats := matrices_spec.Stats[matrix_name]
+		if matrix_stats == nil {
+			continue
+		}
+		matrix_report := MatrixStatsReport{
+			Name: matrix_name,
+			TestStats: &matrix_stats.TestStats,
+			Groups: []GroupStatsReport{},
+		}
+
+		test_matrix := matrices_spec.Matrices[matrix_name]
+		for _, test_group := range sortedTestGroups(test_matrix.Tests) {
+			group_stats := matrix_stats.Groups[test_group]
+			if group_stats == nil {
+				group_stats = &v1.TestStats{}
+			}
+			matrix_report.Groups = append(matrix_report.Groups, GroupStatsReport{
+				Name: test_group,
+				TestStats: group_stats,
+			})
+
+			for _, test := range test_matrix.Tests[test_group] {
+				if test.Stats == nil {
+					continue
+				}
+				report.Tests = append(report.Tests, TestStatsReport{
+					Matrix: matrix_name,
+					TestGroup: test_group,
+					ProwName: test.ProwName,
+					TestStats: test.Stats,
+				})
+			}
+		}
+
+		report.Matrices = append(report.Matrices, matrix_report)
+	}
+
+	sort.SliceStable(report.Tests, func(i, j int) bool {
+		return lessReliable(report.Tests[i].TestStats, report.Tests[j].TestStats)
+	})
+
+	return report
+}
@@ -0,0 +1,138 @@
+package populate
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+func TestComputeStats(t *testing.T) {
+	day := func(d int) v1.Timestamp {
+		return v1.NewTimestamp(time.Date(2021, 3, d, 0, 0, 0, 0, time.UTC))
+	}
+	build := func(d int, passed bool) *v1.TestResult {
+		result := "FAILURE"
+		if passed {
+			result = "SUCCESS"
+		}
+		return &v1.TestResult{BuildId: day(d).String(), Passed: passed, Result: result, FinishTime: day(d)}
+	}
+	flake := build(5, false)
+	flake.FlakeFailure = true
+	running := &v1.TestResult{BuildId: "running", Result: "N/A"}
+
+	matrices_spec := &v1.MatricesSpec{Matrices: map[string]v1.MatrixSpec{
+		"nightly": {Tests: map[string][]v1.TestSpec{
+			"a": {
+				// newest first: F F P F P, plus a flake and a running build
+				{ProwName: "flaky", OldTests: []*v1.TestResult{running, build(7, false), flake,
+					build(4, true), build(3, false), build(1, true)}},
+				{ProwName: "stable", OldTests: []*v1.TestResult{build(7, true), build(6, true)}},
+			},
+			"b": {
+				{ProwName: "broken", OldTests: []*v1.TestResult{build(7, false), build(6, false), build(5, false)}},
+				{ProwName: "new"},
+			},
+		}},
+	}}
+
+	ComputeStats(matrices_spec)
+
+	flaky := matrices_spec.Matrices["nightly"].Tests["a"][0].Stats
+	if flaky.Builds != 5 || flaky.Passed != 2 || flaky.Failed != 2 || flaky.Flakes != 1 {
+		t.Errorf("unexpected counts: %+v", flaky)
+	}
+	if flaky.PassRate != 0.4 || flaky.FlakeRate != 0.2 {
+		t.Errorf("got pass rate %v and flake rate %v, expected 0.4 and 0.2", flaky.PassRate, flaky.FlakeRate)
+	}
+	if flaky.FailureStreak != 2 || flaky.Flips != 3 {
+		t.Errorf("got streak %d and %d flips, expected 2 and 3", flaky.FailureStreak, flaky.Flips)
+	}
+	// failures on the 3rd, 5th and 7th
+	if flaky.MeanTimeBetweenFailures != 48 * time.Hour {
+		t.Errorf("got MTBF %v, expected 48h", flaky.MeanTimeBetweenFailures)
+	}
+
+	group_b := matrices_spec.Stats["nightly"].Groups["b"]
+	if group_b.Builds != 3 || group_b.PassRate != 0 || group_b.FailureStreak != 3 {
+		t.Errorf("unexpected statistics of the group b: %+v", group_b)
+	}
+
+	matrix := matrices_spec.Stats["nightly"]
+	if matrix.Builds != 10 || matrix.Passed != 4 || matrix.FailureStreak != 3 || matrix.Flips != 3 {
+		t.Errorf("unexpected statistics of the matrix: %+v", matrix.TestStats)
+	}
+
+	unreliable := []string{}
+	for _, test := range matrices_spec.UnreliableTests {
+		unreliable = append(unreliable, test.ProwName)
+	}
+	if len(unreliable) != 2 || unreliable[0] != "broken" || unreliable[1] != "flaky" {
+		t.Errorf("got unreliable tests %v, expected [broken flaky]", unreliable)
+	}
+}
+
+func TestNewStatsReport(t *testing.T) {
+	day := func(d int) v1.Timestamp {
+		return v1.NewTimestamp(time.Date(2021, 3, d, 0, 0, 0, 0, time.UTC))
+	}
+	build := func(d int, passed bool) *v1.TestResult {
+		result := "FAILURE"
+		if passed {
+			result = "SUCCESS"
+		}
+		return &v1.TestResult{BuildId: day(d).String(), Passed: passed, Result: result, FinishTime: day(d)}
+	}
+
+	matrices_spec := &v1.MatricesSpec{Matrices: map[string]v1.MatrixSpec{
+		"nightly": {Tests: map[string][]v1.TestSpec{
+			"0_main": {
+				{ProwName: "stable", OldTests: []*v1.TestResult{build(3, true), build(2, true), build(1, false)}},
+			},
+			"1_release": {
+				{ProwName: "broken", OldTests: []*v1.TestResult{build(3, false), build(2, false), build(1, true)}},
+				{ProwName: "new"},
+			},
+		}},
+	}}
+
+	ComputeStats(matrices_spec)
+	report := NewStatsReport(matrices_spec)
+
+	if len(report.Matrices) != 1 {
+		t.Fatalf("got %d matrices in the report, expected 1", len(report.Matrices))
+	}
+	matrix := report.Matrices[0]
+	if matrix.Name != "nightly" || matrix.Builds != 6 || matrix.Passed != 3 || matrix.Failed != 3 ||
+		matrix.Flips != 2 || matrix.MeanTimeBetweenFailuresSeconds != 24 * 3600 {
+		t.Errorf("unexpected statistics of the matrix: %+v", matrix.TestStats)
+	}
+	if len(matrix.Groups) != 2 || matrix.Groups[0].Name != "0_main" || matrix.Groups[1].Name != "1_release" ||
+		matrix.Groups[1].Builds != 3 || matrix.Groups[1].FailureStreak != 2 {
+		t.Errorf("unexpected statistics of the groups: %+v", matrix.Groups)
+	}
+
+	// least reliable first, the test without builds last
+	if len(report.Tests) != 3 || report.Tests[0].ProwName != "broken" || report.Tests[1].ProwName != "stable" ||
+		report.Tests[2].ProwName != "new" {
+		t.Fatalf("unexpected ranking of the tests: %+v", report.Tests)
+	}
+	if broken := report.Tests[0]; broken.Matrix != "nightly" || broken.TestGroup != "1_release" ||
+		broken.Failed != 2 || broken.FailureStreak != 2 || broken.MeanTimeBetweenFailuresSeconds != 24 * 3600 {
+		t.Errorf("unexpected statistics of the broken test: %+v", broken.TestStats)
+	}
+
+	content, err := json.Marshal(report.Tests[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{`"prow_name":"broken"`, `"failure_streak":2`,
+		`"mean_time_between_failures_seconds":86400`} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("the statistics report entry does not contain %s: %s", expected, content)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package signature
+
+import (
+	"sort"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+// failureText returns the most specific description of the failure of
+// `test_result`: the first failed ansible task, the first failed JUnit
+// testcase or the FAILURE file of the test step.
+func failureText(test_result *v1.TestResult) string {
+	for _, step := range test_result.ToolboxStepsResults {
+		for _, failure := range step.FailedTasks {
+			msg := failure.Msg
+			if msg == "" {
+				msg = failure.Stderr
+			}
+			task := failure.Task
+			if failure.Role != "" {
+				task = failure.Role + " : " + task
+			}
+			return task + ": " + msg
+		}
+	}
+
+	for _, testcase := range test_result.JUnitTestCases {
+		if testcase.Status == "failed" {
+			return testcase.Name + ": " + testcase.Message
+		}
+	}
+
+	return test_result.StepFailure
+}
+
+// FromTestResult returns the failure signature of `test_result`, or an
+// empty string if it passed.
+func FromTestResult(test_result *v1.TestResult) string {
+	if test_result.Passed {
+		return ""
+	}
+
+	return Normalize(failureText(test_result))
+}
+
+func buildBefore(a, b *v1.TestResult) bool {
+	if a.FinishTime.Valid && b.FinishTime.Valid && !a.FinishTime.Time.Equal(b.FinishTime.Time) {
+		return a.FinishTime.Time.Before(b.FinishTime.Time)
+	}
+	return a.BuildId < b.BuildId
+}
+
+// ClusterFailures groups the failed builds of all the tests by failure
+// signature. The clusters are sorted by decreasing number of
+// occurrences.
+func ClusterFailures(matrices_spec *v1.MatricesSpec) []*v1.FailureCluster {
+	clusters := map[string]*v1.FailureCluster{}
+	ordered_clusters := []*v1.FailureCluster{}
+
+	matrix_names := []string{}
+	for matrix_name := range matrices_spec.Matrices {
+		matrix_names = append(matrix_names, matrix_name)
+	}
+	sort.Strings(matrix_names)
+
+	for _, matrix_name := range matrix_names {
+		test_matrix := matrices_spec.Matrices[matrix_name]
+		test_groups := []string{}
+		for test_group := range test_matrix.Tests {
+			test_groups = append(test_groups, test_group)
+		}
+		sort.Strings(test_groups)
+
+		for _, test_group := range test_groups {
+			tests := test_matrix.Tests[test_group]
+			for test_idx := range tests {
+				test := &tests[test_idx]
+				for _, test_result := range test.OldTests {
+					if test_result.FailureSignature == "" {
+						continue
+					}
+
+					cluster, found := clusters[test_result.FailureSignature]
+					if !found {
+						cluster = &v1.FailureCluster{
+							Signature: test_result.FailureSignature,
+							Id: ID(test_result.FailureSignature),
+							FirstSeen: test_result,
+							LastSeen: test_result,
+						}
+						clusters[test_result.FailureSignature] = cluster
+						ordered_clusters = append(ordered_clusters, cluster)
+					}
+
+					cluster.Occurrences += 1
+					if len(cluster.Tests) == 0 || cluster.Tests[len(cluster.Tests) - 1] != test {
+						cluster.Tests = append(cluster.Tests, test)
+					}
+					if buildBefore(test_result, cluster.FirstSeen) {
+						cluster.FirstSeen = test_result
+					}
+					if buildBefore(cluster.LastSeen, test_result) {
+						cluster.LastSeen = test_result
+					}
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(ordered_clusters, func(i, j int) bool {
+		return ordered_clusters[i].Occurrences > ordered_clusters[j].Occurrences
+	})
+
+	return ordered_clusters
+}
@@ -0,0 +1,58 @@
+package signature
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+)
+
+func TestClusterFailures(t *testing.T) {
+	day := func(d int) v1.Timestamp {
+		return v1.NewTimestamp(time.Date(2021, 3, d, 0, 0, 0, 0, time.UTC))
+	}
+	failed_task := func(pod string) []v1.ToolboxStepResult {
+		return []v1.ToolboxStepResult{{FailedTasks: []v1.TaskFailure{{
+			Role: "gpu_operator_wait_deployment", Task: "Wait for the driver",
+			Msg: "pod " + pod + " is in CrashLoopBackOff",
+		}}}}
+	}
+
+	matrices_spec := &v1.MatricesSpec{Matrices: map[string]v1.MatrixSpec{
+		"nightly": {Tests: map[string][]v1.TestSpec{
+			"4.8": {{ProwName: "job-4.8", OldTests: []*v1.TestResult{
+				{BuildId: "3", FinishTime: day(3), ToolboxStepsResults: failed_task("nvidia-driver-daemonset-zq9vl")},
+				{BuildId: "2", FinishTime: day(2), StepFailure: "cluster install failed"},
+				{BuildId: "1", FinishTime: day(1), Passed: true},
+			}}},
+			"4.9": {{ProwName: "job-4.9", OldTests: []*v1.TestResult{
+				{BuildId: "12", FinishTime: day(4), ToolboxStepsResults: failed_task("nvidia-driver-daemonset-4rx2b")},
+				{BuildId: "11", FinishTime: day(2), ToolboxStepsResults: failed_task("nvidia-driver-daemonset-xkz5p")},
+			}}},
+		}},
+	}}
+
+	for _, tests := range matrices_spec.Matrices["nightly"].Tests {
+		for _, test_result := range tests[0].OldTests {
+			test_result.FailureSignature = FromTestResult(test_result)
+		}
+	}
+
+	clusters := ClusterFailures(matrices_spec)
+	if len(clusters) != 2 {
+		t.Fatalf("got %d clusters, expected 2: %+v", len(clusters), clusters)
+	}
+
+	driver := clusters[0]
+	expected_signature := "gpu_operator_wait_deployment : Wait for the driver: pod nvidia-driver-daemonset-<POD> is in CrashLoopBackOff"
+	if driver.Signature != expected_signature || driver.Occurrences != 3 || len(driver.Tests) != 2 ||
+		driver.FirstSeen.BuildId != "11" || driver.LastSeen.BuildId != "12" {
+		t.Errorf("unexpected driver cluster: %q, %d occurrences, %d tests, first %s, last %s",
+			driver.Signature, driver.Occurrences, len(driver.Tests), driver.FirstSeen.BuildId, driver.LastSeen.BuildId)
+	}
+
+	install := clusters[1]
+	if install.Signature != "cluster install failed" || install.Occurrences != 1 || install.Tests[0].ProwName != "job-4.8" {
+		t.Errorf("unexpected install cluster: %q, %d occurrences", install.Signature, install.Occurrences)
+	}
+}
@@ -0,0 +1,72 @@
+// Package signature normalizes the failure messages into signatures,
+// so that the failures with the same root cause can be grouped.
+package signature
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxLength is the maximum length of a signature.
+const MaxLength = 512
+
+// alphabet of the random suffixes of the Kubernetes resource names,
+// without vowels nor confusable digits
+const podHashChars = "[bcdfghjklmnpqrstvwxz2456789]"
+
+var normalizers = []struct {
+	re *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?`), "<TIMESTAMP>"},
+	{regexp.MustCompile(`\b\d{4}-\d{2}-\d{2}\b`), "<DATE>"},
+	{regexp.MustCompile(`\b\d{1,2}:\d{2}:\d{2}(\.\d+)?\b`), "<TIME>"},
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), "<UUID>"},
+	{regexp.MustCompile(`\b(\d{1,3}\.){3}\d{1,3}(:\d+)?\b`), "<IP>"},
+	{regexp.MustCompile(`(?i)\b([0-9a-f]{1,4}:){7}[0-9a-f]{1,4}\b`), "<IP>"},
+	// pods of deployments (<name>-<replicaset hash>-<hash>) and of
+	// daemonsets or jobs (<name>-<hash>)
+	{regexp.MustCompile(`-` + podHashChars + `{8,10}-` + podHashChars + `{5}\b`), "-<POD>"},
+	{regexp.MustCompile(`-` + podHashChars + `{5}\b`), "-<POD>"},
+	{regexp.MustCompile(`(?i)\b0x[0-9a-f]+\b`), "<HEX>"},
+	// git commits, image digests, container ids
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{12,}\b`), "<HASH>"},
+	{regexp.MustCompile(`\b\d+\.\d+(ns|us|µs|ms|s|m|h)\b`), "<DURATION>"},
+	{regexp.MustCompile(`\b\d{4,}\b`), "<N>"},
+	{regexp.MustCompile(`\s+`), " "},
+}
+
+// Normalize removes the parts of `text` which change from one
+// occurrence of a failure to the other (timestamps, UUIDs, pod names,
+// IPs, ...).
+func Normalize(text string) string {
+	for _, normalizer := range normalizers {
+		text = normalizer.re.ReplaceAllString(text, normalizer.replacement)
+	}
+	text = strings.TrimSpace(text)
+
+	return Truncate(text, MaxLength)
+}
+
+// Truncate cuts `text` to at most `max_length` bytes, without cutting a
+// multi-byte character.
+func Truncate(text string, max_length int) string {
+	if len(text) <= max_length {
+		return text
+	}
+
+	cut := max_length
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut -= 1
+	}
+	return text[:cut]
+}
+
+// ID returns a short identifier of `signature`.
+func ID(signature string) string {
+	hash := sha1.Sum([]byte(signature))
+	return hex.EncodeToString(hash[:])[:10]
+}
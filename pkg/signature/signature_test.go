@@ -0,0 +1,58 @@
+package signature
+
+import (
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	for text, expected := range map[string]string{
+		"2021-03-10T12:04:05.123Z pod gpu-operator-7c9f8d6b5d-x7zkq is not ready":
+			"<TIMESTAMP> pod gpu-operator-<POD> is not ready",
+		"nvidia-driver-daemonset-4rx2b  in\tCrashLoopBackOff":
+			"nvidia-driver-daemonset-<POD> in CrashLoopBackOff",
+		"dial tcp 10.0.128.12:6443: connect: connection refused":
+			"dial tcp <IP>: connect: connection refused",
+		"job 3f0d7c1e-9a44-4b6b-8a1c-1f2e3d4c5b6a failed at 12:04:05 on 2021-03-10":
+			"job <UUID> failed at <TIME> on <DATE>",
+		"image quay.io/org/gpu-burn@sha256:0123456789abcdef0123456789abcdef not found":
+			"image quay.io/org/gpu-burn@sha256:<HASH> not found",
+		"Timed out after 612.35s waiting for 16384 pages":
+			"Timed out after <DURATION> waiting for <N> pages",
+		// the words and the short numbers are kept
+		"node ready check failed: 3 of 5 nodes": "node ready check failed: 3 of 5 nodes",
+	} {
+		if normalized := Normalize(text); normalized != expected {
+			t.Errorf("Normalize(%q):\n got %q\n expected %q", text, normalized, expected)
+		}
+	}
+}
+
+func TestSameSignature(t *testing.T) {
+	first := Normalize("2021-03-10 01:02:03 pod nvidia-driver-daemonset-4rx2b failed on 10.0.0.1")
+	second := Normalize("2021-03-11 04:05:06 pod nvidia-driver-daemonset-zq9vl failed on 10.0.0.2")
+
+	if first != second || ID(first) != ID(second) {
+		t.Errorf("different signatures: %q (%s) and %q (%s)", first, ID(first), second, ID(second))
+	}
+	if len(ID(first)) != 10 {
+		t.Errorf("unexpected ID length: %s", ID(first))
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		max_length int
+		expected string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"truncated text", 9, "truncated"},
+		// "é" is 2 bytes long, it is not cut in half
+		{"café au lait", 4, "caf"},
+	} {
+		if truncated := Truncate(tc.text, tc.max_length); truncated != tc.expected {
+			t.Errorf("Truncate(%q, %d): got %q, expected %q", tc.text, tc.max_length, truncated, tc.expected)
+		}
+	}
+}
@@ -6,25 +6,145 @@ import (
 	"io/ioutil"
 	"html/template"
 	"strings"
+	"time"
 	"unicode/utf8"
 	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+	"github.com/openshift-psap/ci-dashboard/pkg/signature"
 )
 
+// NearTimeoutRatio is the fraction of the Prow timeout above which a
+// build is considered to be close to timing out.
+const NearTimeoutRatio = 0.9
+
+func formatDuration(d time.Duration) string {
+	if d <= 0 {
+		return "N/A"
+	} else if d < time.Minute {
+		return d.Round(time.Second).String()
+	}
+	formatted := strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+	if strings.HasSuffix(formatted, "h0m") {
+		formatted = strings.TrimSuffix(formatted, "0m")
+	}
+	return formatted
+}
+
+// relativeTime describes how long before `now` the timestamp is, eg
+// "3h ago".
+func relativeTime(t v1.Timestamp, now time.Time) string {
+	if !t.Valid {
+		return "N/A"
+	}
+
+	elapsed := now.Sub(t.Time)
+	suffix := "ago"
+	if elapsed < 0 {
+		elapsed = -elapsed
+		suffix = "from now"
+	}
+
+	if elapsed < time.Minute {
+		return "just now"
+	} else if elapsed < time.Hour {
+		return fmt.Sprintf("%dm %s", int(elapsed.Minutes()), suffix)
+	} else if elapsed < 48 * time.Hour {
+		return fmt.Sprintf("%dh %s", int(elapsed.Hours()), suffix)
+	}
+	return fmt.Sprintf("%dd %s", int(elapsed.Hours() / 24), suffix)
+}
+
+const DefaultGenerationDateFormat = "2006-01-02 15h04"
+
+type Options struct {
+	// time of the generation, the relative times are computed from it
+	Now time.Time
+	// time zone of the dates, time.Local if nil
+	Location *time.Location
+	// layout of the dates of the builds
+	DateFormat string
+	// layout of the generation date
+	GenerationDateFormat string
+}
+
+// stepTaskFailure is a failed task, with the name of its toolbox step.
+type stepTaskFailure struct {
+	Step string
+	v1.TaskFailure
+}
+
+// regressionDescr describes the regression of a test, with the
+// versions which changed between the last good and the first bad
+// build.
+func regressionDescr(regression *v1.Regression, formatDate func(v1.Timestamp) string) string {
+	if regression == nil {
+		return ""
+	}
+
+	switch regression.Status {
+	case v1.RegressionStatusRegressed:
+		descr := fmt.Sprintf("Failing since build %s (%s, %d failed build(s)), last good build: %s (%s)",
+			regression.FirstBad.BuildId, formatDate(regression.FirstBad.FinishTime), regression.FailedBuilds,
+			regression.LastGood.BuildId, formatDate(regression.LastGood.FinishTime))
+		changes := []string{}
+		for _, change := range regression.VersionChanges {
+			changes = append(changes, fmt.Sprintf("%s %s -> %s", change.Name,
+				versionOrNA(change.LastGood), versionOrNA(change.FirstBad)))
+		}
+		if len(changes) != 0 {
+			descr += "; changed: " + strings.Join(changes, ", ")
+		}
+		return descr
+	case v1.RegressionStatusFailing:
+		return fmt.Sprintf("Failing in all the %d conclusive build(s) of the history, since build %s at least",
+			regression.FailedBuilds, regression.FirstBad.BuildId)
+	case v1.RegressionStatusPassing:
+		return "Passing"
+	}
+	return "No conclusive build in the history"
+}
+
+func versionOrNA(version string) string {
+	if version == "" {
+		return "N/A"
+	}
+	return version
+}
+
 type TemplateBase struct {
 	Spec *v1.MatricesSpec
 	Description string
 	Date string
+	GenerationTime v1.Timestamp
 }
 
-func Generate(matrixTemplate string, matrices *v1.MatricesSpec, date string) ([]byte, error) {
+func Generate(matrixTemplate string, matrices *v1.MatricesSpec, options Options) ([]byte, error) {
 	matrix_template, err := ioutil.ReadFile(matrixTemplate)
 	if err != nil {
 		return []byte{}, fmt.Errorf("Matrix template file %s cannot be read: %v", matrixTemplate, err)
 	}
 
+	if options.Location == nil {
+		options.Location = time.Local
+	}
+	if options.DateFormat == "" {
+		options.DateFormat = v1.DateFormat
+	}
+	if options.GenerationDateFormat == "" {
+		options.GenerationDateFormat = DefaultGenerationDateFormat
+	}
+
+	generation_time := v1.NewTimestamp(options.Now.In(options.Location))
 	tmpl_data := TemplateBase{
 		Spec: matrices,
-		Date: date,
+		Date: generation_time.Format(options.GenerationDateFormat),
+		GenerationTime: generation_time,
+	}
+
+	inLocation := func(t v1.Timestamp) v1.Timestamp {
+		if t.Valid {
+			t.Time = t.Time.In(options.Location)
+		}
+		return t
 	}
 
 	fmap := template.FuncMap{
@@ -64,14 +184,46 @@ func Generate(matrixTemplate string, matrices *v1.MatricesSpec, date string) ([]
 				// override test_matrix.ProwStep if ProwStep is test_spec.ProwStep is specified
 				prow_step = test.TestSpec.ProwStep
 			}
+			artifacts_base := matrix.ArtifactsURL
+			if matrix.ArtifactsBrowserURL != "" {
+				// the artifacts are not fetched from a browsable location
+				artifacts_base = matrix.ArtifactsBrowserURL
+			}
 			base := fmt.Sprintf("%s/%s/%s/artifacts/%s/%s",
-				matrix.ArtifactsURL, test.TestSpec.ProwName, test.BuildId, test.TestSpec.TestName, prow_step)
+				artifacts_base, test.TestSpec.ProwName, test.BuildId, test.TestSpec.TestName, prow_step)
 			if test.TestSpec.IsCiOperator == nil || *test.TestSpec.IsCiOperator == true {
 				return base + "/artifacts"
 			} else {
 				return base
 			}
 		},
+		"build_url": func(test *v1.TestResult) string {
+			if test == nil || test.TestSpec == nil || test.TestSpec.Matrix == nil {
+				return "INVALID"
+			}
+			return fmt.Sprintf("%s/%s/%s", test.TestSpec.Matrix.ViewerURL, test.TestSpec.ProwName, test.BuildId)
+		},
+		"signature_id": signature.ID,
+		"percent": func(ratio float64) string {
+			return fmt.Sprintf("%.0f%%", ratio * 100)
+		},
+		"matrix_stats": func(matrix_name string) *v1.MatrixStats {
+			return matrices.Stats[matrix_name]
+		},
+		"group_stats": func(matrix_name, test_group string) *v1.TestStats {
+			if matrix_stats := matrices.Stats[matrix_name]; matrix_stats != nil {
+				return matrix_stats.Groups[test_group]
+			}
+			return nil
+		},
+		"is_first_bad": func(test v1.TestSpec, test_result *v1.TestResult) bool {
+			return test.Regression != nil && test.Regression.FirstBad == test_result
+		},
+		"regression_descr": func(regression *v1.Regression) string {
+			return regressionDescr(regression, func(t v1.Timestamp) string {
+				return inLocation(t).Format(options.DateFormat)
+			})
+		},
 		"spyglass_url": func(matrix v1.MatrixSpec, prowName string, test v1.TestResult) string {
 			return fmt.Sprintf("%s/%s/%s", matrix.ViewerURL, prowName, test.BuildId)
 		},
@@ -97,25 +249,22 @@ func Generate(matrixTemplate string, matrices *v1.MatricesSpec, date string) ([]
 				return "Test failed because the operator step failed"
 			} else if status == "step_missing" {
 				return "Test failed but operator step wasn't executed"
+			} else if status == "fetch_error" {
+				return "Test results could not be fetched: " + test.FetchError
+			} else if test.Classification != nil && status == test.Classification.Category {
+				descr := fmt.Sprintf("Test failed, classified as %s by the rule '%s'",
+					strings.ReplaceAll(status, "_", " "), test.Classification.Rule)
+				if test.Classification.Description != "" {
+					descr += ": " + test.Classification.Description
+				}
+				return descr
 			} else {
 				return fmt.Sprintf("Test: %t, Step: %t (status: %s)",
 					test.Passed, test.StepPassed, status)
 			}
 		},
 		"test_status": func(test v1.TestResult) string {
-			if test.Passed {
-				return "success"
-			} else if len(test.Messages[v1.TestMessageTypeFlake]) != 0 {
-				return "known_flake"
-			} else if !test.StepExecuted {
-				return "step_missing"
-			} else if test.StepPassed {
-				return "step_success"
-			} else if !test.StepPassed {
-				return "step_failed"
-			} else {
-				return "parsing_error"
-			}
+			return test.Status()
 		},
 		"test_messages": func(message_type string, test v1.TestResult) map[string]string {
 			if message_type == "flake" {
@@ -132,6 +281,64 @@ func Generate(matrixTemplate string, matrices *v1.MatricesSpec, date string) ([]
 		"test_message_types": func() []string {
 			return []string{"flake", "info", "warning", "error"}
 		},
+		"duration": formatDuration,
+		"time_of_day": func(t v1.Timestamp) string {
+			return inLocation(t).Format("15:04")
+		},
+		"format_date": func(t v1.Timestamp) string {
+			return inLocation(t).Format(options.DateFormat)
+		},
+		"format_time": func(layout string, t v1.Timestamp) string {
+			return inLocation(t).Format(layout)
+		},
+		"relative_time": func(t v1.Timestamp) string {
+			return relativeTime(t, options.Now)
+		},
+		"timeout_usage": func(test v1.TestResult) int {
+			if test.Timeout <= 0 || test.Duration <= 0 {
+				return -1
+			}
+			return int(100 * test.Duration / test.Timeout)
+		},
+		"near_timeout": func(test v1.TestResult) bool {
+			return test.Timeout > 0 && float64(test.Duration) >= NearTimeoutRatio * float64(test.Timeout)
+		},
+		"failed_tasks": func(test v1.TestResult) []stepTaskFailure {
+			failures := []stepTaskFailure{}
+			for _, step := range test.ToolboxStepsResults {
+				for _, failure := range step.FailedTasks {
+					failures = append(failures, stepTaskFailure{Step: step.Name, TaskFailure: failure})
+				}
+			}
+			return failures
+		},
+		"first_line": func(s string) string {
+			s = strings.TrimSpace(s)
+			if idx := strings.Index(s, "\n"); idx != -1 {
+				return s[:idx] + " [...]"
+			}
+			return s
+		},
+		"junit_testcases": func(status string, test v1.TestResult) []v1.JUnitTestCase {
+			testcases := []v1.JUnitTestCase{}
+			for _, testcase := range test.JUnitTestCases {
+				if status == "" || testcase.Status == status {
+					testcases = append(testcases, testcase)
+				}
+			}
+			return testcases
+		},
+		"junit_summary": func(test v1.TestResult) string {
+			if len(test.JUnitTestCases) == 0 {
+				return ""
+			}
+			counts := map[string]int{}
+			for _, testcase := range test.JUnitTestCases {
+				counts[testcase.Status] += 1
+			}
+			return fmt.Sprintf("JUnit: %d passed, %d failed, %d skipped",
+				counts["passed"], counts["failed"], counts["skipped"])
+		},
     }
 
 	tmpl := template.Must(template.New("runtime").Funcs(fmap).Parse(string(matrix_template)))
@@ -0,0 +1,384 @@
+package matrix
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/openshift-psap/ci-dashboard/api/matrix/v1"
+	"github.com/openshift-psap/ci-dashboard/pkg/signature"
+)
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Date(2021, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		timestamp v1.Timestamp
+		expected string
+	}{
+		{v1.Timestamp{}, "N/A"},
+		{v1.NewTimestamp(now.Add(-30 * time.Second)), "just now"},
+		{v1.NewTimestamp(now.Add(-42 * time.Minute)), "42m ago"},
+		{v1.NewTimestamp(now.Add(-3 * time.Hour)), "3h ago"},
+		{v1.NewTimestamp(now.Add(-50 * time.Hour)), "2d ago"},
+		{v1.NewTimestamp(now.Add(2 * time.Hour)), "2h from now"},
+	} {
+		if relative := relativeTime(tc.timestamp, now); relative != tc.expected {
+			t.Errorf("relativeTime(%v): got %q, expected %q", tc.timestamp, relative, tc.expected)
+		}
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	for duration, expected := range map[time.Duration]string{
+		0: "N/A",
+		42 * time.Second: "42s",
+		5 * time.Minute: "5m",
+		220 * time.Minute: "3h40m",
+		4 * time.Hour: "4h",
+	} {
+		if formatted := formatDuration(duration); formatted != expected {
+			t.Errorf("formatDuration(%v): got %q, expected %q", duration, formatted, expected)
+		}
+	}
+}
+
+// testTemplateFile is the template of the daily matrix command.
+const testTemplateFile = "../../../templates/daily_matrix.tmpl.html"
+
+// testMailTemplateFile is the template of the daily matrix mail.
+const testMailTemplateFile = "../../../templates/daily_matrix.mail.tmpl.md"
+
+var testNow = time.Date(2020, 9, 16, 12, 0, 0, 0, time.UTC)
+
+// newTestMatrices returns a matrix with a single test, whose builds are
+// `test_results`, newest first.
+func newTestMatrices(test_results ...*v1.TestResult) *v1.MatricesSpec {
+	matrices_spec := &v1.MatricesSpec{
+		TestHistory: 5,
+		Matrices: map[string]v1.MatrixSpec{
+			"nightly": {
+				Name: "nightly",
+				ViewerURL: "https://prow.example.com/view/gs/test-bucket/logs",
+				ArtifactsURL: "https://gcsweb.example.com/gcs/test-bucket/logs",
+				ProwStep: "test-step",
+				Tests: map[string][]v1.TestSpec{
+					"0_main|Main branch": {{
+						Branch: "main",
+						TestName: "e2e",
+						ProwName: "periodic-ci-org-repo-main-e2e",
+						TestGroup: "0_main|Main branch",
+					}},
+				},
+			},
+		},
+	}
+
+	test_matrix := matrices_spec.Matrices["nightly"]
+	test := &test_matrix.Tests["0_main|Main branch"][0]
+	test.Matrix = &test_matrix
+	for _, test_result := range test_results {
+		test_result.TestSpec = test
+		if test_result.Messages == nil {
+			test_result.Messages = map[v1.TestMessageType]map[string]string{}
+		}
+	}
+	test.OldTests = test_results
+
+	return matrices_spec
+}
+
+// generate renders the daily matrix template in UTC.
+func generate(t *testing.T, matrices_spec *v1.MatricesSpec) string {
+	return generateTemplate(t, testTemplateFile, matrices_spec)
+}
+
+// generateTemplate renders `template_file` in UTC.
+func generateTemplate(t *testing.T, template_file string, matrices_spec *v1.MatricesSpec) string {
+	html, err := Generate(template_file, matrices_spec, Options{
+		Now: testNow,
+		Location: time.UTC,
+		DateFormat: "02/01/2006 15:04 MST",
+	})
+	if err != nil {
+		t.Fatalf("failed to generate the matrix: %v", err)
+	}
+
+	return string(html)
+}
+
+func checkContains(t *testing.T, html string, expected ...string) {
+	for _, snippet := range expected {
+		if !strings.Contains(html, snippet) {
+			t.Errorf("generated matrix does not contain %q", snippet)
+		}
+	}
+}
+
+func TestGenerateJUnit(t *testing.T) {
+	failed := &v1.TestResult{
+		BuildId: "1002",
+		Result: "FAILURE",
+		StepExecuted: true,
+		StepResult: "FAILURE",
+		JUnitTestCases: []v1.JUnitTestCase{
+			{Name: "deploys the operator", Status: "passed"},
+			{Name: "runs the workload", Status: "failed", Message: "workload pod failed"},
+		},
+	}
+
+	checkContains(t, generate(t, newTestMatrices(failed)),
+		"JUnit: 1 passed, 1 failed, 0 skipped",
+		`<div class="junit_failures" title="workload pod failed">runs the workload</div>`)
+}
+
+func TestGenerateDurations(t *testing.T) {
+	finish_time := time.Unix(1600172800, 0)
+	slow := &v1.TestResult{
+		BuildId: "1002",
+		Result: "FAILURE",
+		StartTime: v1.NewTimestamp(finish_time.Add(-220 * time.Minute)),
+		FinishTime: v1.NewTimestamp(finish_time),
+		Duration: 220 * time.Minute,
+		QueueTime: 10 * time.Minute,
+		Timeout: 4 * time.Hour,
+	}
+	fast := &v1.TestResult{
+		BuildId: "1003",
+		Result: "SUCCESS",
+		Passed: true,
+		FinishTime: v1.NewTimestamp(finish_time.Add(24 * time.Hour)),
+		Duration: 30 * time.Minute,
+		Timeout: 4 * time.Hour,
+	}
+
+	checkContains(t, generate(t, newTestMatrices(slow)),
+		`title="Started at 08:46, queued for 10m, 91% of the 4h timeout">(took 3h40m)</span>`,
+		"Close to the Prow timeout",
+		"took 3h40m (91% of the timeout)")
+
+	html := generate(t, newTestMatrices(fast, slow))
+	checkContains(t, html, "(took 30m)", "took 3h40m (91% of the timeout)")
+	if strings.Contains(html, "Close to the Prow timeout") {
+		t.Errorf("the last build is reported close to the timeout")
+	}
+}
+
+func TestGenerateDates(t *testing.T) {
+	finished := &v1.TestResult{
+		BuildId: "1002",
+		Result: "SUCCESS",
+		Passed: true,
+		FinishTime: v1.NewTimestamp(time.Unix(1600172800, 0)),
+	}
+	running := &v1.TestResult{BuildId: "1003", Result: "N/A"}
+
+	checkContains(t, generate(t, newTestMatrices(running, finished)),
+		"15/09/2020 12:26 UTC (23h ago)",
+		"N/A (N/A)",
+		"Document generated on 2020-09-16 12h00.")
+
+	html, err := Generate(testTemplateFile, newTestMatrices(finished), Options{
+		Now: testNow,
+		Location: time.FixedZone("CEST", 2 * 60 * 60),
+		DateFormat: "02/01/2006 15:04 MST",
+		GenerationDateFormat: "Jan 2, 15:04 MST",
+	})
+	if err != nil {
+		t.Fatalf("failed to generate the matrix: %v", err)
+	}
+	checkContains(t, string(html),
+		"15/09/2020 14:26 CEST (23h ago)",
+		"Document generated on Sep 16, 14:00 CEST.")
+}
+
+func TestGenerateParseError(t *testing.T) {
+	passed := &v1.TestResult{
+		BuildId: "1000",
+		Result: "SUCCESS",
+		Passed: true,
+		ToolboxStepsResults: []v1.ToolboxStepResult{
+			{Name: "000__cluster__capture_environment", Ok: 5},
+			{Name: "001__cluster__set_scale", ParseError: "truncated log after 0 entries"},
+		},
+	}
+
+	checkContains(t, generate(t, newTestMatrices(passed)),
+		"log of 001__cluster__set_scale not parsed: truncated log after 0 entries")
+}
+
+func TestGenerateFailedTasks(t *testing.T) {
+	failed := &v1.TestResult{
+		BuildId: "1002",
+		Result: "FAILURE",
+		ToolboxStepsResults: []v1.ToolboxStepResult{{
+			Name: "000__cluster__capture_environment",
+			Ok: 2,
+			Failures: 1,
+			FailedTasks: []v1.TaskFailure{{
+				Role: "cluster_capture_environment",
+				Task: "Get the nodes",
+				Host: "localhost",
+				Msg: "non-zero return code\nmore details",
+				Stderr: "error: You must be logged in to the server",
+			}},
+		}},
+	}
+
+	checkContains(t, generate(t, newTestMatrices(failed)),
+		"<summary>Why it failed</summary>",
+		"<b>000__cluster__capture_environment</b>: cluster_capture_environment : Get the nodes (on localhost)",
+		"<pre>non-zero return code\nmore details</pre>",
+		"<pre>error: You must be logged in to the server</pre>",
+		"failed task: 000__cluster__capture_environment: Get the nodes (on localhost): non-zero return code [...]")
+
+	passed := &v1.TestResult{BuildId: "1003", Result: "SUCCESS", Passed: true}
+	if html := generate(t, newTestMatrices(passed)); strings.Contains(html, "Why it failed") {
+		t.Errorf("the failed tasks of a passed build are shown")
+	}
+
+	// the failed tasks of the older builds are shown too
+	html := generate(t, newTestMatrices(passed, failed))
+	checkContains(t, html,
+		"<summary>Why #1002 failed</summary>",
+		"<b>000__cluster__capture_environment</b>: cluster_capture_environment : Get the nodes (on localhost)")
+	if strings.Contains(html, "Why it failed") {
+		t.Errorf("the failed tasks of the older build are shown as the ones of the last build")
+	}
+
+	checkContains(t, generateTemplate(t, testMailTemplateFile, newTestMatrices(passed, failed)),
+		"failed task of #1002: Get the nodes (on localhost): non-zero return code [...]")
+}
+
+func TestGenerateClassification(t *testing.T) {
+	failed := &v1.TestResult{
+		BuildId: "1002",
+		Result: "FAILURE",
+		Classification: &v1.FailureClassification{
+			Rule: "cluster-login",
+			Category: v1.FailureCategoryInfraFlake,
+			Ticket: "https://issues.example.com/browse/CI-42",
+			Description: "the cluster was not reachable",
+		},
+	}
+
+	checkContains(t, generate(t, newTestMatrices(failed)),
+		"old_tests old_test_infra_flake",
+		"Test failed, classified as infra flake by the rule &#39;cluster-login&#39;: the cluster was not reachable",
+		`<a href="https://issues.example.com/browse/CI-42" title="Ticket of the 'cluster-login' failure">`)
+}
+
+func TestGenerateFailureClusters(t *testing.T) {
+	first := &v1.TestResult{
+		BuildId: "1001",
+		Result: "FAILURE",
+		FinishTime: v1.NewTimestamp(time.Unix(1600086400, 0)),
+		FailureSignature: "step failed",
+	}
+	last := &v1.TestResult{
+		BuildId: "1002",
+		Result: "FAILURE",
+		FinishTime: v1.NewTimestamp(time.Unix(1600172800, 0)),
+		FailureSignature: "step failed",
+	}
+	matrices_spec := newTestMatrices(last, first)
+	matrices_spec.FailureClusters = []*v1.FailureCluster{{
+		Signature: "step failed",
+		Id: signature.ID("step failed"),
+		Tests: []*v1.TestSpec{last.TestSpec},
+		FirstSeen: first,
+		LastSeen: last,
+		Occurrences: 2,
+	}}
+
+	checkContains(t, generate(t, matrices_spec),
+		`<table id="failure_signatures">`,
+		fmt.Sprintf(`<tr id="signature-%s">`, signature.ID("step failed")),
+		"<td>2</td>\n                      <td><pre class=\"failure_signature\">step failed</pre></td>",
+		"<div>nightly: Main branch / periodic-ci-org-repo-main-e2e</div>",
+		"14/09/2020 12:26 UTC</a> (1001)",
+		"15/09/2020 12:26 UTC</a> (1002)",
+		"signature: " + signature.ID("step failed"))
+}
+
+func TestGenerateRegressions(t *testing.T) {
+	build := func(build_id string, day int, passed bool, operator_version string) *v1.TestResult {
+		result := "FAILURE"
+		if passed {
+			result = "SUCCESS"
+		}
+		return &v1.TestResult{BuildId: build_id, Passed: passed, Result: result, OperatorVersion: operator_version,
+			FinishTime: v1.NewTimestamp(time.Date(2020, 9, day, 12, 26, 0, 0, time.UTC))}
+	}
+	last_good := build("2000", 13, true, "1.7.0")
+	first_bad := build("2001", 14, false, "1.7.1")
+	matrices_spec := newTestMatrices(build("2002", 15, false, "1.7.1"), first_bad, last_good)
+
+	html := generate(t, matrices_spec)
+	if strings.Contains(html, `<table id="regressions">`) || strings.Contains(html, " first_bad") {
+		t.Errorf("the matrix contains regressions before their detection")
+	}
+
+	test := last_good.TestSpec
+	test.Regression = &v1.Regression{
+		Test: test,
+		Status: v1.RegressionStatusRegressed,
+		LastGood: last_good,
+		FirstBad: first_bad,
+		FailedBuilds: 2,
+		VersionChanges: []v1.VersionChange{{Name: "operator", LastGood: "1.7.0", FirstBad: "1.7.1"}},
+	}
+	matrices_spec.Regressions = []*v1.Regression{test.Regression}
+
+	descr := "Failing since build 2001 (14/09/2020 12:26 UTC, 2 failed build(s)), " +
+		"last good build: 2000 (13/09/2020 12:26 UTC); changed: operator 1.7.0 -&gt; 1.7.1"
+	checkContains(t, generate(t, matrices_spec),
+		`<table id="regressions">`,
+		fmt.Sprintf(`<tr id="regression-periodic-ci-org-repo-main-e2e" title="%s">`, descr),
+		fmt.Sprintf(`<a href="#regression-periodic-ci-org-repo-main-e2e"><i class="material-icons state failure" title="%s">`, descr),
+		"<td>nightly: Main branch / periodic-ci-org-repo-main-e2e</td>",
+		"<div>operator: 1.7.0 &rarr; 1.7.1</div>",
+		"old_tests old_test_step_missing first_bad")
+}
+
+func TestGenerateStats(t *testing.T) {
+	failed := &v1.TestResult{BuildId: "1002", Result: "FAILURE"}
+	matrices_spec := newTestMatrices(failed)
+
+	if html := generate(t, matrices_spec); strings.Contains(html, `<table id="reliability">`) ||
+		strings.Contains(html, "pass rate:") {
+		t.Errorf("the matrix contains statistics before their computation")
+	}
+
+	test := failed.TestSpec
+	test.Stats = &v1.TestStats{Builds: 4, Passed: 1, Failed: 1, Flakes: 2, PassRate: 0.25, FlakeRate: 0.5,
+		FailureStreak: 2, Flips: 2, MeanTimeBetweenFailures: 36 * time.Hour}
+	matrices_spec.Stats = map[string]*v1.MatrixStats{"nightly": {
+		TestStats: v1.TestStats{Builds: 7, Passed: 3, Failed: 4, PassRate: 3.0 / 7, Flips: 3,
+			MeanTimeBetweenFailures: 18 * time.Hour},
+		Groups: map[string]*v1.TestStats{"0_main|Main branch": {Builds: 3, Passed: 1, Failed: 2,
+			PassRate: 1.0 / 3}},
+	}}
+	matrices_spec.UnreliableTests = []*v1.TestSpec{test}
+
+	checkContains(t, generate(t, matrices_spec),
+		`title="1 passed, 2 failed and 0 flaky builds">pass rate: 33%, flake rate: 0%</th>`,
+		`title="1 passed, 1 failed and 2 flaky builds, a failure every 36h">`+
+			"pass rate: 25%, flakes: 50%, flips: 2, failing for 2 build(s)</div>",
+		`<table id="reliability">`,
+		"<td><b>nightly</b> (all the tests)</td>\n                      <td>43%</td>",
+		"<td>nightly: Main branch / periodic-ci-org-repo-main-e2e</td>\n                      <td>25%</td>")
+}
+
+func TestGenerateHistoryStats(t *testing.T) {
+	failed := &v1.TestResult{BuildId: "1002", Result: "FAILURE"}
+	matrices_spec := newTestMatrices(failed)
+	failed.TestSpec.HistoryStats = &v1.TestStats{Builds: 4, Passed: 1, Failed: 1, Flakes: 2,
+		PassRate: 0.25, FlakeRate: 0.5}
+	matrices_spec.HistorySince = v1.NewTimestamp(time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC))
+
+	checkContains(t, generate(t, matrices_spec),
+		`title="1 passed, 1 failed and 2 flaky builds recorded in the history">`+
+			"since 01/09/2020 00:00 UTC: pass rate: 25%, flakes: 50% over 4 builds</div>")
+}